@@ -0,0 +1,514 @@
+// Package client talks to an e-fis cluster over its rest api.
+//
+// It fetches the ring from the servers and sends every key straight to its owner,
+// hashing keys onto the ring exactly like the servers do. Any server can answer any key,
+// so a stale ring costs a hop, not an error.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golrice/e-fis/internal/api"
+	"github.com/golrice/e-fis/internal/auth"
+	"github.com/golrice/e-fis/internal/consistenthash"
+	"github.com/golrice/e-fis/internal/retry"
+)
+
+const (
+	defaultTimeout         = 2 * time.Second
+	defaultMaxRetries      = 2
+	defaultBackoffBase     = 20 * time.Millisecond
+	defaultBackoffMax      = 500 * time.Millisecond
+	defaultMaxIdleConns    = 16
+	defaultRefreshInterval = 30 * time.Second
+	maxParallelGets        = 16
+)
+
+var (
+	ErrNotFound = errors.New("client: not found")
+	ErrClosed   = errors.New("client: closed")
+)
+
+// error is a reply of a server other than 2xx
+type Error struct {
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("client: server returned %d: %s", e.Status, e.Message)
+}
+
+// only overload and gateway errors are worth another try
+func (e *Error) Temporary() bool {
+	return retry.Temporary(e.Status)
+}
+
+type Options struct {
+	// base urls of some servers, e.g. http://localhost:8001, the ring is fetched from them
+	Servers []string
+	// use this client as is, Timeout and MaxIdleConnsPerHost are ignored then
+	HTTPClient *http.Client
+	// a single attempt of a request
+	Timeout time.Duration
+	// idle keep-alive connections kept to each server
+	MaxIdleConnsPerHost int
+	// retries after the first attempt, each goes to the next server on the ring
+	// 0 means 2, a negative number disables retries
+	MaxRetries int
+	// the backoff before retry n is a random duration in [0, min(BackoffMax, BackoffBase * 2^n))
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+	// how often the ring is fetched anyway, a negative interval only refreshes on changes
+	RefreshInterval time.Duration
+	// sign the requests with this key of -auth-keys, the servers only answer signed ones then
+	KeyID  string
+	Secret string
+}
+
+func (o *Options) fill() {
+	if o.Timeout <= 0 {
+		o.Timeout = defaultTimeout
+	}
+	if o.MaxIdleConnsPerHost <= 0 {
+		o.MaxIdleConnsPerHost = defaultMaxIdleConns
+	}
+	if o.MaxRetries == 0 {
+		o.MaxRetries = defaultMaxRetries
+	} else if o.MaxRetries < 0 {
+		o.MaxRetries = 0
+	}
+	if o.BackoffBase <= 0 {
+		o.BackoffBase = defaultBackoffBase
+	}
+	if o.BackoffMax <= 0 {
+		o.BackoffMax = defaultBackoffMax
+	}
+	if o.RefreshInterval == 0 {
+		o.RefreshInterval = defaultRefreshInterval
+	}
+}
+
+// cluster is the ring as a server sees it
+type Cluster = api.ClusterInfo
+
+type Client struct {
+	opts  Options
+	http  *http.Client
+	seeds []string
+
+	mu      sync.RWMutex
+	cluster *Cluster
+	ring    *consistenthash.DHTMap
+
+	// a single refresh at a time
+	refreshing sync.Mutex
+	stop       chan struct{}
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+// new fetches the ring from the servers, the client still works if none answers,
+// it sends everything to the servers given then
+func New(opts Options) (*Client, error) {
+	if len(opts.Servers) == 0 {
+		return nil, errors.New("client: need at least one server")
+	}
+	opts.fill()
+
+	hc := opts.HTTPClient
+	if hc == nil {
+		dialer := &net.Dialer{Timeout: opts.Timeout, KeepAlive: 30 * time.Second}
+		hc = &http.Client{
+			Transport: &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				DialContext:         dialer.DialContext,
+				MaxIdleConns:        opts.MaxIdleConnsPerHost * 8,
+				MaxIdleConnsPerHost: opts.MaxIdleConnsPerHost,
+				IdleConnTimeout:     90 * time.Second,
+			},
+		}
+	}
+	if opts.KeyID != "" {
+		signed := *hc
+		signed.Transport = auth.NewTransport(auth.NewKeyRing(opts.KeyID, []byte(opts.Secret)), hc.Transport)
+		hc = &signed
+	}
+
+	c := &Client{
+		opts:  opts,
+		http:  hc,
+		seeds: append([]string(nil), opts.Servers...),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+	defer cancel()
+	c.Refresh(ctx)
+
+	go c.refreshLoop()
+
+	return c, nil
+}
+
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.stop)
+		<-c.done
+	})
+	return nil
+}
+
+func (c *Client) refreshLoop() {
+	defer close(c.done)
+
+	if c.opts.RefreshInterval < 0 {
+		<-c.stop
+		return
+	}
+
+	ticker := time.NewTicker(c.opts.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), c.opts.Timeout)
+			c.Refresh(ctx)
+			cancel()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// the ring the client routes with, nil before the first successful refresh
+func (c *Client) Cluster() *Cluster {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.cluster
+}
+
+// refresh asks the known servers for the ring, the first answer wins
+func (c *Client) Refresh(ctx context.Context) error {
+	c.refreshing.Lock()
+	defer c.refreshing.Unlock()
+
+	var lastErr error
+	for _, server := range c.servers() {
+		info, err := c.fetchCluster(ctx, server)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		ring := consistenthash.New(info.Replicas, nil)
+		ring.Add(info.Ring...)
+
+		c.mu.Lock()
+		c.cluster = info
+		c.ring = ring
+		c.mu.Unlock()
+
+		return nil
+	}
+
+	return lastErr
+}
+
+// the members we know of, then the seeds
+func (c *Client) servers() []string {
+	seen := map[string]bool{}
+	var out []string
+	add := func(s string) {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+
+	if cluster := c.Cluster(); cluster != nil {
+		for _, m := range cluster.Ring {
+			add(m)
+		}
+	}
+	for _, s := range c.seeds {
+		add(s)
+	}
+
+	return out
+}
+
+func (c *Client) fetchCluster(ctx context.Context, server string) (*Cluster, error) {
+	body, _, err := c.once(ctx, http.MethodGet, server+api.ClusterPath, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &Cluster{}
+	if err := json.Unmarshal(body, info); err != nil {
+		return nil, err
+	}
+	if len(info.Ring) == 0 || info.Replicas <= 0 {
+		return nil, fmt.Errorf("client: %s has no ring", server)
+	}
+
+	return info, nil
+}
+
+// the servers to try for a key, the owner first
+func (c *Client) route(key string) []string {
+	c.mu.RLock()
+	ring := c.ring
+	var n int
+	if c.cluster != nil {
+		n = len(c.cluster.Ring)
+	}
+	c.mu.RUnlock()
+
+	if ring == nil {
+		return c.seeds
+	}
+
+	return ring.GetN(key, n)
+}
+
+// a server with another ring answered, fetch it in the background
+func (c *Client) noticeVersion(version string) {
+	cluster := c.Cluster()
+	if version == "" || (cluster != nil && cluster.Version == version) {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), c.opts.Timeout)
+		defer cancel()
+		c.Refresh(ctx)
+	}()
+}
+
+func (c *Client) keyURL(server, namespace, key string) string {
+	return server + api.Prefix + url.PathEscape(namespace) + "/" + url.PathEscape(key)
+}
+
+// do sends the request for key to its owner, retries go to the next servers on the ring
+func (c *Client) do(ctx context.Context, method, namespace, key, query string, body []byte) ([]byte, error) {
+	select {
+	case <-c.stop:
+		return nil, ErrClosed
+	default:
+	}
+
+	servers := c.route(key)
+	if len(servers) == 0 {
+		return nil, errors.New("client: no server to ask")
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= c.opts.MaxRetries; attempt++ {
+		if attempt > 0 {
+			timer := time.NewTimer(c.backoff(attempt - 1))
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, ctx.Err()
+			}
+		}
+
+		target := c.keyURL(servers[attempt%len(servers)], namespace, key) + query
+		b, header, err := c.once(ctx, method, target, body)
+		if header != nil {
+			c.noticeVersion(header.Get(api.HeaderRingVersion))
+		}
+		if err == nil {
+			return b, nil
+		}
+		lastErr = err
+
+		if !retryable(err) || ctx.Err() != nil {
+			break
+		}
+	}
+
+	return nil, lastErr
+}
+
+func (c *Client) once(ctx context.Context, method, target string, body []byte) ([]byte, http.Header, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.opts.Timeout)
+	defer cancel()
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, res.Header, err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		var e struct {
+			Error string `json:"error"`
+		}
+		json.Unmarshal(b, &e)
+		if res.StatusCode == http.StatusNotFound && e.Error != "" && !isNamespaceError(e.Error) {
+			return nil, res.Header, ErrNotFound
+		}
+		return nil, res.Header, &Error{Status: res.StatusCode, Message: e.Error}
+	}
+
+	return b, res.Header, nil
+}
+
+// transport errors and overloaded servers are retried, answers are not
+func retryable(err error) bool {
+	if errors.Is(err, ErrNotFound) {
+		return false
+	}
+
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Temporary()
+	}
+
+	return true
+}
+
+// a missing namespace is a mistake of the caller, not a missing key
+func isNamespaceError(msg string) bool {
+	return strings.HasPrefix(msg, "no such namespace")
+}
+
+func (c *Client) backoff(n int) time.Duration {
+	return retry.Backoff(n, c.opts.BackoffBase, c.opts.BackoffMax)
+}
+
+func (c *Client) Get(ctx context.Context, namespace, key string) ([]byte, error) {
+	return c.do(ctx, http.MethodGet, namespace, key, "", nil)
+}
+
+// set stores the value, a ttl <= 0 never expires
+func (c *Client) Set(ctx context.Context, namespace, key string, value []byte, ttl time.Duration) error {
+	query := ""
+	if ttl > 0 {
+		query = "?ttl=" + strconv.FormatInt(ttl.Milliseconds(), 10) + "ms"
+	}
+	if value == nil {
+		value = []byte{}
+	}
+
+	_, err := c.do(ctx, http.MethodPut, namespace, key, query, value)
+	return err
+}
+
+func (c *Client) Delete(ctx context.Context, namespace, key string) error {
+	_, err := c.do(ctx, http.MethodDelete, namespace, key, "", nil)
+	return err
+}
+
+// mget reads the keys in parallel, missing keys are left out of the result
+// the first error other than a missing key is returned
+func (c *Client) MGet(ctx context.Context, namespace string, keys ...string) (map[string][]byte, error) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var firstErr error
+	out := make(map[string][]byte, len(keys))
+	sem := make(chan struct{}, maxParallelGets)
+
+	for _, key := range keys {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(key string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			v, err := c.Get(ctx, namespace, key)
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				out[key] = v
+			case errors.Is(err, ErrNotFound):
+			case firstErr == nil:
+				firstErr = err
+			}
+		}(key)
+	}
+	wg.Wait()
+
+	return out, firstErr
+}
+
+// owner is the server the client sends the key to, empty if no ring is known
+func (c *Client) Owner(key string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.ring == nil {
+		return ""
+	}
+	return c.ring.Get(key)
+}
+
+// share is the fraction of the keys every server owns
+func (c *Client) Share() map[string]float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.ring == nil {
+		return nil
+	}
+	return c.ring.Share()
+}
+
+// stats collects the counters of every server on the ring, servers which fail are left out
+// the first error is returned along with the rest
+func (c *Client) Stats(ctx context.Context) (map[string]map[string]any, error) {
+	out := make(map[string]map[string]any)
+	var firstErr error
+
+	for _, server := range c.servers() {
+		body, _, err := c.once(ctx, http.MethodGet, server+api.StatsPath, nil)
+		if err == nil {
+			var stats map[string]any
+			if err = json.Unmarshal(body, &stats); err == nil {
+				out[server] = stats
+				continue
+			}
+		}
+		if firstErr == nil {
+			firstErr = fmt.Errorf("%s: %w", server, err)
+		}
+	}
+
+	return out, firstErr
+}
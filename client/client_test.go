@@ -0,0 +1,264 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golrice/e-fis/internal/api"
+	"github.com/golrice/e-fis/internal/auth"
+	"github.com/golrice/e-fis/internal/cache"
+	"github.com/golrice/e-fis/internal/consistenthash"
+)
+
+// a cluster of api servers which share the view of the ring but not their caches,
+// so a value is only found where the client stored it
+type testCluster struct {
+	mu      sync.Mutex
+	ring    []string
+	servers []*httptest.Server
+	graphs  map[string]*cache.Graph
+	hits    map[string]*atomic.Int32
+}
+
+func newTestCluster(t *testing.T, n int) *testCluster {
+	t.Helper()
+
+	tc := &testCluster{graphs: map[string]*cache.Graph{}, hits: map[string]*atomic.Int32{}}
+	for i := 0; i < n; i++ {
+		graph := cache.DefaultGraph()
+		graph.AddNode(cache.NewNode("kv", 2<<10, func(key string) ([]byte, error) {
+			return nil, fmt.Errorf("%s: %w", key, cache.ErrNotFound)
+		}))
+
+		hits := &atomic.Int32{}
+		var self string
+		h := api.NewHandler(graph, api.Options{
+			Cluster: func() api.ClusterInfo {
+				tc.mu.Lock()
+				defer tc.mu.Unlock()
+				return api.ClusterInfo{Self: self, Ring: append([]string(nil), tc.ring...), Replicas: 50}
+			},
+		})
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != api.ClusterPath {
+				hits.Add(1)
+			}
+			h.ServeHTTP(w, r)
+		}))
+		t.Cleanup(srv.Close)
+
+		self = srv.URL
+		tc.servers = append(tc.servers, srv)
+		tc.graphs[srv.URL] = graph
+		tc.hits[srv.URL] = hits
+		tc.ring = append(tc.ring, srv.URL)
+	}
+
+	return tc
+}
+
+func (tc *testCluster) setRing(ring ...string) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.ring = ring
+}
+
+func (tc *testCluster) owner(key string) string {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	m := consistenthash.New(50, nil)
+	m.Add(tc.ring...)
+	return m.Get(key)
+}
+
+func TestClient_RoutesToOwner(t *testing.T) {
+	tc := newTestCluster(t, 3)
+	c, err := New(Options{Servers: []string{tc.servers[0].URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if cluster := c.Cluster(); cluster == nil || len(cluster.Ring) != 3 {
+		t.Fatalf("the ring should be fetched, got %+v", cluster)
+	}
+
+	// enough keys that every server of the ring owns some
+	var keys []string
+	owners := map[string]bool{}
+	for i := 0; i < 30 || len(owners) < len(tc.servers); i++ {
+		key := "key" + strconv.Itoa(i)
+		keys = append(keys, key)
+		owners[tc.owner(key)] = true
+	}
+
+	ctx := context.Background()
+	for i, key := range keys {
+		if err := c.Set(ctx, "kv", key, []byte("v"+strconv.Itoa(i)), 0); err != nil {
+			t.Fatal(err)
+		}
+
+		// it must have landed at the owner and nowhere else
+		node, _ := cache.GetNode(tc.graphs[tc.owner(key)], "kv")
+		if v, ok := node.Peek(key); !ok || v.String() != "v"+strconv.Itoa(i) {
+			t.Fatalf("%s is not at its owner", key)
+		}
+	}
+
+	for i, key := range keys {
+		v, err := c.Get(ctx, "kv", key)
+		if err != nil || string(v) != "v"+strconv.Itoa(i) {
+			t.Fatalf("get %s: %q, %v", key, v, err)
+		}
+	}
+	for _, srv := range tc.servers {
+		if tc.hits[srv.URL].Load() == 0 {
+			t.Fatalf("%s got no request, keys should be spread", srv.URL)
+		}
+	}
+
+	values, err := c.MGet(ctx, "kv", "key1", "key2", "missing")
+	if err != nil || len(values) != 2 || string(values["key2"]) != "v2" {
+		t.Fatalf("mget: %v, %v", values, err)
+	}
+
+	if err := c.Delete(ctx, "kv", "key1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, "kv", "key1"); err != ErrNotFound {
+		t.Fatalf("a deleted key: got %v", err)
+	}
+
+	c.Set(ctx, "kv", "ttl", []byte("x"), time.Hour)
+	node, _ := cache.GetNode(tc.graphs[tc.owner("ttl")], "kv")
+	if v, _ := node.Peek("ttl"); v.Expire().IsZero() {
+		t.Fatal("the ttl should be sent along")
+	}
+
+	if _, err := c.Get(ctx, "nope", "a"); err == ErrNotFound || err == nil {
+		t.Fatalf("a missing namespace is not a missing key, got %v", err)
+	}
+}
+
+func TestClient_RefreshAndRetry(t *testing.T) {
+	tc := newTestCluster(t, 3)
+	c, err := New(Options{Servers: []string{tc.servers[0].URL}, BackoffBase: time.Millisecond, RefreshInterval: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// the third server leaves, the next answer tells the client
+	gone := tc.servers[2]
+	tc.setRing(tc.servers[0].URL, tc.servers[1].URL)
+	c.Get(context.Background(), "kv", "a")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(c.Cluster().Ring) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("the ring should be refreshed, got %v", c.Cluster().Ring)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// a server which is down is skipped for the next one on the ring
+	tc.setRing(tc.servers[0].URL, gone.URL)
+	c.Refresh(context.Background())
+	gone.Close()
+
+	var key string
+	for i := 0; ; i++ {
+		key = "key" + strconv.Itoa(i)
+		if tc.owner(key) == gone.URL {
+			break
+		}
+	}
+	if err := c.Set(context.Background(), "kv", key, []byte("v"), 0); err != nil {
+		t.Fatalf("the retry should reach another server: %v", err)
+	}
+}
+
+func TestNew_NoServerAnswers(t *testing.T) {
+	if _, err := New(Options{}); err == nil {
+		t.Fatal("a client needs servers")
+	}
+
+	c, err := New(Options{Servers: []string{"http://127.0.0.1:1"}, MaxRetries: -1, Timeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, err := c.Get(context.Background(), "kv", "a"); err == nil {
+		t.Fatal("nobody answers")
+	}
+}
+
+func TestClient_Signed(t *testing.T) {
+	keys := auth.NewKeyRing("k1", []byte("secret"))
+	verifier := auth.NewVerifier(keys, auth.VerifierOptions{})
+	graph := cache.DefaultGraph()
+	graph.AddNode(cache.NewNode("kv", 2<<10, func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("%s: %w", key, cache.ErrNotFound)
+	}))
+	h := api.NewHandler(graph, api.Options{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := verifier.Verify(r); err != nil {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	unsigned, err := New(Options{Servers: []string{srv.URL}, RefreshInterval: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsigned.Close()
+	if err := unsigned.Set(ctx, "kv", "a", []byte("v"), 0); err == nil {
+		t.Fatal("an unsigned request should be rejected")
+	}
+
+	signed, err := New(Options{Servers: []string{srv.URL}, RefreshInterval: -1, KeyID: "k1", Secret: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer signed.Close()
+	if err := signed.Set(ctx, "kv", "a", []byte("v"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := signed.Get(ctx, "kv", "a"); err != nil || string(v) != "v" {
+		t.Fatalf("get a: %q, %v", v, err)
+	}
+}
+
+func TestClient_NoRetryOnAnswers(t *testing.T) {
+	tc := newTestCluster(t, 3)
+	c, err := New(Options{Servers: []string{tc.servers[0].URL}, RefreshInterval: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, err := c.Get(context.Background(), "kv", "missing"); err != ErrNotFound {
+		t.Fatalf("got %v", err)
+	}
+
+	var total int32
+	for _, hits := range tc.hits {
+		total += hits.Load()
+	}
+	if total != 1 {
+		t.Fatalf("a missing key should be asked once, got %d requests", total)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/golrice/e-fis/internal/bench"
+	"github.com/golrice/e-fis/internal/cache"
+	"github.com/golrice/e-fis/internal/trace"
+)
+
+func cmdBench(args []string, stdout io.Writer) error {
+	c := newCommon("bench", true, 10*time.Minute)
+	var opts bench.Options
+	var traceFile, policy string
+	var inproc bool
+	var capacity int64
+	var loadDelay time.Duration
+	w := &opts.Workload
+	c.fs.StringVar(&w.Distribution, "dist", bench.Zipf, "key distribution, uniform, zipf, hotspot, scan or trace")
+	c.fs.IntVar(&w.Keys, "keys", 10000, "number of distinct keys")
+	c.fs.Float64Var(&w.Skew, "skew", 0.99, "zipf skew, above 0 and not 1")
+	c.fs.Float64Var(&w.HotKeys, "hot-keys", 0.2, "hotspot, the fraction of the keys which are hot")
+	c.fs.Float64Var(&w.HotOps, "hot-ops", 0.8, "hotspot, the fraction of the requests which go to the hot keys")
+	c.fs.Float64Var(&w.Writes, "writes", 0, "fraction of the requests which set the key")
+	c.fs.IntVar(&w.ValueSize, "size", 100, "bytes of a value")
+	c.fs.Int64Var(&w.Seed, "seed", 1, "seed of the key choice, the same seed gives the same keys")
+	c.fs.StringVar(&traceFile, "trace", "", "replay this trace, implies --dist trace")
+	c.fs.IntVar(&opts.Concurrency, "concurrency", 8, "requests in flight")
+	c.fs.DurationVar(&opts.Duration, "duration", 0, "length of the run, 10s if neither this nor --requests is set")
+	c.fs.Int64Var(&opts.Requests, "requests", 0, "stop after this many requests")
+	c.fs.BoolVar(&opts.Preload, "preload", false, "set every key once before the run")
+	c.fs.BoolVar(&inproc, "inproc", false, "drive a node in this process instead of the servers")
+	c.fs.StringVar(&policy, "policy", "lru", "eviction policy of the --inproc node")
+	c.fs.Int64Var(&capacity, "capacity", 1<<20, "bytes cached by the --inproc node, 0 is unlimited")
+	c.fs.DurationVar(&loadDelay, "load-delay", 0, "how long the --inproc node takes to load a missing key")
+	out, err := c.parse(args, stdout)
+	if err != nil {
+		return err
+	}
+
+	if traceFile != "" {
+		f, err := os.Open(traceFile)
+		if err != nil {
+			return err
+		}
+		w.Records, err = trace.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", traceFile, err)
+		}
+		w.Distribution = bench.Trace
+	}
+
+	var target bench.Target
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	if inproc {
+		size := w.ValueSize
+		node, err := cache.NewNodeWithPolicy(c.ns, capacity, policy, func(key string) ([]byte, error) {
+			if loadDelay > 0 {
+				time.Sleep(loadDelay)
+			}
+			return make([]byte, size), nil
+		})
+		if err != nil {
+			return err
+		}
+		target = bench.NewNodeTarget(node)
+	} else {
+		cl, _, done, err := c.connect()
+		if err != nil {
+			return err
+		}
+		defer done()
+		target = bench.NewClientTarget(cl, c.ns)
+	}
+
+	report, err := bench.Run(ctx, target, opts)
+	if report == nil {
+		return err
+	}
+
+	if out.format == "json" {
+		out.json(report)
+		return err
+	}
+
+	l := report.Latency
+	fmt.Fprintf(stdout, "%s, %d workers, %s\n", report.Distribution, report.Concurrency, report.Elapsed.Round(time.Millisecond))
+	fmt.Fprintf(stdout, "  requests    %d: %d gets, %d sets, %d deletes, %d not found, %d errors\n",
+		report.Requests, report.Gets, report.Sets, report.Deletes, report.NotFound, report.Errors)
+	fmt.Fprintf(stdout, "  throughput  %.1f ops/s\n", report.Throughput)
+	fmt.Fprintf(stdout, "  latency     mean %s, p50 %s, p90 %s, p99 %s, p99.9 %s, max %s\n",
+		round(l.Mean), round(l.P50), round(l.P90), round(l.P99), round(l.P999), round(l.Max))
+	fmt.Fprintf(stdout, "  hit ratio   %.1f%% of %d node gets\n", report.HitRatio*100, report.Counters.Gets)
+	fmt.Fprintf(stdout, "  loads       %d from peers, %d local, %.1f%% from peers\n",
+		report.Counters.PeerLoads, report.Counters.LocalLoads, report.PeerShare*100)
+	for _, e := range report.FirstErrors {
+		fmt.Fprintln(stdout, "  error: "+e)
+	}
+
+	return err
+}
+
+// enough digits to compare runs, not more
+func round(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond)
+	}
+	return d.Round(100 * time.Nanosecond)
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golrice/e-fis/client"
+)
+
+var commands = map[string]func(args []string, stdout io.Writer) error{
+	"get":   cmdGet,
+	"set":   cmdSet,
+	"del":   cmdDel,
+	"mget":  cmdMGet,
+	"warm":  cmdWarm,
+	"stats": cmdStats,
+	"ring":  cmdRing,
+	"bench": cmdBench,
+	"sim":   cmdSim,
+}
+
+const defaultTimeout = 5 * time.Second
+
+// the flags every command takes
+type common struct {
+	fs      *flag.FlagSet
+	servers string
+	output  string
+	timeout time.Duration
+	ns      string
+	key     string
+}
+
+func newCommon(name string, withNamespace bool, timeout time.Duration) *common {
+	c := &common{fs: flag.NewFlagSet(name, flag.ContinueOnError)}
+
+	servers := os.Getenv("EFIS_SERVERS")
+	if servers == "" {
+		servers = "http://localhost:8001"
+	}
+	c.fs.StringVar(&c.servers, "server", servers, "comma separated servers, defaults to $EFIS_SERVERS")
+	c.fs.StringVar(&c.output, "output", "raw", "output format, raw, json or hex")
+	c.fs.DurationVar(&c.timeout, "timeout", timeout, "timeout of the whole command")
+	c.fs.StringVar(&c.key, "auth-key", os.Getenv("EFIS_AUTH_KEY"), "id:secret, a key of the -auth-keys of the servers, defaults to $EFIS_AUTH_KEY")
+	if withNamespace {
+		c.fs.StringVar(&c.ns, "ns", "kv", "namespace")
+	}
+
+	return c
+}
+
+func (c *common) parse(args []string, stdout io.Writer) (*output, error) {
+	if err := c.fs.Parse(args); err != nil {
+		return nil, err
+	}
+	return newOutput(stdout, c.output)
+}
+
+func (c *common) connect() (*client.Client, context.Context, context.CancelFunc, error) {
+	opts := client.Options{
+		Servers:         strings.Split(c.servers, ","),
+		RefreshInterval: -1,
+	}
+	if c.key != "" {
+		id, secret, ok := strings.Cut(c.key, ":")
+		if !ok {
+			return nil, nil, nil, fmt.Errorf("bad key %q, want id:secret", c.key)
+		}
+		opts.KeyID, opts.Secret = id, secret
+	}
+	cl, err := client.New(opts)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	return cl, ctx, func() {
+		cancel()
+		cl.Close()
+	}, nil
+}
+
+func cmdGet(args []string, stdout io.Writer) error {
+	c := newCommon("get", true, defaultTimeout)
+	out, err := c.parse(args, stdout)
+	if err != nil {
+		return err
+	}
+	if c.fs.NArg() != 1 {
+		return errors.New("get takes a single key")
+	}
+
+	cl, ctx, done, err := c.connect()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	key := c.fs.Arg(0)
+	v, err := cl.Get(ctx, c.ns, key)
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	out.value(c.ns, key, v)
+
+	return nil
+}
+
+func cmdSet(args []string, stdout io.Writer) error {
+	c := newCommon("set", true, defaultTimeout)
+	var ttl time.Duration
+	c.fs.DurationVar(&ttl, "ttl", 0, "the key expires after this, 0 never")
+	if _, err := c.parse(args, stdout); err != nil {
+		return err
+	}
+	if c.fs.NArg() < 1 || c.fs.NArg() > 2 {
+		return errors.New("set takes a key and a value")
+	}
+
+	var value []byte
+	if c.fs.NArg() == 2 {
+		value = []byte(c.fs.Arg(1))
+	} else {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		value = b
+	}
+
+	cl, ctx, done, err := c.connect()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	return cl.Set(ctx, c.ns, c.fs.Arg(0), value, ttl)
+}
+
+func cmdDel(args []string, stdout io.Writer) error {
+	c := newCommon("del", true, defaultTimeout)
+	if _, err := c.parse(args, stdout); err != nil {
+		return err
+	}
+	if c.fs.NArg() == 0 {
+		return errors.New("del takes at least one key")
+	}
+
+	cl, ctx, done, err := c.connect()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	for _, key := range c.fs.Args() {
+		if err := cl.Delete(ctx, c.ns, key); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+// one key per line, blank lines and lines starting with # are skipped
+func readKeys(path string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var keys []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys = append(keys, line)
+	}
+
+	return keys, scanner.Err()
+}
+
+func cmdMGet(args []string, stdout io.Writer) error {
+	c := newCommon("mget", true, defaultTimeout)
+	var file string
+	c.fs.StringVar(&file, "file", "", "file with one key per line, - is stdin")
+	out, err := c.parse(args, stdout)
+	if err != nil {
+		return err
+	}
+
+	keys := c.fs.Args()
+	if file != "" {
+		more, err := readKeys(file)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, more...)
+	}
+	if len(keys) == 0 {
+		return errors.New("mget needs keys, as arguments or with --file")
+	}
+
+	cl, ctx, done, err := c.connect()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	found, err := cl.MGet(ctx, c.ns, keys...)
+	out.values(c.ns, keys, found)
+
+	return err
+}
+
+type warmReport struct {
+	Keys    int      `json:"keys"`
+	Loaded  int64    `json:"loaded"`
+	Missing int64    `json:"missing"`
+	Failed  int64    `json:"failed"`
+	Errors  []string `json:"errors,omitempty"`
+	Took    string   `json:"took"`
+}
+
+// warm reads every key once, so that the owners load them from their source
+func cmdWarm(args []string, stdout io.Writer) error {
+	c := newCommon("warm", true, time.Minute)
+	var file string
+	var parallel int
+	c.fs.StringVar(&file, "file", "", "file with one key per line, - is stdin")
+	c.fs.IntVar(&parallel, "parallel", 16, "keys loaded at the same time")
+	out, err := c.parse(args, stdout)
+	if err != nil {
+		return err
+	}
+	if file == "" {
+		return errors.New("warm needs --file")
+	}
+	parallel = max(parallel, 1)
+
+	keys, err := readKeys(file)
+	if err != nil {
+		return err
+	}
+
+	cl, ctx, done, err := c.connect()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	start := time.Now()
+	report := warmReport{Keys: len(keys)}
+	var mu sync.Mutex
+	var loaded, missing, failed atomic.Int64
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, parallel)
+	for _, key := range keys {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(key string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			_, err := cl.Get(ctx, c.ns, key)
+			switch {
+			case err == nil:
+				loaded.Add(1)
+			case errors.Is(err, client.ErrNotFound):
+				missing.Add(1)
+			default:
+				failed.Add(1)
+				mu.Lock()
+				if len(report.Errors) < 10 {
+					report.Errors = append(report.Errors, key+": "+err.Error())
+				}
+				mu.Unlock()
+			}
+		}(key)
+	}
+	wg.Wait()
+
+	report.Loaded, report.Missing, report.Failed = loaded.Load(), missing.Load(), failed.Load()
+	report.Took = time.Since(start).Round(time.Millisecond).String()
+
+	if out.format == "json" {
+		out.json(report)
+	} else {
+		fmt.Fprintf(stdout, "%d keys: %d loaded, %d missing, %d failed in %s\n",
+			report.Keys, report.Loaded, report.Missing, report.Failed, report.Took)
+		for _, e := range report.Errors {
+			fmt.Fprintln(stdout, "  "+e)
+		}
+	}
+
+	if report.Failed > 0 {
+		return fmt.Errorf("%d keys failed", report.Failed)
+	}
+	return nil
+}
+
+func cmdStats(args []string, stdout io.Writer) error {
+	c := newCommon("stats", false, defaultTimeout)
+	out, err := c.parse(args, stdout)
+	if err != nil {
+		return err
+	}
+
+	cl, ctx, done, err := c.connect()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	stats, err := cl.Stats(ctx)
+	if out.format == "json" {
+		out.json(stats)
+		return err
+	}
+
+	servers := make([]string, 0, len(stats))
+	for server := range stats {
+		servers = append(servers, server)
+	}
+	sort.Strings(servers)
+	for _, server := range servers {
+		fmt.Fprintln(stdout, server)
+		out.tree(stats[server], "  ")
+	}
+
+	return err
+}
+
+type ringReport struct {
+	Self    string             `json:"self"`
+	Version string             `json:"version"`
+	Members []string           `json:"members"`
+	Ring    []string           `json:"ring"`
+	Share   map[string]float64 `json:"share"`
+	Owners  map[string]string  `json:"owners,omitempty"`
+}
+
+func cmdRing(args []string, stdout io.Writer) error {
+	c := newCommon("ring", false, defaultTimeout)
+	out, err := c.parse(args, stdout)
+	if err != nil {
+		return err
+	}
+
+	cl, _, done, err := c.connect()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	cluster := cl.Cluster()
+	if cluster == nil {
+		return errors.New("no server answered with its ring")
+	}
+
+	report := ringReport{
+		Self:    cluster.Self,
+		Version: cluster.Version,
+		Members: cluster.Members,
+		Ring:    cluster.Ring,
+		Share:   cl.Share(),
+	}
+	if c.fs.NArg() > 0 {
+		report.Owners = make(map[string]string)
+		for _, key := range c.fs.Args() {
+			report.Owners[key] = cl.Owner(key)
+		}
+	}
+
+	if out.format == "json" {
+		return out.json(report)
+	}
+
+	fmt.Fprintf(stdout, "ring %s, as seen by %s\n", report.Version, report.Self)
+	for _, member := range report.Members {
+		state := "out of the ring"
+		if share, ok := report.Share[member]; ok {
+			state = fmt.Sprintf("%5.1f%%", share*100)
+		}
+		fmt.Fprintf(stdout, "  %s\t%s\n", member, state)
+	}
+	for _, key := range c.fs.Args() {
+		fmt.Fprintf(stdout, "%s -> %s\n", key, report.Owners[key])
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golrice/e-fis/internal/api"
+	"github.com/golrice/e-fis/internal/cache"
+)
+
+// a single server cluster, the peer port serves the api like cmd/server does
+func startCluster(t *testing.T) string {
+	t.Helper()
+
+	graph := cache.DefaultGraph()
+	graph.AddNode(cache.NewNode("kv", 2<<10, func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("%s: %w", key, cache.ErrNotFound)
+	}))
+	graph.AddNode(cache.NewNode("scores", 2<<10, func(key string) ([]byte, error) {
+		if key == "Tom" {
+			return []byte("630"), nil
+		}
+		return nil, fmt.Errorf("%s: %w", key, cache.ErrNotFound)
+	}))
+
+	var self string
+	srv := httptest.NewServer(api.NewHandler(graph, api.Options{
+		Cluster: func() api.ClusterInfo {
+			return api.ClusterInfo{Self: self, Members: []string{self}, Ring: []string{self}, Replicas: 50}
+		},
+		Stats: func() map[string]any {
+			return map[string]any{"pool": map[string]any{"hits": 1}}
+		},
+	}))
+	t.Cleanup(srv.Close)
+	self = srv.URL
+
+	return srv.URL
+}
+
+func runCLI(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+
+	var out bytes.Buffer
+	err := run(args, &out)
+	return out.String(), err
+}
+
+func TestCLI_Keys(t *testing.T) {
+	server := "--server=" + startCluster(t)
+
+	if _, err := runCLI(t, "set", server, "a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := runCLI(t, "get", server, "a"); err != nil || out != "1\n" {
+		t.Fatalf("get: %q, %v", out, err)
+	}
+	if out, _ := runCLI(t, "get", server, "--output=hex", "a"); out != "31\n" {
+		t.Fatalf("hex: %q", out)
+	}
+	out, _ := runCLI(t, "get", server, "--output=json", "a")
+	var v jsonValue
+	if err := json.Unmarshal([]byte(out), &v); err != nil || v.Value != "1" || v.Namespace != "kv" {
+		t.Fatalf("json: %q", out)
+	}
+	if out, err := runCLI(t, "get", server, "--ns=scores", "Tom"); err != nil || out != "630\n" {
+		t.Fatalf("namespace: %q, %v", out, err)
+	}
+
+	keys := filepath.Join(t.TempDir(), "keys")
+	os.WriteFile(keys, []byte("# scores\nTom\n\nBob\n"), 0o644)
+	if out, err := runCLI(t, "mget", server, "--ns=scores", "--file="+keys); err != nil || out != "Tom\t630\nBob\t(missing)\n" {
+		t.Fatalf("mget: %q, %v", out, err)
+	}
+	if out, err := runCLI(t, "warm", server, "--ns=scores", "--file="+keys); err != nil || !strings.Contains(out, "1 loaded, 1 missing, 0 failed") {
+		t.Fatalf("warm: %q, %v", out, err)
+	}
+
+	if _, err := runCLI(t, "del", server, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runCLI(t, "get", server, "a"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("a deleted key: %v", err)
+	}
+}
+
+func TestCLI_Cluster(t *testing.T) {
+	url := startCluster(t)
+	server := "--server=" + url
+
+	out, err := runCLI(t, "ring", server, "a")
+	if err != nil || !strings.Contains(out, "100.0%") || !strings.Contains(out, "a -> "+url) {
+		t.Fatalf("ring: %q, %v", out, err)
+	}
+
+	out, err = runCLI(t, "stats", server)
+	if err != nil || !strings.Contains(out, "hits: 1") {
+		t.Fatalf("stats: %q, %v", out, err)
+	}
+
+	out, err = runCLI(t, "bench", server, "--keys=10", "--requests=50", "--preload", "--output=json")
+	var report struct{ Requests, Errors, NotFound int64 }
+	if err != nil || json.Unmarshal([]byte(out), &report) != nil || report.Requests != 50 || report.Errors+report.NotFound != 0 {
+		t.Fatalf("bench: %q, %v", out, err)
+	}
+
+	if _, err := runCLI(t, "nope"); err == nil {
+		t.Fatal("an unknown command should fail")
+	}
+	if _, err := runCLI(t, "get", server, "--output=yaml", "a"); err == nil {
+		t.Fatal("an unknown output should fail")
+	}
+}
+
+func TestCLI_Sim(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "trace")
+	os.WriteFile(file, []byte("1\n2\n1\n2\n"), 0o644)
+
+	out, err := runCLI(t, "sim", "--trace="+file, "--format=lirs", "--policies=lru", "--capacities=1k")
+	if err != nil || out != "policy,capacity,gets,hits,hit_ratio,byte_hit_ratio\nlru,1024,4,2,0.5000,0.5000\n" {
+		t.Fatalf("sim: %q, %v", out, err)
+	}
+
+	if _, err := runCLI(t, "sim", "--trace="+file, "--capacities=x"); err == nil {
+		t.Fatal("a bad capacity should fail")
+	}
+}
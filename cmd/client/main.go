@@ -1,57 +1,57 @@
 package main
 
 import (
-	"bytes"
-	"flag"
 	"fmt"
 	"io"
-	"log"
-	"net/http"
-
-	pb "github.com/golrice/e-fis/internal/protocal"
-	"google.golang.org/protobuf/proto"
+	"os"
+	"strings"
 )
 
-func main() {
-	var serverAddr string
-	var key string
-	flag.StringVar(&serverAddr, "server", "http://localhost:9999", "server address")
-	flag.StringVar(&key, "key", "", "key to get from cache")
-	flag.Parse()
-
-	if key == "" {
-		log.Fatal("key is required")
-	}
-
-	request := &pb.Request{
-		NodeName: "scores",
-		Key:      key,
-	}
-
-	body, err := proto.Marshal(request)
-	if err != nil {
-		log.Fatalf("failed to marshal request: %v", err)
+const usage = `usage: client <command> [flags] [args]
+
+commands:
+  get   [--ns kv] KEY            read a key
+  set   [--ns kv] [--ttl 1m] KEY [VALUE]
+                                 store a key, the value is read from stdin if it is left out
+  del   [--ns kv] KEY...         delete keys
+  mget  [--ns kv] [--file F] [KEY...]
+                                 read many keys, one key per line in F, - is stdin
+  warm  [--ns kv] --file F       load the keys in F into the cache
+  stats                          the counters of every server
+  ring  [KEY...]                 the members, their share of the keys, and the owners of KEY
+  bench [--ns kv] [--dist zipf] [--duration 10s] [--inproc]
+                                 generate load and report throughput, latency and hit ratio
+  sim   --trace F [--format efis|arc|lirs] [--policies lru,lfu] [--capacities 64k,1m]
+                                 replay a trace against the eviction policies, no server needed
+
+every command but sim takes --server, a comma separated list of servers, --timeout
+and --output raw|json|hex, run "client <command> -h" for the flags of a command
+
+"client -key KEY" still reads a single key through the api server
+`
+
+func run(args []string, stdout io.Writer) error {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		fmt.Fprint(stdout, usage)
+		return nil
 	}
 
-	resp, err := http.Post(serverAddr+"/api", "application/octet-stream", bytes.NewReader(body))
-	if err != nil {
-		log.Fatalf("failed to send request to server: %v", err)
+	// the old flag-only form
+	if strings.HasPrefix(args[0], "-") {
+		return legacy(args, stdout)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		log.Fatalf("server returned non-OK status: %v", resp.Status)
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q, run client help", args[0])
 	}
 
-	responseBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Fatalf("failed to read response body: %v", err)
-	}
+	return cmd(args[1:], stdout)
+}
 
-	var response pb.Response
-	if err := proto.Unmarshal(responseBody, &response); err != nil {
-		log.Fatalf("failed to unmarshal response: %v", err)
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "client:", err)
+		os.Exit(1)
 	}
-
-	fmt.Printf("Value for key '%s': %s\n", key, string(response.Value))
 }
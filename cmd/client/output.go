@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// output prints values and reports as raw text, json or hex
+type output struct {
+	w      io.Writer
+	format string
+}
+
+func newOutput(w io.Writer, format string) (*output, error) {
+	switch format {
+	case "raw", "json", "hex":
+		return &output{w: w, format: format}, nil
+	}
+	return nil, fmt.Errorf("unknown output %q, want raw, json or hex", format)
+}
+
+// json keeps text as is and falls back to base64 for binary values
+type jsonValue struct {
+	Key       string `json:"key"`
+	Value     string `json:"value,omitempty"`
+	ValueB64  []byte `json:"value_base64,omitempty"`
+	Namespace string `json:"namespace"`
+}
+
+func newJSONValue(namespace, key string, v []byte) jsonValue {
+	if utf8.Valid(v) {
+		return jsonValue{Namespace: namespace, Key: key, Value: string(v)}
+	}
+	return jsonValue{Namespace: namespace, Key: key, ValueB64: v}
+}
+
+func (o *output) value(namespace, key string, v []byte) {
+	switch o.format {
+	case "json":
+		o.json(newJSONValue(namespace, key, v))
+	case "hex":
+		fmt.Fprintln(o.w, hex.EncodeToString(v))
+	default:
+		o.w.Write(v)
+		fmt.Fprintln(o.w)
+	}
+}
+
+// values prints the keys in the given order, missing ones too
+func (o *output) values(namespace string, keys []string, found map[string][]byte) {
+	if o.format == "json" {
+		list := make([]any, 0, len(keys))
+		for _, key := range keys {
+			if v, ok := found[key]; ok {
+				list = append(list, newJSONValue(namespace, key, v))
+			} else {
+				list = append(list, map[string]any{"namespace": namespace, "key": key, "missing": true})
+			}
+		}
+		o.json(list)
+		return
+	}
+
+	for _, key := range keys {
+		v, ok := found[key]
+		switch {
+		case !ok:
+			fmt.Fprintf(o.w, "%s\t(missing)\n", key)
+		case o.format == "hex":
+			fmt.Fprintf(o.w, "%s\t%s\n", key, hex.EncodeToString(v))
+		default:
+			fmt.Fprintf(o.w, "%s\t%s\n", key, v)
+		}
+	}
+}
+
+func (o *output) json(v any) error {
+	enc := json.NewEncoder(o.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// tree prints nested maps as indented name: value lines, sorted by name
+func (o *output) tree(v any, indent string) {
+	m, ok := v.(map[string]any)
+	if !ok {
+		fmt.Fprintf(o.w, "%s%v\n", indent, v)
+		return
+	}
+
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		switch child := m[name].(type) {
+		case map[string]any:
+			fmt.Fprintf(o.w, "%s%s:\n", indent, name)
+			o.tree(child, indent+"  ")
+		case []any:
+			// a list of records, e.g. the points of a curve, one per line
+			fmt.Fprintf(o.w, "%s%s:\n", indent, name)
+			for _, item := range child {
+				o.item(item, indent+"  ")
+			}
+		default:
+			fmt.Fprintf(o.w, "%s%s: %s\n", indent, name, scalar(child))
+		}
+	}
+}
+
+// a map in a list is printed on one line, name=value separated by spaces
+func (o *output) item(v any, indent string) {
+	m, ok := v.(map[string]any)
+	if !ok {
+		fmt.Fprintf(o.w, "%s- %v\n", indent, v)
+		return
+	}
+
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fields := make([]string, len(names))
+	for i, name := range names {
+		fields[i] = name + "=" + scalar(m[name])
+	}
+	fmt.Fprintf(o.w, "%s- %s\n", indent, strings.Join(fields, " "))
+}
+
+// json numbers are float64, large counters would be printed with an exponent otherwise
+func scalar(v any) string {
+	if f, ok := v.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/golrice/e-fis/internal/api"
+	pb "github.com/golrice/e-fis/internal/protocal"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// get posts the request to <server>/api in the given format, proto or json, and decodes the reply
+func get(client *http.Client, serverAddr string, request *pb.Request, format string) (*pb.Response, error) {
+	var contentType string
+	var marshal func(proto.Message) ([]byte, error)
+	var unmarshal func([]byte, proto.Message) error
+	switch format {
+	case "proto":
+		contentType, marshal, unmarshal = api.ContentTypeProtobuf, proto.Marshal, proto.Unmarshal
+	case "json":
+		contentType, marshal, unmarshal = api.ContentTypeJSON, protojson.Marshal, protojson.Unmarshal
+	default:
+		return nil, fmt.Errorf("unknown format %s", format)
+	}
+
+	body, err := marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, serverAddr+api.RPCPath, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", contentType)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request to server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		// errors always come as json
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(responseBody, &e) == nil && e.Error != "" {
+			return nil, fmt.Errorf("server returned %v: %s", resp.Status, e.Error)
+		}
+		return nil, fmt.Errorf("server returned non-OK status: %v", resp.Status)
+	}
+
+	var response pb.Response
+	if err := unmarshal(responseBody, &response); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	return &response, nil
+}
+
+// legacy keeps "client -key Tom" working, it reads a single key over the protobuf endpoint
+func legacy(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("client", flag.ContinueOnError)
+	var serverAddr string
+	var namespace string
+	var key string
+	var format string
+	fs.StringVar(&serverAddr, "server", "http://localhost:9999", "server address")
+	fs.StringVar(&namespace, "namespace", "scores", "namespace of the key")
+	fs.StringVar(&key, "key", "", "key to get from cache")
+	fs.StringVar(&format, "format", "proto", "wire format, proto or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if key == "" {
+		return errors.New("key is required")
+	}
+
+	response, err := get(http.DefaultClient, serverAddr, &pb.Request{NodeName: namespace, Key: key}, format)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "Value for key '%s': %s\n", key, string(response.Value))
+	return nil
+}
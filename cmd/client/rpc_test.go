@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golrice/e-fis/internal/api"
+	"github.com/golrice/e-fis/internal/cache"
+	pb "github.com/golrice/e-fis/internal/protocal"
+)
+
+// the api server as cmd/server mounts it, with two namespaces
+func startServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	graph := cache.DefaultGraph()
+	source := map[string]map[string]string{
+		"scores": {"Tom": "630"},
+		"ages":   {"Tom": "17"},
+	}
+	for name, db := range source {
+		db := db
+		graph.AddNode(cache.NewNode(name, 2<<10, func(key string) ([]byte, error) {
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, fmt.Errorf("%s: %w", key, cache.ErrNotFound)
+		}))
+	}
+
+	handler := api.NewHandler(graph, api.Options{DefaultNamespace: "scores"})
+	mux := http.NewServeMux()
+	mux.Handle(api.RPCPath, handler)
+	mux.Handle(api.Prefix, handler)
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestClient_EndToEnd(t *testing.T) {
+	srv := startServer(t)
+
+	for _, format := range []string{"proto", "json"} {
+		resp, err := get(srv.Client(), srv.URL, &pb.Request{NodeName: "scores", Key: "Tom"}, format)
+		if err != nil || string(resp.Value) != "630" {
+			t.Fatalf("%s: got %v, %v", format, resp, err)
+		}
+
+		// the namespace of the request is honored
+		resp, err = get(srv.Client(), srv.URL, &pb.Request{NodeName: "ages", Key: "Tom"}, format)
+		if err != nil || string(resp.Value) != "17" {
+			t.Fatalf("%s: got %v, %v", format, resp, err)
+		}
+
+		_, err = get(srv.Client(), srv.URL, &pb.Request{NodeName: "scores", Key: "Bob"}, format)
+		if err == nil || !strings.Contains(err.Error(), "404") {
+			t.Fatalf("%s: a missing key should be a 404, got %v", format, err)
+		}
+
+		_, err = get(srv.Client(), srv.URL, &pb.Request{NodeName: "nope", Key: "Tom"}, format)
+		if err == nil || !strings.Contains(err.Error(), "no such namespace") {
+			t.Fatalf("%s: got %v", format, err)
+		}
+	}
+}
+
+func TestServer_Negotiation(t *testing.T) {
+	srv := startServer(t)
+
+	// the old query form still answers raw bytes, in the default namespace
+	res, err := http.Get(srv.URL + "/api?key=Tom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if string(b) != "630" || res.Header.Get("Content-Type") != api.ContentTypeOctet {
+		t.Fatalf("got %q %s", b, res.Header.Get("Content-Type"))
+	}
+
+	// a json request with a protobuf reply
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/api", strings.NewReader(`{"nodeName":"ages","key":"Tom"}`))
+	req.Header.Set("Content-Type", api.ContentTypeJSON)
+	req.Header.Set("Accept", api.ContentTypeProtobuf)
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.Header.Get("Content-Type") != api.ContentTypeProtobuf {
+		t.Fatalf("got %s", res.Header.Get("Content-Type"))
+	}
+
+	res, err = http.Post(srv.URL+"/api", "text/plain", strings.NewReader("Tom"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnsupportedMediaType {
+		t.Fatalf("got %d", res.StatusCode)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/golrice/e-fis/internal/sim"
+	"github.com/golrice/e-fis/internal/trace"
+)
+
+// sim replays a trace against the eviction policies offline, no server is needed
+func cmdSim(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("sim", flag.ContinueOnError)
+	var file, format, ns, policies, capacities, outFormat string
+	var opts sim.Options
+	fs.StringVar(&file, "trace", "", "the trace to replay, - is stdin")
+	fs.StringVar(&format, "format", trace.FormatEfis, "format of the trace, "+strings.Join(trace.Formats(), ", "))
+	fs.StringVar(&ns, "ns", "", "only replay the records of this namespace")
+	fs.StringVar(&policies, "policies", "", "comma separated policies, all of "+strings.Join(sim.Policies(), ", ")+" if empty")
+	fs.StringVar(&capacities, "capacities", "", "comma separated capacities in bytes, e.g. 64k,1m, spread over the working set if empty")
+	fs.IntVar(&opts.Points, "points", 10, "number of capacities if --capacities is empty")
+	fs.IntVar(&opts.DefaultSize, "size", 1, "bytes of a record without a size, e.g. from a block trace")
+	fs.StringVar(&outFormat, "output", "csv", "output format, csv or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if file == "" {
+		return errors.New("sim needs --trace")
+	}
+	if outFormat != "csv" && outFormat != "json" {
+		return fmt.Errorf("unknown output %q, want csv or json", outFormat)
+	}
+
+	if policies != "" {
+		opts.Policies = strings.Split(policies, ",")
+	}
+	if capacities != "" {
+		for _, s := range strings.Split(capacities, ",") {
+			c, err := parseBytes(s)
+			if err != nil {
+				return err
+			}
+			opts.Capacities = append(opts.Capacities, c)
+		}
+	}
+
+	var r io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	records, err := trace.ReadFormat(r, format)
+	if err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	if ns != "" {
+		kept := records[:0]
+		for _, rec := range records {
+			if rec.Namespace == ns {
+				kept = append(kept, rec)
+			}
+		}
+		records = kept
+	}
+
+	result, err := sim.Run(records, opts)
+	if err != nil {
+		return err
+	}
+
+	if outFormat == "json" {
+		out := &output{w: stdout, format: outFormat}
+		return out.json(result)
+	}
+	return sim.WriteCSV(stdout, result.Points)
+}
+
+// bytes with an optional k, m or g suffix, powers of 1024
+func parseBytes(s string) (int64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	shift := 0
+	switch {
+	case strings.HasSuffix(s, "k"):
+		shift = 10
+	case strings.HasSuffix(s, "m"):
+		shift = 20
+	case strings.HasSuffix(s, "g"):
+		shift = 30
+	}
+	if shift > 0 {
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("bad capacity %q", s)
+	}
+	return n << shift, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/golrice/e-fis/internal/hotkey"
+	"github.com/golrice/e-fis/internal/trace"
+)
+
+// traceDefaults fill in what a POST to /admin/trace leaves out
+func startAdminServer(adminAddr string, pool *HttpPool, traceDefaults trace.RecorderOptions) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/stats", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, pool.StatsSnapshot())
+	})
+	mux.HandleFunc("/admin/peers", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{
+			"ring":   pool.RingMembers(),
+			"health": pool.PeerHealth(),
+		})
+	})
+	mux.HandleFunc("/admin/breakers", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, pool.BreakerStatus())
+	})
+	mux.HandleFunc("/admin/rebalance", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, pool.RebalanceProgress())
+	})
+	// the hottest keys of every namespace, ?ns= picks one, ?n= how many, 10 by default
+	mux.HandleFunc("/admin/hotkeys", func(w http.ResponseWriter, r *http.Request) {
+		n := 10
+		if s := r.URL.Query().Get("n"); s != "" {
+			v, err := strconv.Atoi(s)
+			if err != nil || v <= 0 {
+				http.Error(w, "bad n: "+s, http.StatusBadRequest)
+				return
+			}
+			n = v
+		}
+		ns := r.URL.Query().Get("ns")
+
+		reports := make(map[string]hotkey.Report)
+		for _, node := range pool.graph.Nodes() {
+			if ns == "" || node.Name() == ns {
+				reports[node.Name()] = node.HotKeys(n)
+			}
+		}
+		if ns != "" && len(reports) == 0 {
+			http.Error(w, "no such namespace: "+ns, http.StatusNotFound)
+			return
+		}
+		writeJSON(w, reports)
+	})
+	// GET the status, POST {"path": ..., "sample_rate": 0.1} starts recording, DELETE stops it
+	mux.HandleFunc("/admin/trace", func(w http.ResponseWriter, r *http.Request) {
+		recorder := pool.Recorder()
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			opts := traceDefaults
+			body, err := io.ReadAll(io.LimitReader(r.Body, 4<<10))
+			if err == nil && len(body) > 0 {
+				err = json.Unmarshal(body, &opts)
+			}
+			if err != nil {
+				http.Error(w, "bad body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := recorder.Start(opts); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		case http.MethodDelete:
+			if err := recorder.Stop(); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, POST, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, recorder.Status())
+	})
+
+	// rotates the keys of -auth-keys: GET lists them, POST {"id": ..., "secret": ...} adds one,
+	// POST {"id": ..., "primary": true} signs with it from now on, DELETE ?id= removes one
+	mux.HandleFunc("/admin/keys", func(w http.ResponseWriter, r *http.Request) {
+		keys := pool.Keys()
+		if keys == nil {
+			http.Error(w, "auth is not enabled", http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			var req struct {
+				ID      string `json:"id"`
+				Secret  string `json:"secret"`
+				Primary bool   `json:"primary"`
+			}
+			body, err := io.ReadAll(io.LimitReader(r.Body, 4<<10))
+			if err == nil {
+				err = json.Unmarshal(body, &req)
+			}
+			if err != nil {
+				http.Error(w, "bad body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if req.ID == "" || (req.Secret == "" && !req.Primary) {
+				http.Error(w, "want an id and a secret or primary", http.StatusBadRequest)
+				return
+			}
+			if req.Secret != "" {
+				keys.Add(req.ID, []byte(req.Secret))
+				log.Printf("[Admin] key %s added", req.ID)
+			}
+			if req.Primary {
+				if err := keys.Use(req.ID); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				log.Printf("[Admin] signing with key %s", req.ID)
+			}
+		case http.MethodDelete:
+			id := r.URL.Query().Get("id")
+			if err := keys.Remove(id); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			log.Printf("[Admin] key %s removed", id)
+		default:
+			w.Header().Set("Allow", "GET, POST, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		primary, _ := keys.Primary()
+		writeJSON(w, map[string]any{"primary": primary, "keys": keys.IDs()})
+	})
+
+	log.Println("admin server is running at", adminAddr)
+	log.Fatal(http.ListenAndServe(hostOf(adminAddr), mux))
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("[Admin] fail to write response, err:", err)
+	}
+}
@@ -1,12 +1,35 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"strings"
+	"time"
 
+	"github.com/golrice/e-fis/internal/api"
+	"github.com/golrice/e-fis/internal/auth"
+	"github.com/golrice/e-fis/internal/bus"
 	"github.com/golrice/e-fis/internal/cache"
+	"github.com/golrice/e-fis/internal/discovery"
+	"github.com/golrice/e-fis/internal/health"
+	"github.com/golrice/e-fis/internal/membership"
+	"github.com/golrice/e-fis/internal/memcache"
+	"github.com/golrice/e-fis/internal/peer"
+	"github.com/golrice/e-fis/internal/peertls"
+	"github.com/golrice/e-fis/internal/rebalance"
+	"github.com/golrice/e-fis/internal/resp"
+	"github.com/golrice/e-fis/internal/trace"
+	"github.com/golrice/e-fis/internal/watch"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	grpcpeer "google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 )
 
 var db = map[string]string{
@@ -15,54 +38,234 @@ var db = map[string]string{
 	"Sam":  "567",
 }
 
-func createNode() *cache.Node {
-	return cache.NewNode("scores", 2<<10, cache.GetterLikeFunc(
+func createNode(pool *HttpPool) *cache.Node {
+	return pool.NewNode("scores", 2<<10, cache.GetterLikeFunc(
 		func(key string) ([]byte, error) {
 			log.Println("[SlowDB] search key", key)
 			if v, ok := db[key]; ok {
 				return []byte(v), nil
 			}
-			return nil, fmt.Errorf("%s not exist", key)
+			return nil, fmt.Errorf("%s: %w", key, cache.ErrNotFound)
 		}))
 }
 
-func startCacheServer(addr string, addrs []string, node *cache.Node) {
-	peers := NewHttpPool(addr)
-	peers.Set(addrs...)
+// a plain key-value namespace, it only holds what clients set
+func createKVNode(pool *HttpPool) *cache.Node {
+	return pool.NewNode("kv", 64<<20, cache.GetterLikeFunc(
+		func(key string) ([]byte, error) {
+			return nil, fmt.Errorf("%s: %w", key, cache.ErrNotFound)
+		}))
+}
+
+// http://host:port -> host:port
+func hostOf(addr string) string {
+	if i := strings.Index(addr, "://"); i >= 0 {
+		return addr[i+3:]
+	}
+	return addr
+}
+
+func startCacheServer(peers *HttpPool, apiHandler http.Handler, addr string, disc discovery.Discovery, nodes []*cache.Node, healthInterval time.Duration, gossipAddr string, seeds []string, rebalanceRate int, certs *peertls.Manager) {
+	if certs != nil {
+		peers.EnableTLS(certs.ClientConfig())
+	}
+	if rebalanceRate > 0 {
+		peers.EnableRebalance(rebalance.Options{Rate: rebalanceRate})
+	}
+	if gossipAddr != "" {
+		if _, err := peers.JoinGossip(membership.Config{BindAddr: gossipAddr}, seeds...); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		peers.Subscribe(disc)
+	}
+	if healthInterval > 0 {
+		peers.EnableHealthCheck(health.Options{Interval: healthInterval})
+	}
+
+	for _, node := range nodes {
+		node.RegisterPeers(peers)
+	}
+	// smart clients talk to the owner of a key directly, they sign their requests like the peers
+	mux := http.NewServeMux()
+	mux.Handle(defaultBasePath, peers)
+	mux.Handle(api.RPCPath, peers.Verified(apiHandler))
+	mux.Handle("/api/", peers.Verified(apiHandler))
 
-	node.RegisterPeers(peers)
 	log.Println("server is running at", addr)
-	log.Fatal(http.ListenAndServe(addr[7:], peers))
+	if certs != nil {
+		server := &http.Server{Addr: hostOf(addr), Handler: mux, TLSConfig: certs.ServerConfig()}
+		log.Fatal(server.ListenAndServeTLS("", ""))
+	}
+	log.Fatal(http.ListenAndServe(hostOf(addr), mux))
 }
 
-func startAPIServer(apiAddr string, node *cache.Node) {
-	http.Handle("/api", http.HandlerFunc(
-		func(w http.ResponseWriter, r *http.Request) {
-			key := r.URL.Query().Get("key")
-			view, err := node.Get(key)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			w.Header().Set("Content-Type", "application/octet-stream")
-			w.Write(view.ByteSlice())
-		}))
+// namespaces created through the api only exist on this server, create them on every server
+func newAPIHandler(pool *HttpPool) *api.Handler {
+	return api.NewHandler(pool.graph, api.Options{
+		DefaultNamespace: "scores",
+		Create: func(name string, capacity int64, policy string) (*cache.Node, error) {
+			return pool.NewNodeWithPolicy(name, capacity, policy, func(key string) ([]byte, error) {
+				return nil, fmt.Errorf("%s: %w", key, cache.ErrNotFound)
+			})
+		},
+		Cluster:  pool.ClusterInfo,
+		Stats:    pool.StatsSnapshot,
+		Recorder: pool.Recorder(),
+		Watch:    pool.Watcher(),
+	})
+}
+
+func startAPIServer(apiAddr string, handler http.Handler) {
+	http.Handle(api.RPCPath, handler)
+	http.Handle("/api/", handler)
 	log.Println("fontend server is running at", apiAddr)
-	log.Fatal(http.ListenAndServe(apiAddr[7:], nil))
+	log.Fatal(http.ListenAndServe(hostOf(apiAddr), nil))
+}
+
+// the bucket of memcached clients, values carry their flags, so it is kept apart from kv
+func createMemcacheNode(pool *HttpPool) *cache.Node {
+	return pool.NewNode("memcache", 64<<20, cache.GetterLikeFunc(
+		func(key string) ([]byte, error) {
+			return nil, fmt.Errorf("%s: %w", key, cache.ErrNotFound)
+		}))
+}
+
+// select 0 is the kv namespace, select 1 the scores
+func startRESPServer(addr string, graph *cache.Graph) {
+	server := resp.NewServer(graph, resp.Options{Databases: []string{"kv", "scores"}})
+	log.Println("redis protocol server is running at", addr)
+	log.Fatal(server.ListenAndServe(addr))
+}
+
+// reads and the watch stream of the api over grpc
+// with the certificates of the peers a client needs one of them too, like a peer does,
+// without them anyone who reaches the port could read, so it only listens on loopback
+func startGRPCServer(addr string, handler *api.Handler, certs *peertls.Manager) {
+	var opts []grpc.ServerOption
+	if certs != nil {
+		opts = append(opts,
+			grpc.Creds(credentials.NewTLS(certs.ServerConfig())),
+			grpc.UnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, next grpc.UnaryHandler) (any, error) {
+				if err := verifiedClient(ctx); err != nil {
+					return nil, err
+				}
+				return next(ctx, req)
+			}),
+			grpc.StreamInterceptor(func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, next grpc.StreamHandler) error {
+				if err := verifiedClient(ss.Context()); err != nil {
+					return err
+				}
+				return next(srv, ss)
+			}),
+		)
+	} else if !isLoopback(addr) {
+		log.Fatalf("grpc at %s needs -tls-ca, -tls-cert and -tls-key, or a loopback address", addr)
+	}
+
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	server := grpc.NewServer(opts...)
+	handler.RegisterGRPC(server)
+	log.Println("grpc server is running at", addr)
+	log.Fatal(server.Serve(lis))
+}
+
+// the handshake checks the certificate already, a call over anything else is refused
+func verifiedClient(ctx context.Context) error {
+	p, ok := grpcpeer.FromContext(ctx)
+	if !ok {
+		return status.Error(codes.Unauthenticated, "no peer")
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 {
+		return status.Error(codes.Unauthenticated, "a client certificate is required")
+	}
+	return nil
+}
+
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func startMemcacheServer(addr string, graph *cache.Graph) {
+	server := memcache.NewServer(graph, memcache.Options{Namespace: "memcache"})
+	log.Println("memcached protocol server is running at", addr)
+	log.Fatal(server.ListenAndServe(addr))
 }
 
 func main() {
 	var port int
 	var api bool
+	var admin int
+	var healthInterval time.Duration
+	var gossipAddr, join string
+	var peersFile string
+	var rebalanceRate int
+	getterOpts := peer.DefaultHttpGetterOptions()
+	var breaker bool
+	var hedge time.Duration
+	var hedgeAdaptive bool
+	var tlsConf peertls.Config
+	var authKeys string
+	var authSkew time.Duration
+	var respAddr string
+	var memcacheAddr string
+	var traceOpts trace.RecorderOptions
+	var spread cache.SpreadOptions
+	var useBus bool
+	var useWatch bool
+	var grpcAddr string
 	flag.IntVar(&port, "port", 8001, "server port")
 	flag.BoolVar(&api, "api", false, "Start a api server?")
+	flag.IntVar(&admin, "admin", 0, "admin server port, 0 disables it")
+	flag.DurationVar(&healthInterval, "health", 2*time.Second, "peer health check interval, 0 disables it")
+	flag.StringVar(&gossipAddr, "gossip", "", "udp address for gossip membership, e.g. localhost:7946, the static peer list is used if empty")
+	flag.StringVar(&join, "join", "", "comma separated gossip addresses to join")
+	flag.StringVar(&peersFile, "peers", "", "json or yaml file with the peer list, reloaded on change")
+	flag.IntVar(&rebalanceRate, "rebalance", 10, "batches per second handed off after the ring changed, 0 disables it")
+	flag.DurationVar(&getterOpts.Timeout, "peer-timeout", getterOpts.Timeout, "timeout of a request to a peer")
+	flag.IntVar(&getterOpts.MaxRetries, "peer-retries", getterOpts.MaxRetries, "retries of a failed get from a peer")
+	flag.BoolVar(&breaker, "breaker", true, "skip peers whose circuit breaker is open")
+	flag.DurationVar(&hedge, "hedge", 0, "send a hedged request after this delay, 0 disables hedging")
+	flag.BoolVar(&hedgeAdaptive, "hedge-p95", false, "hedge after the observed p95 latency of the peer instead")
+	flag.StringVar(&tlsConf.CAFile, "tls-ca", "", "ca certificate of the peers, enables mutual tls together with -tls-cert and -tls-key")
+	flag.StringVar(&tlsConf.CertFile, "tls-cert", "", "certificate of this peer")
+	flag.StringVar(&tlsConf.KeyFile, "tls-key", "", "private key of this peer")
+	flag.DurationVar(&tlsConf.ReloadInterval, "tls-reload", time.Minute, "how often the tls files are checked for changes, 0 disables reloading")
+	flag.StringVar(&authKeys, "auth-keys", os.Getenv("EFIS_AUTH_KEYS"), "id:secret,... shared keys to sign peer requests with, the first one signs, rotated through /admin/keys, defaults to $EFIS_AUTH_KEYS")
+	flag.DurationVar(&authSkew, "auth-skew", 30*time.Second, "how far the clock of a signed request may be off")
+	flag.StringVar(&respAddr, "resp", "", "address of the redis protocol listener, e.g. localhost:6379, disabled if empty")
+	flag.StringVar(&memcacheAddr, "memcache", "", "address of the memcached protocol listener, e.g. localhost:11211, disabled if empty")
+	flag.StringVar(&traceOpts.Path, "trace", "", "record sampled key accesses to this file from the start, see /admin/trace")
+	flag.Float64Var(&traceOpts.SampleRate, "trace-sample", 0.1, "the fraction of the keys which are traced")
+	flag.Float64Var(&spread.Threshold, "spread", 100, "requests per second at which the owner copies a key to more members, 0 disables it")
+	flag.IntVar(&spread.Copies, "spread-copies", 2, "members after the owner which get a copy of a hot key")
+	flag.BoolVar(&useBus, "bus", true, "deliver writes and invalidations to every peer, with retries, so that copies anywhere are dropped")
+	flag.BoolVar(&useWatch, "watch", true, "stream the changes of the caches at /api/watch/ and over grpc")
+	flag.StringVar(&grpcAddr, "grpc", "", "address of the grpc listener, e.g. localhost:9090, disabled if empty, only loopback without -tls-cert")
 	flag.Parse()
 
+	scheme := "http"
+	if tlsConf.CAFile != "" {
+		scheme = "https"
+	}
+
 	apiAddr := "http://localhost:9999"
 	addrMap := map[int]string{
-		8001: "http://localhost:8001",
-		8002: "http://localhost:8002",
-		8003: "http://localhost:8003",
+		8001: scheme + "://localhost:8001",
+		8002: scheme + "://localhost:8002",
+		8003: scheme + "://localhost:8003",
 	}
 
 	var addrs []string
@@ -70,9 +273,80 @@ func main() {
 		addrs = append(addrs, v)
 	}
 
-	node := createNode()
+	pool := NewHttpPool(addrMap[port])
+	pool.SetGetterOptions(getterOpts)
+	if authKeys != "" {
+		keys, err := auth.ParseKeyRing(authKeys)
+		if err != nil {
+			log.Fatal(err)
+		}
+		pool.EnableAuth(keys, auth.VerifierOptions{MaxSkew: authSkew})
+	}
+	if breaker {
+		pool.EnableCircuitBreaker(peer.BreakerOptions{})
+	}
+	if useBus {
+		pool.EnableBus(bus.Options{})
+	}
+	if useWatch {
+		pool.EnableWatch(watch.Options{})
+	}
+	node := createNode(pool)
+	kv := createKVNode(pool)
+	bucket := createMemcacheNode(pool)
+	if hedge > 0 {
+		node.EnableHedging(cache.HedgeOptions{Delay: hedge, Adaptive: hedgeAdaptive})
+	}
+	if spread.Threshold > 0 {
+		for _, n := range []*cache.Node{node, kv, bucket} {
+			n.EnableSpreading(spread)
+		}
+	}
+	if respAddr != "" {
+		go startRESPServer(respAddr, pool.graph)
+	}
+	if memcacheAddr != "" {
+		go startMemcacheServer(memcacheAddr, pool.graph)
+	}
+	apiHandler := newAPIHandler(pool)
 	if api {
-		go startAPIServer(apiAddr, node)
+		go startAPIServer(apiAddr, apiHandler)
+	}
+	if traceOpts.Path != "" {
+		if err := pool.Recorder().Start(traceOpts); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		traceOpts.Path = fmt.Sprintf("efis-%d.trace", port)
+	}
+	if admin != 0 {
+		go startAdminServer(fmt.Sprintf("http://localhost:%d", admin), pool, traceOpts)
+	}
+	var disc discovery.Discovery = discovery.NewStatic(addrs...)
+	if peersFile != "" {
+		f, err := discovery.NewFile(peersFile, 0)
+		if err != nil {
+			log.Fatal(err)
+		}
+		disc = f
+	}
+
+	var certs *peertls.Manager
+	if tlsConf.CAFile != "" {
+		tlsConf.AllowedPeers = pool.Members
+		m, err := peertls.NewManager(tlsConf)
+		if err != nil {
+			log.Fatal(err)
+		}
+		certs = m
+	}
+	if grpcAddr != "" {
+		go startGRPCServer(grpcAddr, apiHandler, certs)
+	}
+
+	var seeds []string
+	if join != "" {
+		seeds = strings.Split(join, ",")
 	}
-	startCacheServer(addrMap[port], []string(addrs), node)
+	startCacheServer(pool, apiHandler, addrMap[port], disc, []*cache.Node{node, kv, bucket}, healthInterval, gossipAddr, seeds, rebalanceRate, certs)
 }
@@ -1,38 +1,84 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math/rand"
 	"net/http"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/golrice/e-fis/internal/api"
+	"github.com/golrice/e-fis/internal/auth"
+	"github.com/golrice/e-fis/internal/bus"
 	"github.com/golrice/e-fis/internal/cache"
 	"github.com/golrice/e-fis/internal/consistenthash"
+	"github.com/golrice/e-fis/internal/discovery"
+	"github.com/golrice/e-fis/internal/health"
+	"github.com/golrice/e-fis/internal/membership"
 	"github.com/golrice/e-fis/internal/peer"
 	pb "github.com/golrice/e-fis/internal/protocal"
+	"github.com/golrice/e-fis/internal/rebalance"
+	"github.com/golrice/e-fis/internal/stats"
+	"github.com/golrice/e-fis/internal/trace"
+	"github.com/golrice/e-fis/internal/watch"
 	"google.golang.org/protobuf/proto"
 )
 
-const defaultReplicas = 50
+const (
+	defaultReplicas = 50
+	// a write or a batch of a peer is read into memory at once
+	maxPeerBody = 8 << 20
+)
 
 type HttpPool struct {
-	info  HttpInfo
-	graph *cache.Graph
+	info       HttpInfo
+	graph      *cache.Graph
+	stats      *stats.Registry
+	getterOpts peer.HttpGetterOptions
+	verifier   *auth.Verifier
+	keys       *auth.KeyRing
+	recorder   *trace.Recorder
 
 	mu          sync.Mutex
+	members     []string
+	ring        []string
 	peers       *consistenthash.DHTMap
 	httpGetters map[string]*peer.HttpGetter
+	breakers    map[string]*peer.Breaker
+	breakerOpts *peer.BreakerOptions
+	health      *health.Checker
+	rebalancer  *rebalance.Rebalancer
+	bus         *bus.Bus
+	hub         *watch.Hub
+	// hot keys whose reads are spread over several members
+	spread      map[spreadID]spreadMark
+	spreadSweep int
 }
 
 func NewHttpPool(addr string) *HttpPool {
-	return &HttpPool{
+	p := &HttpPool{
 		info:        *NewHttpInfo(addr),
 		graph:       cache.DefaultGraph(),
+		stats:       stats.New(),
+		getterOpts:  peer.DefaultHttpGetterOptions(),
+		recorder:    trace.NewRecorder(),
 		mu:          sync.Mutex{},
 		peers:       nil,
 		httpGetters: nil,
 	}
+	p.stats.Gauge("ring_members", func() any { return p.RingMembers() })
+	p.stats.Gauge("spread_keys", func() any { return p.spreadKeys() })
+
+	return p
 }
 
 func (p *HttpPool) Log(format string, v ...any) {
@@ -46,6 +92,20 @@ func (p *HttpPool) NewNode(name string, capacity int64, getter cache.GetterLikeF
 	return node
 }
 
+// a namespace created at runtime, it takes part in the ring at once
+func (p *HttpPool) NewNodeWithPolicy(name string, capacity int64, policy string, getter cache.GetterLikeFunc) (*cache.Node, error) {
+	node, err := cache.NewNodeWithPolicy(name, capacity, policy, getter)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.graph.TryAddNode(node); err != nil {
+		return nil, err
+	}
+	node.RegisterPeers(p)
+
+	return node, nil
+}
+
 func (p *HttpPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// check whether it is a valid request
 	if !strings.HasPrefix(r.URL.Path, p.info.basePath) {
@@ -53,11 +113,38 @@ func (p *HttpPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !p.verify(w, r) {
+		return
+	}
+
+	// health probes are frequent, keep them out of the log
+	if r.URL.Path == p.info.basePath+peer.HealthPath {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// the bus is busy too
+	if r.Method == http.MethodPost && r.URL.Path == p.info.basePath+peer.BusPath {
+		p.serveBus(w, r)
+		return
+	}
+
 	p.Log("%s %s", r.Method, r.URL.Path)
 
 	// path -> <base>/<node_name>/<key>
 	s := strings.SplitN(r.URL.Path[len(p.info.basePath):], "/", 2)
 
+	// a batch is posted to <base>/<node_name>
+	if r.Method == http.MethodPost && len(s) == 1 {
+		p.serveSet(w, r, s[0])
+		return
+	}
+	// DELETE <base>/<node_name>?tag=, ?prefix= or ?generation=
+	if r.Method == http.MethodDelete && len(s) == 1 {
+		p.serveInvalidate(w, r, s[0])
+		return
+	}
+
 	if len(s) != 2 {
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
@@ -71,13 +158,34 @@ func (p *HttpPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	v, err := node.Get(key)
+	if r.Method == http.MethodDelete {
+		node.Remove(key)
+		// the owner drops its copies this way too
+		p.Spread(node_name, key, 0, time.Time{})
+		p.recorder.Record(trace.OpDelete, node_name, key, 0, "")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	v, source, err := node.Lookup(key)
+	if err != nil {
+		source = trace.ResultMiss
+	}
+	p.recorder.Record(trace.OpGet, node_name, key, v.Len(), source)
+	if errors.Is(err, cache.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	body, err := proto.Marshal(&pb.Response{Value: v.ByteSlice()})
+	out := &pb.Response{Value: v.ByteSlice(), Expire: cache.ExpireToNano(v.Expire())}
+	if copies, until := p.Spreading(node_name, key); copies > 0 {
+		out.Copies, out.SpreadUntil = int32(copies), until.UnixNano()
+	}
+	body, err := proto.Marshal(out)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -89,29 +197,710 @@ func (p *HttpPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// store a batch from a peer locally, we do not route it again
+// it is not traced, the server which took the write did that already
+func (p *HttpPool) serveSet(w http.ResponseWriter, r *http.Request, nodeName string) {
+	node, err := cache.GetNode(p.graph, nodeName)
+	if err != nil {
+		http.Error(w, "no such node", http.StatusNotFound)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPeerBody))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	in := &pb.SetRequest{}
+	if err := proto.Unmarshal(body, in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for _, e := range in.Entries {
+		node.SetLocalUntil(e.Key, e.Value, cache.ExpireFromNano(e.Expire), e.Tags...)
+	}
+	// copies of a hot key from its owner, they expire with the lease
+	if in.Copies > 0 {
+		for _, e := range in.Entries {
+			p.Spread(nodeName, e.Key, int(in.Copies), cache.ExpireFromNano(e.Expire))
+		}
+		p.stats.Add("spread_copies_received", int64(len(in.Entries)))
+	} else {
+		p.stats.Add("handoff_received", int64(len(in.Entries)))
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// apply a batch of invalidations from a peer, the answer tells it how far we are
+func (p *HttpPool) serveBus(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	b := p.bus
+	p.mu.Unlock()
+
+	if b == nil {
+		http.Error(w, "no bus", http.StatusNotFound)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPeerBody))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	in := &pb.BusBatch{}
+	if err := proto.Unmarshal(body, in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	out, err := proto.Marshal(&pb.BusAck{Delivered: b.Receive(in)})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	p.stats.Add("bus_received", int64(len(in.Messages)))
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(out)
+}
+
+// drop the keys of a tag, a prefix or an older generation locally, the peer which was asked
+// tells the others
+func (p *HttpPool) serveInvalidate(w http.ResponseWriter, r *http.Request, nodeName string) {
+	node, err := cache.GetNode(p.graph, nodeName)
+	if err != nil {
+		http.Error(w, "no such node", http.StatusNotFound)
+		return
+	}
+
+	q := r.URL.Query()
+	tag, prefix, gen := q.Get("tag"), q.Get("prefix"), q.Get("generation")
+	switch {
+	case tag != "" && prefix == "" && gen == "":
+		p.stats.Add("tag_invalidated", int64(node.RemoveTag(tag)))
+	case prefix != "" && tag == "" && gen == "":
+		p.stats.Add("prefix_invalidated", int64(node.RemovePrefix(prefix)))
+	case gen != "" && tag == "" && prefix == "":
+		n, err := strconv.ParseUint(gen, 10, 64)
+		if err != nil {
+			http.Error(w, "bad generation "+gen, http.StatusBadRequest)
+			return
+		}
+		node.AdvanceGeneration(n)
+	default:
+		http.Error(w, "need one of a tag, a prefix or a generation", http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// options for the clients of peers added from now on
+func (p *HttpPool) SetGetterOptions(opts peer.HttpGetterOptions) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.getterOpts = opts
+}
+
+// talk to the peers over tls, the getters of known peers are replaced
+func (p *HttpPool) EnableTLS(conf *tls.Config) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.getterOpts.TLSConfig = conf
+	p.resetGettersLocked()
+}
+
+// sign our requests to peers and only serve signed ones
+func (p *HttpPool) EnableAuth(keys *auth.KeyRing, opts auth.VerifierOptions) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.keys = keys
+	p.verifier = auth.NewVerifier(keys, opts)
+	p.getterOpts.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		return auth.NewTransport(keys, rt)
+	}
+	p.resetGettersLocked()
+}
+
+// recreate the getters after their options changed
+// must be called with p.mu held
+func (p *HttpPool) resetGettersLocked() {
+	p.httpGetters = nil
+	p.breakers = nil
+	p.setMembersLocked(p.members)
+}
+
+// all configured members, healthy or not
+func (p *HttpPool) Members() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]string(nil), p.members...)
+}
+
 func (p *HttpPool) Set(peers ...string) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	p.peers = consistenthash.New(defaultReplicas, nil)
-	p.peers.Add(peers...)
-	p.httpGetters = make(map[string]*peer.HttpGetter, len(peers))
+	p.setMembersLocked(peers)
+}
+
+// follow the peer set of a discovery provider instead of a one-time Set
+func (p *HttpPool) Subscribe(d discovery.Discovery) {
+	// watch first, so that we do not miss a change between the two calls
+	ch := d.Watch()
+	p.Set(d.Peers()...)
+
+	go func() {
+		for peers := range ch {
+			p.Log("peers changed: %v", peers)
+			p.stats.Inc("discovery_updates")
+			p.Set(peers...)
+		}
+	}()
+}
+
+// add a single member to the ring, it is a no-op for known members
+func (p *HttpPool) AddPeer(addr string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, m := range p.members {
+		if m == addr {
+			return
+		}
+	}
+	p.setMembersLocked(append(append([]string(nil), p.members...), addr))
+}
+
+func (p *HttpPool) RemovePeer(addr string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
+	members := make([]string, 0, len(p.members))
+	for _, m := range p.members {
+		if m != addr {
+			members = append(members, m)
+		}
+	}
+	p.setMembersLocked(members)
+}
+
+// must be called with p.mu held
+func (p *HttpPool) setMembersLocked(peers []string) {
+	p.members = append([]string(nil), peers...)
+
+	// keep the getters of known peers
+	getters := make(map[string]*peer.HttpGetter, len(peers))
 	for _, eachPeer := range peers {
-		p.httpGetters[eachPeer] = &peer.HttpGetter{BaseURL: eachPeer + p.info.basePath}
+		if g, ok := p.httpGetters[eachPeer]; ok {
+			getters[eachPeer] = g
+			continue
+		}
+		getters[eachPeer] = peer.NewHttpGetter(eachPeer+p.info.basePath, p.getterOpts)
+	}
+	p.httpGetters = getters
+
+	if p.breakerOpts != nil {
+		p.wrapBreakersLocked()
+	}
+
+	if p.health != nil {
+		p.health.Set(p.remoteMembers()...)
+	}
+	// unhealthy members stay on the bus, they catch up once they answer
+	if p.bus != nil {
+		p.bus.SetPeers(p.remoteMembers()...)
+	}
+
+	p.rebuild()
+}
+
+// rebuild the ring from the healthy members, keys which moved away are handed off
+// must be called with p.mu held
+func (p *HttpPool) rebuild() {
+	alive := p.aliveMembers()
+	sort.Strings(alive)
+
+	p.peers = consistenthash.New(defaultReplicas, nil)
+	p.peers.Add(alive...)
+
+	changed := !slices.Equal(alive, p.ring)
+	p.ring = alive
+
+	if changed && p.rebalancer != nil {
+		// the run asks for the owner with p.mu, so it must not be started under it
+		go p.rebalancer.Run(p.graph.Nodes(), p.owner)
+	}
+}
+
+// the member which owns the key in the current ring
+func (p *HttpPool) owner(key string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.peers == nil {
+		return ""
 	}
+
+	return p.peers.Get(key)
 }
 
+// wrap every peer in a circuit breaker, PickPeer skips peers whose breaker is open
+func (p *HttpPool) EnableCircuitBreaker(opts peer.BreakerOptions) {
+	onChange := opts.OnChange
+	opts.OnChange = func(name string, from, to peer.BreakerState) {
+		p.Log("circuit breaker of %s: %s -> %s", name, from, to)
+		p.stats.Inc("breaker_" + to.String())
+
+		if onChange != nil {
+			onChange(name, from, to)
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.breakerOpts != nil {
+		panic("EnableCircuitBreaker called more than once")
+	}
+	p.breakerOpts = &opts
+	p.wrapBreakersLocked()
+	p.stats.Gauge("breakers", func() any { return p.BreakerStatus() })
+}
+
+// keep the breakers of known peers
+// must be called with p.mu held
+func (p *HttpPool) wrapBreakersLocked() {
+	breakers := make(map[string]*peer.Breaker, len(p.httpGetters))
+	for addr, getter := range p.httpGetters {
+		if addr == p.info.addr {
+			continue
+		}
+		if b, ok := p.breakers[addr]; ok {
+			breakers[addr] = b
+			continue
+		}
+		breakers[addr] = peer.NewBreaker(addr, getter, *p.breakerOpts)
+	}
+	p.breakers = breakers
+}
+
+// the breaker of every remote member, nil if breakers are disabled
+func (p *HttpPool) BreakerStatus() map[string]peer.BreakerStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.breakerOpts == nil {
+		return nil
+	}
+
+	out := make(map[string]peer.BreakerStatus, len(p.breakers))
+	for addr, b := range p.breakers {
+		out[addr] = b.Status()
+	}
+
+	return out
+}
+
+// hand keys over to their new owner whenever the ring changes
+func (p *HttpPool) EnableRebalance(opts rebalance.Options) {
+	r := rebalance.New(p.info.addr, p.handOff, opts)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.rebalancer != nil {
+		panic("EnableRebalance called more than once")
+	}
+	p.rebalancer = r
+	p.stats.Gauge("rebalance", func() any { return r.Progress() })
+}
+
+func (p *HttpPool) handOff(owner string, in *pb.SetRequest) error {
+	p.mu.Lock()
+	getter, ok := p.httpGetters[owner]
+	p.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("unknown peer %s", owner)
+	}
+
+	if err := getter.Set(in); err != nil {
+		return err
+	}
+	p.stats.Add("handoff_sent", int64(len(in.Entries)))
+
+	return nil
+}
+
+// deliver the invalidations of the graph to every member, in order and with retries,
+// so that copies anywhere are dropped, not only at the owner of the key
+func (p *HttpPool) EnableBus(opts bus.Options) {
+	b := bus.New(p.info.addr, p.deliver, opts)
+
+	p.mu.Lock()
+	if p.bus != nil {
+		p.mu.Unlock()
+		panic("EnableBus called more than once")
+	}
+	p.bus = b
+	b.SetPeers(p.remoteMembers()...)
+	p.mu.Unlock()
+
+	p.graph.Subscribe(b)
+	p.stats.Gauge("bus", func() any { return b.Status() })
+}
+
+func (p *HttpPool) deliver(ctx context.Context, addr string, in *pb.BusBatch) (uint64, error) {
+	p.mu.Lock()
+	getter, ok := p.httpGetters[addr]
+	p.mu.Unlock()
+
+	if !ok {
+		return 0, fmt.Errorf("unknown peer %s", addr)
+	}
+
+	out := &pb.BusAck{}
+	if err := getter.Deliver(ctx, in, out); err != nil {
+		return 0, err
+	}
+	p.stats.Add("bus_sent", int64(len(in.Messages)))
+
+	return out.Delivered, nil
+}
+
+// stream the changes of the local caches to watchers, see api.WatchPath
+func (p *HttpPool) EnableWatch(opts watch.Options) {
+	h := watch.New(opts)
+
+	p.mu.Lock()
+	if p.hub != nil {
+		p.mu.Unlock()
+		panic("EnableWatch called more than once")
+	}
+	p.hub = h
+	p.mu.Unlock()
+
+	p.graph.Notify(h)
+	p.stats.Gauge("watch", func() any { return h.Status() })
+}
+
+// the changes of the local caches, nil if watching is disabled
+func (p *HttpPool) Watcher() *watch.Hub {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.hub
+}
+
+// progress of the last rebalance, nil if rebalancing is disabled
+func (p *HttpPool) RebalanceProgress() *rebalance.Progress {
+	p.mu.Lock()
+	r := p.rebalancer
+	p.mu.Unlock()
+
+	if r == nil {
+		return nil
+	}
+	progress := r.Progress()
+
+	return &progress
+}
+
+// we never eject ourselves
+// must be called with p.mu held
+func (p *HttpPool) aliveMembers() []string {
+	alive := make([]string, 0, len(p.members))
+	for _, m := range p.members {
+		if m == p.info.addr || p.health == nil || p.health.Healthy(m) {
+			alive = append(alive, m)
+		}
+	}
+
+	return alive
+}
+
+// must be called with p.mu held
+func (p *HttpPool) remoteMembers() []string {
+	remote := make([]string, 0, len(p.members))
+	for _, m := range p.members {
+		if m != p.info.addr {
+			remote = append(remote, m)
+		}
+	}
+
+	return remote
+}
+
+// start probing the other members, unhealthy ones are removed from the ring until they recover
+func (p *HttpPool) EnableHealthCheck(opts health.Options) {
+	onChange := opts.OnChange
+	opts.OnChange = func(addr string, healthy bool) {
+		if healthy {
+			p.Log("peer %s is healthy again, add it back to the ring", addr)
+			p.stats.Inc("peer_recoveries")
+		} else {
+			p.Log("peer %s is unhealthy, eject it from the ring", addr)
+			p.stats.Inc("peer_ejections")
+		}
+
+		p.mu.Lock()
+		p.rebuild()
+		p.mu.Unlock()
+
+		if onChange != nil {
+			onChange(addr, healthy)
+		}
+	}
+
+	checker := health.New(p.ping, opts)
+
+	p.mu.Lock()
+	if p.health != nil {
+		p.mu.Unlock()
+		panic("EnableHealthCheck called more than once")
+	}
+	p.health = checker
+	checker.Set(p.remoteMembers()...)
+	p.mu.Unlock()
+
+	p.stats.Gauge("peer_health", func() any { return p.PeerHealth() })
+	checker.Start()
+}
+
+// let the gossip membership drive the ring, members are named by their peer address
+func (p *HttpPool) JoinGossip(conf membership.Config, seeds ...string) (*membership.Memberlist, error) {
+	conf.Name = p.info.addr
+	onEvent := conf.OnEvent
+	conf.OnEvent = func(ev membership.Event) {
+		switch ev.Type {
+		case membership.EventJoin:
+			p.AddPeer(ev.Member.Name)
+		case membership.EventLeave, membership.EventFail:
+			p.RemovePeer(ev.Member.Name)
+		}
+		p.stats.Inc("gossip_" + ev.Type.String())
+
+		if onEvent != nil {
+			onEvent(ev)
+		}
+	}
+
+	p.AddPeer(p.info.addr)
+
+	ml, err := membership.Create(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(seeds) > 0 {
+		if _, err := ml.Join(seeds...); err != nil {
+			p.Log("fail to join the cluster, we wait for others to find us: %s", err.Error())
+		}
+	}
+
+	p.stats.Gauge("gossip_members", func() any { return ml.AllMembers() })
+
+	return ml, nil
+}
+
+func (p *HttpPool) ping(addr string) error {
+	p.mu.Lock()
+	getter, ok := p.httpGetters[addr]
+	p.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("unknown peer %s", addr)
+	}
+
+	return getter.Ping()
+}
+
+// the health state of every remote member, nil if health checking is disabled
+func (p *HttpPool) PeerHealth() map[string]health.Status {
+	p.mu.Lock()
+	checker := p.health
+	p.mu.Unlock()
+
+	if checker == nil {
+		return nil
+	}
+
+	return checker.Status()
+}
+
+// whether the request is signed, if auth is enabled, it answers 401 otherwise, or 413 for a body too large to check
+func (p *HttpPool) verify(w http.ResponseWriter, r *http.Request) bool {
+	p.mu.Lock()
+	verifier := p.verifier
+	p.mu.Unlock()
+
+	if verifier == nil {
+		return true
+	}
+	if err := verifier.Verify(r); err != nil {
+		p.stats.Inc("auth_failures")
+		p.Log("reject %s %s: %s", r.Method, r.URL.Path, err.Error())
+		if errors.Is(err, auth.ErrTooLarge) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return false
+		}
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// h only serves requests signed like those of the peers, e.g. the api on the peer port
+func (p *HttpPool) Verified(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p.verify(w, r) {
+			h.ServeHTTP(w, r)
+		}
+	})
+}
+
+// the keys requests are signed with, nil without auth
+func (p *HttpPool) Keys() *auth.KeyRing {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.keys
+}
+
+// the sampled access trace of the peer port and the api, it is off until it is started
+func (p *HttpPool) Recorder() *trace.Recorder {
+	return p.recorder
+}
+
+// the counters of the pool and of every node
+func (p *HttpPool) StatsSnapshot() map[string]any {
+	nodes := map[string]any{}
+	for _, node := range p.graph.Nodes() {
+		nodes[node.Name()] = node.Stats()
+	}
+
+	return map[string]any{
+		"pool":  p.stats.Snapshot(),
+		"nodes": nodes,
+	}
+}
+
+// what smart clients need to route keys like PickPeer does
+func (p *HttpPool) ClusterInfo() api.ClusterInfo {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return api.ClusterInfo{
+		Self:     p.info.addr,
+		Members:  append([]string(nil), p.members...),
+		Ring:     append([]string(nil), p.ring...),
+		Replicas: defaultReplicas,
+	}
+}
+
+// the members which are currently part of the ring
+func (p *HttpPool) RingMembers() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.aliveMembers()
+}
+
+// the owner of the key, a spread key is read through PickHolder, which knows its namespace
 func (p *HttpPool) PickPeer(key string) (peer.PeerGetter, bool) {
+	return p.PickOwner(key)
+}
+
+// the owner of the key, even if its reads are spread
+func (p *HttpPool) PickOwner(key string) (peer.PeerGetter, bool) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	if target := p.peers.Get(key); target != "" && target != p.info.addr {
-		p.Log("Pick peer %s", target)
-		return p.httpGetters[target], true
+	if p.peers == nil {
+		return nil, false
 	}
 
-	return nil, false
+	return p.pickLocked(p.peers.Get(key))
+}
+
+// must be called with p.mu held
+func (p *HttpPool) pickLocked(target string) (peer.PeerGetter, bool) {
+	if target == "" || target == p.info.addr {
+		return nil, false
+	}
+
+	var getter peer.PeerGetter = p.httpGetters[target]
+	if b, ok := p.breakers[target]; ok {
+		// do not wait on a peer which is known to be failing, load it ourselves
+		if !b.Allow() {
+			p.stats.Inc("breaker_skips")
+			return nil, false
+		}
+		getter = b
+	}
+
+	p.Log("Pick peer %s", target)
+	return getter, true
+}
+
+// every member of the ring but us
+func (p *HttpPool) PickAll() []peer.PeerGetter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	getters := make([]peer.PeerGetter, 0, len(p.ring))
+	for _, m := range p.ring {
+		if g, ok := p.httpGetters[m]; ok && m != p.info.addr {
+			getters = append(getters, g)
+		}
+	}
+
+	return getters
+}
+
+// the owner of the key and the members holding a copy, those whose breaker is open are skipped
+func (p *HttpPool) PickReplicas(namespace, key string) []peer.PeerGetter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.peers == nil {
+		return nil
+	}
+
+	holders := p.holdersLocked(namespace, key)
+	if holders == nil {
+		holders = []string{p.peers.Get(key)}
+	}
+
+	getters := make([]peer.PeerGetter, 0, len(holders))
+	for _, i := range rand.Perm(len(holders)) {
+		if g, ok := p.pickLocked(holders[i]); ok {
+			getters = append(getters, g)
+		}
+	}
+	return getters
 }
 
 var _ peer.PeerPicker = (*HttpPool)(nil)
+var _ peer.ReplicaPicker = (*HttpPool)(nil)
+var _ peer.Spreader = (*HttpPool)(nil)
+var _ peer.PeerLister = (*HttpPool)(nil)
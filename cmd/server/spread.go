@@ -0,0 +1,130 @@
+package main
+
+import (
+	"math/rand"
+	"slices"
+	"time"
+
+	"github.com/golrice/e-fis/internal/peer"
+)
+
+// the marks of keys which are not read anymore are dropped once there are this many
+const minSpreadSweep = 64
+
+// a key is hot in a namespace, the same key in another one is read from its owner
+type spreadID struct {
+	namespace string
+	key       string
+}
+
+type spreadMark struct {
+	copies int
+	until  time.Time
+}
+
+// the owner and the members holding a copy, nil if the key is not spread
+// a member holding a copy reads from the owner itself, so a read takes two hops at most
+// must be called with p.mu held
+func (p *HttpPool) holdersLocked(namespace, key string) []string {
+	id := spreadID{namespace, key}
+	mark, ok := p.spread[id]
+	if !ok {
+		return nil
+	}
+	if !time.Now().Before(mark.until) {
+		delete(p.spread, id)
+		return nil
+	}
+
+	holders := p.peers.GetN(key, 1+mark.copies)
+	if slices.Contains(holders, p.info.addr) {
+		return nil
+	}
+	return holders
+}
+
+// a spread key is read from its owner or one of the members holding a copy
+func (p *HttpPool) PickHolder(namespace, key string) (peer.PeerGetter, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.peers == nil {
+		return nil, false
+	}
+
+	target := p.peers.Get(key)
+	if holders := p.holdersLocked(namespace, key); len(holders) > 0 {
+		target = holders[rand.Intn(len(holders))]
+		p.stats.Inc("spread_reads")
+	}
+
+	return p.pickLocked(target)
+}
+
+func (p *HttpPool) PickCopies(key string, n int) []peer.PeerGetter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.peers == nil {
+		return nil
+	}
+
+	members := p.peers.GetN(key, 1+n)
+	if len(members) == 0 || members[0] != p.info.addr {
+		return nil
+	}
+
+	// copies are pushed and dropped, the breakers only guard reads
+	getters := make([]peer.PeerGetter, 0, n)
+	for _, m := range members[1:] {
+		if g, ok := p.httpGetters[m]; ok {
+			getters = append(getters, g)
+		}
+	}
+	return getters
+}
+
+func (p *HttpPool) Spread(namespace, key string, copies int, until time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	id := spreadID{namespace, key}
+	now := time.Now()
+	if copies <= 0 || !now.Before(until) {
+		delete(p.spread, id)
+		return
+	}
+
+	if p.spread == nil {
+		p.spread = make(map[spreadID]spreadMark)
+	}
+	p.spread[id] = spreadMark{copies: copies, until: until}
+
+	if len(p.spread) >= max(p.spreadSweep, minSpreadSweep) {
+		for k, mark := range p.spread {
+			if !now.Before(mark.until) {
+				delete(p.spread, k)
+			}
+		}
+		p.spreadSweep = 2 * len(p.spread)
+	}
+}
+
+func (p *HttpPool) Spreading(namespace, key string) (int, time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	mark, ok := p.spread[spreadID{namespace, key}]
+	if !ok || !time.Now().Before(mark.until) {
+		return 0, time.Time{}
+	}
+	return mark.copies, mark.until
+}
+
+// the keys this server spreads reads of, as owner, holder of a copy or reader
+func (p *HttpPool) spreadKeys() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.spread)
+}
@@ -0,0 +1,388 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golrice/e-fis/internal/cache"
+	"github.com/golrice/e-fis/internal/trace"
+	"github.com/golrice/e-fis/internal/watch"
+)
+
+const (
+	Prefix = "/api/v1/"
+
+	defaultMaxValueSize = 1 << 20
+	defaultTimeout      = 5 * time.Second
+	maxNamespaceBody    = 4 << 10
+)
+
+var namespacePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+type Options struct {
+	// creates and registers a namespace, namespaces can not be created if nil
+	Create func(name string, capacity int64, policy string) (*cache.Node, error)
+	// larger values are refused with 413
+	MaxValueSize int64
+	// a read which takes longer fails with 504
+	Timeout time.Duration
+	// the namespace of RPCPath requests which name none
+	DefaultNamespace string
+	// the ring of the server, ClusterPath is not served if nil
+	Cluster func() ClusterInfo
+	// the counters of the server, StatsPath is not served if nil
+	Stats func() map[string]any
+	// sampled reads and writes are recorded to it, nothing is recorded if nil
+	Recorder *trace.Recorder
+	// the changes of the graph, WatchPath is not served if nil
+	Watch *watch.Hub
+}
+
+// handler serves the rest api below /api/v1/, and single reads at /api
+//
+//	GET    /api/v1/                   list the namespaces
+//	GET    /api/v1/{namespace}        describe a namespace
+//	PUT    /api/v1/{namespace}        create a namespace, {"capacity": 1024, "policy": "lru"}
+//	DELETE /api/v1/{namespace}?tag=   drop the keys of a tag everywhere, ?prefix= those with a prefix,
+//	                                  ?all=true all of them by bumping the generation
+//	GET    /api/v1/{namespace}/{key}  read a value, HEAD reads only its headers
+//	PUT    /api/v1/{namespace}/{key}  store the body, ?ttl=30s lets it expire, ?tag=a&tag=b tags it
+//	DELETE /api/v1/{namespace}/{key}  drop a value
+//	POST   /api                       read the key of a pb.Request, as protobuf or json
+//	GET    /api?namespace=&key=       the same, with the raw value as reply by default
+//	GET    /api/cluster               the members and the ring of the server
+//	GET    /api/stats                 the counters of the server
+//	GET    /api/watch/{namespace}     stream the changes of the namespace as server-sent events,
+//	                                  ?prefix= of some keys, see serveWatch
+type Handler struct {
+	graph *cache.Graph
+	opts  Options
+}
+
+func NewHandler(graph *cache.Graph, opts Options) *Handler {
+	if opts.MaxValueSize <= 0 {
+		opts.MaxValueSize = defaultMaxValueSize
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultTimeout
+	}
+
+	return &Handler{graph: graph, opts: opts}
+}
+
+type errorBody struct {
+	Error  string `json:"error"`
+	Status int    `json:"status"`
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorBody{Error: msg, Status: status})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("[API] fail to write the response: %s", err.Error())
+	}
+}
+
+// the status of a failed read or write
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, cache.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	}
+	// the owner or the source of the value failed
+	return http.StatusServiceUnavailable
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if info, ok := h.cluster(); ok {
+		w.Header().Set(HeaderRingVersion, info.Version)
+	}
+
+	if r.URL.Path == ClusterPath {
+		h.serveCluster(w, r)
+		return
+	}
+	if r.URL.Path == StatsPath {
+		h.serveStats(w, r)
+		return
+	}
+	if r.URL.Path == RPCPath {
+		h.serveRPC(w, r)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, WatchPath) {
+		h.serveWatch(w, r)
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, Prefix) {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	namespace, key, hasKey := strings.Cut(r.URL.Path[len(Prefix):], "/")
+	switch {
+	case namespace == "":
+		h.serveList(w, r)
+	case !hasKey:
+		h.serveNamespace(w, r, namespace)
+	default:
+		h.serveKey(w, r, namespace, key)
+	}
+}
+
+type namespaceInfo struct {
+	Name       string         `json:"name"`
+	Policy     string         `json:"policy"`
+	Capacity   int64          `json:"capacity"`
+	Keys       int            `json:"keys"`
+	Generation uint64         `json:"generation"`
+	Stats      map[string]any `json:"stats,omitempty"`
+}
+
+// keys counts what is cached on this server only
+func describe(node *cache.Node, stats bool) namespaceInfo {
+	info := namespaceInfo{
+		Name:       node.Name(),
+		Policy:     node.Policy(),
+		Capacity:   node.Capacity(),
+		Keys:       len(node.Keys()),
+		Generation: node.Generation(),
+	}
+	if stats {
+		info.Stats = node.Stats()
+	}
+
+	return info
+}
+
+func (h *Handler) serveList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	nodes := h.graph.Nodes()
+	infos := make([]namespaceInfo, 0, len(nodes))
+	for _, node := range nodes {
+		infos = append(infos, describe(node, false))
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"namespaces": infos})
+}
+
+type createRequest struct {
+	Capacity int64  `json:"capacity"`
+	Policy   string `json:"policy"`
+}
+
+func (h *Handler) serveNamespace(w http.ResponseWriter, r *http.Request, name string) {
+	switch r.Method {
+	case http.MethodGet:
+		node, err := cache.GetNode(h.graph, name)
+		if err != nil {
+			writeError(w, http.StatusNotFound, "no such namespace "+name)
+			return
+		}
+		writeJSON(w, http.StatusOK, describe(node, true))
+	case http.MethodPut:
+		h.createNamespace(w, r, name)
+	case http.MethodDelete:
+		h.invalidate(w, r, name)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (h *Handler) createNamespace(w http.ResponseWriter, r *http.Request, name string) {
+	if h.opts.Create == nil {
+		writeError(w, http.StatusMethodNotAllowed, "namespaces can not be created")
+		return
+	}
+	if !namespacePattern.MatchString(name) {
+		writeError(w, http.StatusBadRequest, "bad namespace name "+name)
+		return
+	}
+
+	req := createRequest{Policy: "lru"}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxNamespaceBody))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			writeError(w, http.StatusBadRequest, "bad body: "+err.Error())
+			return
+		}
+	}
+	if req.Capacity < 0 {
+		writeError(w, http.StatusBadRequest, "capacity must not be negative")
+		return
+	}
+
+	node, err := h.opts.Create(name, req.Capacity, req.Policy)
+	if errors.Is(err, cache.ErrNodeExists) {
+		writeError(w, http.StatusConflict, "namespace "+name+" already exists")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, describe(node, false))
+}
+
+func (h *Handler) invalidate(w http.ResponseWriter, r *http.Request, name string) {
+	node, err := cache.GetNode(h.graph, name)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "no such namespace "+name)
+		return
+	}
+
+	q := r.URL.Query()
+	tag, prefix, all := q.Get("tag"), q.Get("prefix"), q.Get("all") == "true"
+	switch {
+	case tag != "" && prefix == "" && !all:
+		err = node.InvalidateTag(tag)
+	case prefix != "" && tag == "" && !all:
+		err = node.InvalidatePrefix(prefix)
+	case all && tag == "" && prefix == "":
+		_, err = node.Bump()
+	default:
+		writeError(w, http.StatusBadRequest, "need one of a tag, a prefix or all=true")
+		return
+	}
+	if err != nil {
+		writeError(w, statusOf(err), err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) serveKey(w http.ResponseWriter, r *http.Request, namespace, key string) {
+	if key == "" {
+		writeError(w, http.StatusBadRequest, "empty key")
+		return
+	}
+
+	node, err := cache.GetNode(h.graph, namespace)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "no such namespace "+namespace)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		h.get(w, r, node, key)
+	case http.MethodPut:
+		h.put(w, r, node, key)
+	case http.MethodDelete:
+		h.opts.Recorder.Record(trace.OpDelete, node.Name(), key, 0, "")
+		if err := node.Delete(key); err != nil {
+			writeError(w, statusOf(err), err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (h *Handler) get(w http.ResponseWriter, r *http.Request, node *cache.Node, key string) {
+	ctx, cancel := context.WithTimeout(r.Context(), h.opts.Timeout)
+	defer cancel()
+
+	v, err := h.lookup(ctx, node, key)
+	if err != nil {
+		writeError(w, statusOf(err), err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(v.Len()))
+	if expire := v.Expire(); !expire.IsZero() {
+		w.Header().Set("Expires", expire.UTC().Format(http.TimeFormat))
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write(v.ByteSlice())
+}
+
+// a read, which is traced if the key is sampled
+func (h *Handler) lookup(ctx context.Context, node *cache.Node, key string) (cache.ByteView, error) {
+	v, source, err := node.LookupContext(ctx, key)
+	if err != nil {
+		source = trace.ResultMiss
+	}
+	h.opts.Recorder.Record(trace.OpGet, node.Name(), key, v.Len(), source)
+
+	return v, err
+}
+
+// ttl is a duration like 30s, or a number of seconds
+func parseTTL(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		s = strconv.FormatInt(n, 10) + "s"
+	}
+
+	ttl, err := time.ParseDuration(s)
+	if err != nil || ttl < 0 {
+		return 0, errors.New("bad ttl " + s)
+	}
+
+	return ttl, nil
+}
+
+func (h *Handler) put(w http.ResponseWriter, r *http.Request, node *cache.Node, key string) {
+	ttl, err := parseTTL(r.URL.Query().Get("ttl"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if r.ContentLength > h.opts.MaxValueSize {
+		writeError(w, http.StatusRequestEntityTooLarge, "value too large")
+		return
+	}
+	value, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.opts.MaxValueSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, "value too large")
+			return
+		}
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	h.opts.Recorder.Record(trace.OpSet, node.Name(), key, len(value), "")
+	if err := node.Set(key, value, ttl, r.URL.Query()["tag"]...); err != nil {
+		writeError(w, statusOf(err), err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
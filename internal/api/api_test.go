@@ -0,0 +1,403 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golrice/e-fis/internal/cache"
+	pb "github.com/golrice/e-fis/internal/protocal"
+	"github.com/golrice/e-fis/internal/trace"
+	"github.com/golrice/e-fis/internal/watch"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	graph := cache.DefaultGraph()
+	graph.AddNode(cache.NewNode("kv", 2<<10, func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("%s: %w", key, cache.ErrNotFound)
+	}))
+	graph.AddNode(cache.NewNode("broken", 2<<10, func(key string) ([]byte, error) {
+		if key == "slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		return nil, errors.New("database is down")
+	}))
+
+	h := NewHandler(graph, Options{
+		MaxValueSize: 8,
+		Timeout:      50 * time.Millisecond,
+		Create: func(name string, capacity int64, policy string) (*cache.Node, error) {
+			node, err := cache.NewNodeWithPolicy(name, capacity, policy, func(key string) ([]byte, error) {
+				return nil, cache.ErrNotFound
+			})
+			if err != nil {
+				return nil, err
+			}
+			return node, graph.TryAddNode(node)
+		},
+	})
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func do(t *testing.T, method, url, body string) (*http.Response, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	b, _ := io.ReadAll(res.Body)
+
+	return res, string(b)
+}
+
+func expect(t *testing.T, method, url, body string, status int) string {
+	t.Helper()
+
+	res, got := do(t, method, url, body)
+	if res.StatusCode != status {
+		t.Fatalf("%s %s: got %d %s, want %d", method, url, res.StatusCode, got, status)
+	}
+	if status >= 400 && method != http.MethodHead {
+		var e errorBody
+		if err := json.Unmarshal([]byte(got), &e); err != nil || e.Status != status || e.Error == "" {
+			t.Fatalf("%s %s: bad error body %q", method, url, got)
+		}
+	}
+
+	return got
+}
+
+func TestHandler_Keys(t *testing.T) {
+	srv := newTestServer(t)
+	base := srv.URL + Prefix
+
+	expect(t, http.MethodGet, base+"kv/a", "", http.StatusNotFound)
+	expect(t, http.MethodPut, base+"kv/a", "1", http.StatusNoContent)
+	if got := expect(t, http.MethodGet, base+"kv/a", "", http.StatusOK); got != "1" {
+		t.Fatalf("got %q", got)
+	}
+
+	expect(t, http.MethodPut, base+"kv/dir/b", "2", http.StatusNoContent)
+	if got := expect(t, http.MethodGet, base+"kv/dir/b", "", http.StatusOK); got != "2" {
+		t.Fatalf("a key with a slash: got %q", got)
+	}
+
+	expect(t, http.MethodPut, base+"kv/t?ttl=1h", "3", http.StatusNoContent)
+	res, _ := do(t, http.MethodHead, base+"kv/t", "")
+	if res.StatusCode != http.StatusOK || res.Header.Get("Expires") == "" || res.ContentLength != 1 {
+		t.Fatalf("head: %d %v", res.StatusCode, res.Header)
+	}
+
+	expect(t, http.MethodDelete, base+"kv/a", "", http.StatusNoContent)
+	expect(t, http.MethodGet, base+"kv/a", "", http.StatusNotFound)
+
+	expect(t, http.MethodGet, base+"nope/a", "", http.StatusNotFound)
+	expect(t, http.MethodPut, base+"kv/a?ttl=soon", "1", http.StatusBadRequest)
+	expect(t, http.MethodPut, base+"kv/a", "123456789", http.StatusRequestEntityTooLarge)
+	expect(t, http.MethodGet, base+"broken/a", "", http.StatusServiceUnavailable)
+	expect(t, http.MethodGet, base+"broken/slow", "", http.StatusGatewayTimeout)
+	expect(t, http.MethodPost, base+"kv/a", "", http.StatusMethodNotAllowed)
+}
+
+func TestHandler_Namespaces(t *testing.T) {
+	srv := newTestServer(t)
+	base := srv.URL + Prefix
+
+	expect(t, http.MethodPut, base+"users", `{"capacity": 1024, "policy": "lfu"}`, http.StatusCreated)
+	expect(t, http.MethodPut, base+"users", "", http.StatusConflict)
+	expect(t, http.MethodPut, base+"bad", `{"policy": "random"}`, http.StatusBadRequest)
+	expect(t, http.MethodPut, base+"bad", `{`, http.StatusBadRequest)
+	expect(t, http.MethodPut, base+"b%20d", "", http.StatusBadRequest)
+
+	expect(t, http.MethodPut, base+"users/u1", "x", http.StatusNoContent)
+
+	var info namespaceInfo
+	json.Unmarshal([]byte(expect(t, http.MethodGet, base+"users", "", http.StatusOK)), &info)
+	if info.Name != "users" || info.Policy != "lfu" || info.Capacity != 1024 || info.Keys != 1 {
+		t.Fatalf("describe: %+v", info)
+	}
+
+	var list struct {
+		Namespaces []namespaceInfo `json:"namespaces"`
+	}
+	json.Unmarshal([]byte(expect(t, http.MethodGet, base, "", http.StatusOK)), &list)
+	var names []string
+	for _, ns := range list.Namespaces {
+		names = append(names, ns.Name)
+	}
+	if strings.Join(names, ",") != "broken,kv,users" {
+		t.Fatalf("list: %v", names)
+	}
+
+	expect(t, http.MethodGet, base+"nope", "", http.StatusNotFound)
+}
+
+func TestHandler_Invalidate(t *testing.T) {
+	srv := newTestServer(t)
+	base := srv.URL + Prefix
+
+	expect(t, http.MethodPut, base+"kv/u1:a?tag=u1&tag=names", "1", http.StatusNoContent)
+	expect(t, http.MethodPut, base+"kv/u1:b?tag=u1", "2", http.StatusNoContent)
+	expect(t, http.MethodPut, base+"kv/u2:a?tag=u2", "3", http.StatusNoContent)
+
+	expect(t, http.MethodDelete, base+"kv?tag=u1", "", http.StatusNoContent)
+	expect(t, http.MethodGet, base+"kv/u1:a", "", http.StatusNotFound)
+	expect(t, http.MethodGet, base+"kv/u1:b", "", http.StatusNotFound)
+	expect(t, http.MethodGet, base+"kv/u2:a", "", http.StatusOK)
+
+	expect(t, http.MethodDelete, base+"kv?prefix=u2:", "", http.StatusNoContent)
+	expect(t, http.MethodGet, base+"kv/u2:a", "", http.StatusNotFound)
+
+	expect(t, http.MethodPut, base+"kv/u3:a", "4", http.StatusNoContent)
+	expect(t, http.MethodDelete, base+"kv?all=true", "", http.StatusNoContent)
+	expect(t, http.MethodGet, base+"kv/u3:a", "", http.StatusNotFound)
+	var info namespaceInfo
+	json.Unmarshal([]byte(expect(t, http.MethodGet, base+"kv", "", http.StatusOK)), &info)
+	if info.Generation != 1 {
+		t.Fatalf("want generation 1 after a flush, got %d", info.Generation)
+	}
+
+	expect(t, http.MethodDelete, base+"kv", "", http.StatusBadRequest)
+	expect(t, http.MethodDelete, base+"kv?tag=a&prefix=b", "", http.StatusBadRequest)
+	expect(t, http.MethodDelete, base+"kv?tag=a&all=true", "", http.StatusBadRequest)
+	expect(t, http.MethodDelete, base+"nope?tag=a", "", http.StatusNotFound)
+}
+
+func TestHandler_Trace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace")
+	recorder := trace.NewRecorder()
+	if err := recorder.Start(trace.RecorderOptions{Path: path, SampleRate: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	graph := cache.DefaultGraph()
+	graph.AddNode(cache.NewNode("traced", 2<<10, func(key string) ([]byte, error) {
+		return []byte("loaded"), nil
+	}))
+	srv := httptest.NewServer(NewHandler(graph, Options{Recorder: recorder}))
+	defer srv.Close()
+	base := srv.URL + Prefix + "traced/"
+
+	expect(t, http.MethodGet, base+"a", "", http.StatusOK)
+	expect(t, http.MethodGet, base+"a", "", http.StatusOK)
+	expect(t, http.MethodPut, base+"b", "12", http.StatusNoContent)
+	expect(t, http.MethodDelete, base+"b", "", http.StatusNoContent)
+	recorder.Stop()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := trace.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, r := range records {
+		if r.Namespace != "traced" || r.Key == "a" || r.Key == "b" {
+			t.Fatalf("the keys should be hashed, got %v", r)
+		}
+		got = append(got, fmt.Sprintf("%s %d %s", r.Op, r.Size, r.Result))
+	}
+	want := "get 6 local,get 6 hit,set 2 ,del 0 "
+	if strings.Join(got, ",") != want {
+		t.Fatalf("want %q, got %q", want, strings.Join(got, ","))
+	}
+}
+
+type sseEvent struct {
+	id, event, data string
+}
+
+// the events of a stream, until it ends
+func readEvents(t *testing.T, url, lastID string) (<-chan sseEvent, func()) {
+	t.Helper()
+
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("watch: %d %v", res.StatusCode, res.Header)
+	}
+
+	events := make(chan sseEvent, 16)
+	go func() {
+		defer close(events)
+		var ev sseEvent
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			field, value, _ := strings.Cut(scanner.Text(), ": ")
+			switch field {
+			case "id":
+				ev.id = value
+			case "event":
+				ev.event = value
+			case "data":
+				ev.data = value
+			case "":
+				events <- ev
+				ev = sseEvent{}
+			}
+		}
+	}()
+
+	return events, func() { res.Body.Close() }
+}
+
+func next(t *testing.T, events <-chan sseEvent) sseEvent {
+	t.Helper()
+
+	select {
+	case ev := <-events:
+		return ev
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event")
+	}
+	return sseEvent{}
+}
+
+func newWatchedServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	hub := watch.New(watch.Options{})
+	graph := cache.DefaultGraph()
+	graph.Notify(hub)
+	graph.AddNode(cache.NewNode("kv", 2<<10, func(key string) ([]byte, error) {
+		return nil, cache.ErrNotFound
+	}))
+	srv := httptest.NewServer(NewHandler(graph, Options{Watch: hub}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestHandler_Watch(t *testing.T) {
+	srv := newWatchedServer(t)
+	base := srv.URL + Prefix + "kv/"
+	watchURL := srv.URL + WatchPath + "kv?prefix=u:"
+
+	events, stop := readEvents(t, watchURL, "")
+	if ev := next(t, events); ev.id == "" || ev.event != "" {
+		t.Fatalf("want the token first, got %+v", ev)
+	}
+
+	expect(t, http.MethodPut, base+"u:1?ttl=1h", "1", http.StatusNoContent)
+	expect(t, http.MethodPut, base+"x", "2", http.StatusNoContent)
+	expect(t, http.MethodDelete, base+"u:1", "", http.StatusNoContent)
+
+	set := next(t, events)
+	var body eventBody
+	if err := json.Unmarshal([]byte(set.data), &body); err != nil {
+		t.Fatal(err)
+	}
+	if set.event != "set" || body.Key != "u:1" || body.Namespace != "kv" || body.Expire == nil || body.Token != set.id {
+		t.Fatalf("unexpected set %+v", set)
+	}
+	del := next(t, events)
+	if del.event != "delete" || !strings.Contains(del.data, `"key":"u:1"`) {
+		t.Fatalf("unexpected delete %+v", del)
+	}
+	stop()
+
+	// what happens while we are away is replayed
+	expect(t, http.MethodPut, base+"u:2", "3", http.StatusNoContent)
+	events, stop = readEvents(t, watchURL, del.id)
+	defer stop()
+	if ev := next(t, events); ev.event != "set" || !strings.Contains(ev.data, `"key":"u:2"`) {
+		t.Fatalf("want the missed set, got %+v", ev)
+	}
+	if ev := next(t, events); ev.event != "" {
+		t.Fatalf("want the current token after the replay, got %+v", ev)
+	}
+
+	expect(t, http.MethodGet, srv.URL+WatchPath+"kv?after=nope", "", http.StatusBadRequest)
+	expect(t, http.MethodGet, srv.URL+WatchPath+"nope", "", http.StatusNotFound)
+	expect(t, http.MethodPost, srv.URL+WatchPath+"kv", "", http.StatusMethodNotAllowed)
+}
+
+func TestHandler_GRPC(t *testing.T) {
+	hub := watch.New(watch.Options{})
+	graph := cache.DefaultGraph()
+	graph.Notify(hub)
+	node := cache.NewNode("kv", 2<<10, func(key string) ([]byte, error) {
+		return nil, cache.ErrNotFound
+	})
+	graph.AddNode(node)
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	NewHandler(graph, Options{Watch: hub}).RegisterGRPC(server)
+	go server.Serve(lis)
+	defer server.Stop()
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := pb.NewRpcGetterClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	stream, err := client.Watch(ctx, &pb.WatchRequest{NodeName: "kv"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev, err := stream.Recv(); err != nil || ev.Kind != string(watch.KindProgress) {
+		t.Fatalf("want the token first, got %v %v", ev, err)
+	}
+
+	node.Set("a", []byte("1"), 0)
+	ev, err := stream.Recv()
+	if err != nil || ev.Kind != string(watch.KindSet) || ev.Key != "a" || ev.NodeName != "kv" || ev.Token == "" {
+		t.Fatalf("unexpected event %v %v", ev, err)
+	}
+
+	out, err := client.Get(ctx, &pb.Request{NodeName: "kv", Key: "a"})
+	if err != nil || string(out.Value) != "1" {
+		t.Fatalf("get: %v %v", out, err)
+	}
+	if _, err := client.Get(ctx, &pb.Request{NodeName: "kv", Key: "b"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("want NotFound, got %v", err)
+	}
+	stream, _ = client.Watch(ctx, &pb.WatchRequest{NodeName: "kv", After: "nope"})
+	if _, err := stream.Recv(); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("want InvalidArgument for a bad token, got %v", err)
+	}
+}
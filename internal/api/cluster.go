@@ -0,0 +1,71 @@
+package api
+
+import (
+	"hash/crc32"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// where the ring is served, smart clients route keys with it
+const ClusterPath = "/api/cluster"
+
+const StatsPath = "/api/stats"
+
+// every response carries the version of the ring, clients refresh once it differs from theirs
+const HeaderRingVersion = "X-Efis-Ring-Version"
+
+// the view of one server, keys are hashed onto Ring like HttpPool does
+type ClusterInfo struct {
+	Self     string   `json:"self"`
+	Members  []string `json:"members"`
+	Ring     []string `json:"ring"`
+	Replicas int      `json:"replicas"`
+	Version  string   `json:"version"`
+}
+
+// servers which agree on the ring agree on the version
+func RingVersion(ring []string) string {
+	return strconv.FormatUint(uint64(crc32.ChecksumIEEE([]byte(strings.Join(ring, ",")))), 16)
+}
+
+func (h *Handler) cluster() (ClusterInfo, bool) {
+	if h.opts.Cluster == nil {
+		return ClusterInfo{}, false
+	}
+
+	info := h.opts.Cluster()
+	info.Version = RingVersion(info.Ring)
+
+	return info, true
+}
+
+func (h *Handler) serveCluster(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	info, ok := h.cluster()
+	if !ok {
+		writeError(w, http.StatusNotFound, "this server is not part of a cluster")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, info)
+}
+
+func (h *Handler) serveStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if h.opts.Stats == nil {
+		writeError(w, http.StatusNotFound, "this server has no stats")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, h.opts.Stats())
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/golrice/e-fis/internal/cache"
+	pb "github.com/golrice/e-fis/internal/protocal"
+	"github.com/golrice/e-fis/internal/watch"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// rpcServer serves RpcGetter, Get reads like RPCPath and Watch streams like WatchPath
+type rpcServer struct {
+	pb.UnimplementedRpcGetterServer
+	h *Handler
+}
+
+// serve RpcGetter on s next to the http api
+func (h *Handler) RegisterGRPC(s *grpc.Server) {
+	pb.RegisterRpcGetterServer(s, &rpcServer{h: h})
+}
+
+// the code of a failed read, like statusOf
+func codeOf(err error) codes.Code {
+	switch statusOf(err) {
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	}
+	return codes.Unavailable
+}
+
+func (s *rpcServer) Get(ctx context.Context, in *pb.Request) (*pb.Response, error) {
+	namespace := in.NodeName
+	if namespace == "" {
+		namespace = s.h.opts.DefaultNamespace
+	}
+	if namespace == "" || in.Key == "" {
+		return nil, status.Error(codes.InvalidArgument, "need a namespace and a key")
+	}
+
+	node, err := cache.GetNode(s.h.graph, namespace)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, "no such namespace "+namespace)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.h.opts.Timeout)
+	defer cancel()
+
+	v, err := s.h.lookup(ctx, node, in.Key)
+	if err != nil {
+		return nil, status.Error(codeOf(err), err.Error())
+	}
+
+	return &pb.Response{Value: v.ByteSlice(), Expire: cache.ExpireToNano(v.Expire())}, nil
+}
+
+// an empty node name watches every namespace, the client resumes with the token of the last
+// event it got as after. a client which falls too far behind gets ResourceExhausted and resumes
+func (s *rpcServer) Watch(in *pb.WatchRequest, stream grpc.ServerStreamingServer[pb.WatchEvent]) error {
+	hub := s.h.opts.Watch
+	if hub == nil {
+		return status.Error(codes.Unimplemented, "this server can not be watched")
+	}
+	if in.NodeName != "" {
+		if _, err := cache.GetNode(s.h.graph, in.NodeName); err != nil {
+			return status.Error(codes.NotFound, "no such namespace "+in.NodeName)
+		}
+	}
+
+	sub, err := hub.Subscribe(watch.Filter{Namespace: in.NodeName, Prefix: in.Prefix}, in.After)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error()+" "+in.After)
+	}
+	defer sub.Close()
+
+	ticker := time.NewTicker(watchProgress)
+	defer ticker.Stop()
+	for {
+		select {
+		case ev, ok := <-sub.C:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "fell behind, resume with the last token")
+			}
+			err := stream.Send(&pb.WatchEvent{
+				Token:    ev.Token,
+				Seq:      ev.Seq,
+				Time:     ev.Time.UnixNano(),
+				NodeName: ev.Namespace,
+				Kind:     string(ev.Kind),
+				Key:      ev.Key,
+				Expire:   cache.ExpireToNano(ev.Expire),
+			})
+			if err != nil {
+				return err
+			}
+		case <-ticker.C:
+			sub.Progress()
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"context"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/golrice/e-fis/internal/cache"
+	pb "github.com/golrice/e-fis/internal/protocal"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// the single key endpoint the client speaks
+const RPCPath = "/api"
+
+const (
+	ContentTypeProtobuf = "application/x-protobuf"
+	ContentTypeJSON     = "application/json"
+	// what older clients send their protobuf as, and what raw values are served as
+	ContentTypeOctet = "application/octet-stream"
+
+	maxRequestBody = 64 << 10
+)
+
+// the encoding of a request or a reply
+type encoding int
+
+const (
+	encodingRaw encoding = iota
+	encodingProtobuf
+	encodingJSON
+)
+
+func encodingOf(contentType string) (encoding, bool) {
+	t, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return encodingRaw, false
+	}
+
+	switch t {
+	case ContentTypeProtobuf, "application/protobuf", ContentTypeOctet:
+		return encodingProtobuf, true
+	case ContentTypeJSON:
+		return encodingJSON, true
+	}
+	return encodingRaw, false
+}
+
+// the reply follows the first encoding we know in Accept, then the encoding of the request
+// a GET without either gets the raw value, like it always did
+func replyEncoding(r *http.Request, request encoding) encoding {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		t, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		switch t {
+		case ContentTypeProtobuf, "application/protobuf":
+			return encodingProtobuf
+		case ContentTypeJSON:
+			return encodingJSON
+		case ContentTypeOctet:
+			return encodingRaw
+		}
+	}
+
+	return request
+}
+
+// serveRPC answers a pb.Request, posted as protobuf or json, or given as ?namespace=&key=
+func (h *Handler) serveRPC(w http.ResponseWriter, r *http.Request) {
+	in := &pb.Request{}
+	request := encodingRaw
+
+	switch r.Method {
+	case http.MethodGet:
+		in.NodeName = r.URL.Query().Get("namespace")
+		in.Key = r.URL.Query().Get("key")
+	case http.MethodPost:
+		var ok bool
+		if request, ok = encodingOf(r.Header.Get("Content-Type")); !ok {
+			writeError(w, http.StatusUnsupportedMediaType, "want a protobuf or json body")
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBody))
+		if err != nil {
+			writeError(w, http.StatusRequestEntityTooLarge, err.Error())
+			return
+		}
+		if request == encodingJSON {
+			err = protojson.Unmarshal(body, in)
+		} else {
+			err = proto.Unmarshal(body, in)
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "bad request body: "+err.Error())
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	if in.NodeName == "" {
+		in.NodeName = h.opts.DefaultNamespace
+	}
+	if in.NodeName == "" || in.Key == "" {
+		writeError(w, http.StatusBadRequest, "need a namespace and a key")
+		return
+	}
+
+	node, err := cache.GetNode(h.graph, in.NodeName)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "no such namespace "+in.NodeName)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.opts.Timeout)
+	defer cancel()
+
+	v, err := h.lookup(ctx, node, in.Key)
+	if err != nil {
+		writeError(w, statusOf(err), err.Error())
+		return
+	}
+
+	out := &pb.Response{Value: v.ByteSlice(), Expire: cache.ExpireToNano(v.Expire())}
+	var body []byte
+	switch replyEncoding(r, request) {
+	case encodingProtobuf:
+		w.Header().Set("Content-Type", ContentTypeProtobuf)
+		body, err = proto.Marshal(out)
+	case encodingJSON:
+		w.Header().Set("Content-Type", ContentTypeJSON)
+		body, err = protojson.Marshal(out)
+	default:
+		w.Header().Set("Content-Type", ContentTypeOctet)
+		body = out.Value
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Write(body)
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/golrice/e-fis/internal/cache"
+	"github.com/golrice/e-fis/internal/watch"
+)
+
+// the changes of a namespace are streamed as server-sent events from /api/watch/{namespace},
+// those of every namespace from /api/watch/
+const WatchPath = "/api/watch/"
+
+// a progress is sent this often, it keeps idle streams open and the token of a filtered one current
+const watchProgress = 15 * time.Second
+
+type eventBody struct {
+	Token     string     `json:"token"`
+	Seq       uint64     `json:"seq"`
+	Time      time.Time  `json:"time"`
+	Namespace string     `json:"namespace,omitempty"`
+	Kind      string     `json:"kind"`
+	Key       string     `json:"key,omitempty"`
+	Expire    *time.Time `json:"expire,omitempty"`
+}
+
+// GET /api/watch/{namespace}?prefix= streams the changes of the keys with the prefix, a client
+// resumes with the token of the last event it saw as Last-Event-ID, like an EventSource does, or as
+// ?after=. the stream ends when the client falls too far behind, it resumes the same way
+func (h *Handler) serveWatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if h.opts.Watch == nil {
+		writeError(w, http.StatusNotFound, "this server can not be watched")
+		return
+	}
+
+	namespace := r.URL.Path[len(WatchPath):]
+	if namespace != "" {
+		if _, err := cache.GetNode(h.graph, namespace); err != nil {
+			writeError(w, http.StatusNotFound, "no such namespace "+namespace)
+			return
+		}
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	after := r.Header.Get("Last-Event-ID")
+	if token := r.URL.Query().Get("after"); token != "" {
+		after = token
+	}
+	s, err := h.opts.Watch.Subscribe(watch.Filter{Namespace: namespace, Prefix: r.URL.Query().Get("prefix")}, after)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error()+" "+after)
+		return
+	}
+	defer s.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(watchProgress)
+	defer ticker.Stop()
+	for {
+		select {
+		case ev, ok := <-s.C:
+			if !ok {
+				log.Printf("[API] a watcher of %q fell behind, it has to resume", namespace)
+				return
+			}
+			if err := writeEvent(w, ev); err != nil {
+				return
+			}
+			// events which wait already go out together
+			if len(s.C) == 0 {
+				flusher.Flush()
+			}
+		case <-ticker.C:
+			s.Progress()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeEvent(w io.Writer, ev watch.Event) error {
+	// without data an EventSource only takes the id
+	if ev.Kind == watch.KindProgress {
+		_, err := fmt.Fprintf(w, "id: %s\n\n", ev.Token)
+		return err
+	}
+
+	body := eventBody{
+		Token:     ev.Token,
+		Seq:       ev.Seq,
+		Time:      ev.Time,
+		Namespace: ev.Namespace,
+		Kind:      string(ev.Kind),
+		Key:       ev.Key,
+	}
+	if !ev.Expire.IsZero() {
+		body.Expire = &ev.Expire
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", ev.Token, ev.Kind, data)
+	return err
+}
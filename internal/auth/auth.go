@@ -0,0 +1,226 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	HeaderKey       = "X-Efis-Key"
+	HeaderTimestamp = "X-Efis-Timestamp"
+	HeaderNonce     = "X-Efis-Nonce"
+	HeaderSignature = "X-Efis-Signature"
+
+	// the body is read into memory before its signature is checked, so it is limited
+	MaxBodyBytes = 8 << 20
+
+	defaultMaxSkew = 30 * time.Second
+)
+
+var (
+	ErrUnsigned     = errors.New("request is not signed")
+	ErrBadSignature = errors.New("bad signature")
+	ErrExpired      = errors.New("request timestamp out of range")
+	ErrReplayed     = errors.New("request replayed")
+	ErrTooLarge     = errors.New("request body too large")
+)
+
+// method, path, timestamp, nonce and the hash of the body, one per line
+func canonical(method, path, timestamp, nonce string, body []byte) []byte {
+	sum := sha256.Sum256(body)
+
+	var b bytes.Buffer
+	b.WriteString(method)
+	b.WriteByte('\n')
+	b.WriteString(path)
+	b.WriteByte('\n')
+	b.WriteString(timestamp)
+	b.WriteByte('\n')
+	b.WriteString(nonce)
+	b.WriteByte('\n')
+	b.WriteString(hex.EncodeToString(sum[:]))
+
+	return b.Bytes()
+}
+
+func mac(secret, msg []byte) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write(msg)
+	return h.Sum(nil)
+}
+
+func requestPath(r *http.Request) string {
+	path := r.URL.EscapedPath()
+	if r.URL.RawQuery != "" {
+		path += "?" + r.URL.RawQuery
+	}
+	return path
+}
+
+// read the body and put it back, so that it can still be sent or served
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, MaxBodyBytes))
+	r.Body.Close()
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, ErrTooLarge
+		}
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	return body, nil
+}
+
+// sign the request with the primary key of the ring
+func Sign(k *KeyRing, r *http.Request) error {
+	body, err := readBody(r)
+	if err != nil {
+		return err
+	}
+
+	var n [16]byte
+	if _, err := rand.Read(n[:]); err != nil {
+		return err
+	}
+	nonce := hex.EncodeToString(n[:])
+	timestamp := strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	id, secret := k.Primary()
+	sig := mac(secret, canonical(r.Method, requestPath(r), timestamp, nonce, body))
+
+	r.Header.Set(HeaderKey, id)
+	r.Header.Set(HeaderTimestamp, timestamp)
+	r.Header.Set(HeaderNonce, nonce)
+	r.Header.Set(HeaderSignature, hex.EncodeToString(sig))
+
+	return nil
+}
+
+type transport struct {
+	keys *KeyRing
+	base http.RoundTripper
+}
+
+// a round tripper which signs every request, retries get a fresh nonce
+func NewTransport(k *KeyRing, base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{keys: k, base: base}
+}
+
+func (t *transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	// a round tripper must not modify the request
+	r = r.Clone(r.Context())
+	if err := Sign(t.keys, r); err != nil {
+		return nil, err
+	}
+
+	return t.base.RoundTrip(r)
+}
+
+type VerifierOptions struct {
+	// how far the timestamp of a request may be off our clock
+	MaxSkew time.Duration
+}
+
+// verifier checks signed requests, a nonce is remembered until its timestamp is out of range
+type Verifier struct {
+	keys    *KeyRing
+	maxSkew time.Duration
+
+	mu     sync.Mutex
+	nonces map[string]time.Time
+	sweep  time.Time
+}
+
+func NewVerifier(k *KeyRing, opts VerifierOptions) *Verifier {
+	if opts.MaxSkew <= 0 {
+		opts.MaxSkew = defaultMaxSkew
+	}
+
+	return &Verifier{
+		keys:    k,
+		maxSkew: opts.MaxSkew,
+		nonces:  make(map[string]time.Time),
+	}
+}
+
+func (v *Verifier) Verify(r *http.Request) error {
+	id := r.Header.Get(HeaderKey)
+	timestamp := r.Header.Get(HeaderTimestamp)
+	nonce := r.Header.Get(HeaderNonce)
+	sig := r.Header.Get(HeaderSignature)
+	if id == "" || timestamp == "" || nonce == "" || sig == "" {
+		return ErrUnsigned
+	}
+
+	secret, ok := v.keys.lookup(id)
+	if !ok {
+		return fmt.Errorf("unknown key %s", id)
+	}
+
+	want, err := hex.DecodeString(sig)
+	if err != nil {
+		return ErrBadSignature
+	}
+
+	ns, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrBadSignature
+	}
+	ts := time.Unix(0, ns)
+	now := time.Now()
+	if ts.Before(now.Add(-v.maxSkew)) || ts.After(now.Add(v.maxSkew)) {
+		return ErrExpired
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		return err
+	}
+
+	if !hmac.Equal(want, mac(secret, canonical(r.Method, requestPath(r), timestamp, nonce, body))) {
+		return ErrBadSignature
+	}
+
+	// only a valid signature may take a nonce, otherwise anyone could fill the cache
+	return v.remember(id+"/"+nonce, ts.Add(v.maxSkew), now)
+}
+
+func (v *Verifier) remember(nonce string, expire, now time.Time) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if now.Sub(v.sweep) > v.maxSkew {
+		for n, e := range v.nonces {
+			if e.Before(now) {
+				delete(v.nonces, n)
+			}
+		}
+		v.sweep = now
+	}
+
+	if _, ok := v.nonces[nonce]; ok {
+		return ErrReplayed
+	}
+	v.nonces[nonce] = expire
+
+	return nil
+}
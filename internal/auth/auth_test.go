@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func signed(t *testing.T, k *KeyRing, method, url, body string) *http.Request {
+	t.Helper()
+
+	r := httptest.NewRequest(method, url, strings.NewReader(body))
+	if err := Sign(k, r); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestVerifier(t *testing.T) {
+	keys := NewKeyRing("k1", []byte("secret"))
+	v := NewVerifier(keys, VerifierOptions{})
+
+	r := signed(t, keys, http.MethodPost, "/efis/scores", "batch")
+	if err := v.Verify(r); err != nil {
+		t.Fatalf("a signed request should pass: %v", err)
+	}
+	// the body is still there for the handler
+	if b, _ := io.ReadAll(r.Body); string(b) != "batch" {
+		t.Fatalf("body = %q", b)
+	}
+
+	// the same request again
+	replay := httptest.NewRequest(http.MethodPost, "/efis/scores", strings.NewReader("batch"))
+	replay.Header = r.Header.Clone()
+	if err := v.Verify(replay); err != ErrReplayed {
+		t.Fatalf("replay: got %v", err)
+	}
+
+	if err := v.Verify(httptest.NewRequest(http.MethodGet, "/efis/scores/Tom", nil)); err != ErrUnsigned {
+		t.Fatalf("unsigned: got %v", err)
+	}
+
+	tampered := signed(t, keys, http.MethodPost, "/efis/scores", "batch")
+	tampered.Body = io.NopCloser(strings.NewReader("other"))
+	if err := v.Verify(tampered); err != ErrBadSignature {
+		t.Fatalf("tampered body: got %v", err)
+	}
+
+	moved := signed(t, keys, http.MethodGet, "/efis/scores/Tom", "")
+	moved.URL.Path = "/efis/scores/Jack"
+	if err := v.Verify(moved); err != ErrBadSignature {
+		t.Fatalf("tampered path: got %v", err)
+	}
+
+	other := signed(t, NewKeyRing("k1", []byte("guess")), http.MethodGet, "/efis/scores/Tom", "")
+	if err := v.Verify(other); err != ErrBadSignature {
+		t.Fatalf("wrong secret: got %v", err)
+	}
+
+	large := signed(t, keys, http.MethodPost, "/efis/scores", "batch")
+	large.Body = io.NopCloser(strings.NewReader(strings.Repeat("x", MaxBodyBytes+1)))
+	if err := v.Verify(large); err != ErrTooLarge {
+		t.Fatalf("large body: got %v", err)
+	}
+
+	old := signed(t, keys, http.MethodGet, "/efis/scores/Tom", "")
+	old.Header.Set(HeaderTimestamp, strconv.FormatInt(time.Now().Add(-time.Minute).UnixNano(), 10))
+	if err := v.Verify(old); err != ErrExpired {
+		t.Fatalf("old request: got %v", err)
+	}
+}
+
+func TestKeyRing_Rotation(t *testing.T) {
+	oldKeys := NewKeyRing("k1", []byte("one"))
+	v := NewVerifier(oldKeys, VerifierOptions{})
+
+	// a peer which already moved on to the new key
+	newKeys := NewKeyRing("k2", []byte("two"))
+	if err := v.Verify(signed(t, newKeys, http.MethodGet, "/efis/a/b", "")); err == nil {
+		t.Fatal("an unknown key should be rejected")
+	}
+
+	oldKeys.Add("k2", []byte("two"))
+	if err := v.Verify(signed(t, newKeys, http.MethodGet, "/efis/a/b", "")); err != nil {
+		t.Fatalf("the new key should be accepted: %v", err)
+	}
+	if err := v.Verify(signed(t, oldKeys, http.MethodGet, "/efis/a/b", "")); err != nil {
+		t.Fatalf("the old key should still be accepted: %v", err)
+	}
+
+	if err := oldKeys.Remove("k1"); err == nil {
+		t.Fatal("the primary key should not be removable")
+	}
+	if err := oldKeys.Use("k2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := oldKeys.Remove("k1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Verify(signed(t, NewKeyRing("k1", []byte("one")), http.MethodGet, "/efis/a/b", "")); err == nil {
+		t.Fatal("a removed key should be rejected")
+	}
+}
+
+func TestParseKeyRing(t *testing.T) {
+	k, err := ParseKeyRing("k1:one, k2:two")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, secret := k.Primary(); id != "k1" || string(secret) != "one" {
+		t.Fatalf("primary = %s:%s", id, secret)
+	}
+	if ids := k.IDs(); len(ids) != 2 {
+		t.Fatalf("ids = %v", ids)
+	}
+
+	for _, bad := range []string{"", "k1", "k1:", ":one"} {
+		if _, err := ParseKeyRing(bad); err == nil {
+			t.Errorf("%q should fail", bad)
+		}
+	}
+}
+
+func TestTransport(t *testing.T) {
+	keys := NewKeyRing("k1", []byte("secret"))
+	v := NewVerifier(keys, VerifierOptions{})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := v.Verify(r); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		w.Write(b)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: NewTransport(keys, nil)}
+	for i := 0; i < 2; i++ {
+		res, err := client.Post(srv.URL+"/efis/scores?x=1", "text/plain", strings.NewReader("hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK || string(b) != "hello" {
+			t.Fatalf("got %d %q", res.StatusCode, b)
+		}
+	}
+
+	res, err := http.Get(srv.URL + "/efis/scores")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unsigned request got %d", res.StatusCode)
+	}
+}
@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// the shared secrets of the cluster, requests are signed with the primary key
+// and accepted with any key in the ring, so that a key can be rotated without downtime:
+// add the new key everywhere, make it primary everywhere, then remove the old one
+type KeyRing struct {
+	mu      sync.RWMutex
+	keys    map[string][]byte
+	primary string
+}
+
+func NewKeyRing(id string, secret []byte) *KeyRing {
+	k := &KeyRing{keys: make(map[string][]byte)}
+	k.Add(id, secret)
+	k.primary = id
+
+	return k
+}
+
+// id1:secret1,id2:secret2, the first key is the primary one
+func ParseKeyRing(s string) (*KeyRing, error) {
+	var k *KeyRing
+	for _, part := range strings.Split(s, ",") {
+		id, secret, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok || id == "" || secret == "" {
+			return nil, fmt.Errorf("bad key %q, want id:secret", part)
+		}
+
+		if k == nil {
+			k = NewKeyRing(id, []byte(secret))
+		} else {
+			k.Add(id, []byte(secret))
+		}
+	}
+
+	if k == nil {
+		return nil, errors.New("empty key ring")
+	}
+
+	return k, nil
+}
+
+// add or replace a key, the primary key stays the same
+func (k *KeyRing) Add(id string, secret []byte) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.keys[id] = append([]byte(nil), secret...)
+}
+
+// sign with this key from now on
+func (k *KeyRing) Use(id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.keys[id]; !ok {
+		return fmt.Errorf("unknown key %s", id)
+	}
+	k.primary = id
+
+	return nil
+}
+
+// the primary key can not be removed
+func (k *KeyRing) Remove(id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if id == k.primary {
+		return fmt.Errorf("key %s is the primary key", id)
+	}
+	delete(k.keys, id)
+
+	return nil
+}
+
+func (k *KeyRing) IDs() []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	ids := make([]string, 0, len(k.keys))
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+func (k *KeyRing) Primary() (string, []byte) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return k.primary, k.keys[k.primary]
+}
+
+func (k *KeyRing) lookup(id string) ([]byte, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	secret, ok := k.keys[id]
+	return secret, ok
+}
@@ -0,0 +1,370 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golrice/e-fis/internal/trace"
+)
+
+const (
+	defaultKeys        = 10000
+	defaultSkew        = 0.99
+	defaultHotKeys     = 0.2
+	defaultHotOps      = 0.8
+	defaultValueSize   = 100
+	defaultConcurrency = 8
+	defaultDuration    = 10 * time.Second
+	maxErrors          = 5
+)
+
+// the keys are KeyPrefix followed by their index, 0 is the hottest one for zipf and hotspot
+const KeyPrefix = "bench-"
+
+type Workload struct {
+	// Uniform, Zipf, Hotspot, Scan or Trace
+	Distribution string
+	Keys         int
+	// zipf skew, above 0 and not 1, 0.99 is the usual choice
+	Skew float64
+	// hotspot sends HotOps of the requests to the first HotKeys of the keys, both fractions
+	HotKeys float64
+	HotOps  float64
+	// fraction of the requests which set the key, the rest gets it
+	Writes    float64
+	ValueSize int
+	// the requests of a Trace workload, the namespace of a record is ignored
+	Records []trace.Record
+	// the same seed gives the same keys in the same order per worker
+	Seed int64
+}
+
+type Options struct {
+	Workload    Workload
+	Concurrency int
+	// the run stops after Duration or Requests, whichever comes first
+	// a trace is replayed once if neither is set
+	Duration time.Duration
+	Requests int64
+	// set every key once before the run, so that gets against an empty namespace find something
+	Preload bool
+}
+
+func (o *Options) fill() error {
+	w := &o.Workload
+	if w.Distribution == "" {
+		w.Distribution = Uniform
+	}
+	if w.Keys <= 0 {
+		w.Keys = defaultKeys
+	}
+	if w.Skew == 0 {
+		w.Skew = defaultSkew
+	}
+	if w.HotKeys <= 0 || w.HotKeys > 1 {
+		w.HotKeys = defaultHotKeys
+	}
+	if w.HotOps <= 0 || w.HotOps > 1 {
+		w.HotOps = defaultHotOps
+	}
+	if w.Writes < 0 || w.Writes > 1 {
+		return fmt.Errorf("writes must be a fraction, got %v", w.Writes)
+	}
+	if w.ValueSize <= 0 {
+		w.ValueSize = defaultValueSize
+	}
+
+	switch w.Distribution {
+	case Uniform, Zipf, Hotspot, Scan:
+	case Trace:
+		if len(w.Records) == 0 {
+			return errors.New("a trace workload needs records")
+		}
+	default:
+		return fmt.Errorf("unknown distribution %q", w.Distribution)
+	}
+
+	if o.Concurrency <= 0 {
+		o.Concurrency = defaultConcurrency
+	}
+	if o.Duration <= 0 && o.Requests <= 0 && w.Distribution != Trace {
+		o.Duration = defaultDuration
+	}
+
+	return nil
+}
+
+// latencies are in nanoseconds
+type Latency struct {
+	Mean time.Duration `json:"mean_ns"`
+	P50  time.Duration `json:"p50_ns"`
+	P90  time.Duration `json:"p90_ns"`
+	P99  time.Duration `json:"p99_ns"`
+	P999 time.Duration `json:"p999_ns"`
+	Max  time.Duration `json:"max_ns"`
+}
+
+type Report struct {
+	Distribution string        `json:"distribution"`
+	Concurrency  int           `json:"concurrency"`
+	Elapsed      time.Duration `json:"elapsed_ns"`
+
+	Requests int64 `json:"requests"`
+	Gets     int64 `json:"gets"`
+	Sets     int64 `json:"sets"`
+	Deletes  int64 `json:"deletes"`
+	NotFound int64 `json:"not_found"`
+	Errors   int64 `json:"errors"`
+	// ops per second
+	Throughput float64 `json:"throughput"`
+	Latency    Latency `json:"latency"`
+
+	// what the servers counted during the run, the hit ratio is hits / gets of the nodes
+	Counters  Counters `json:"counters"`
+	HitRatio  float64  `json:"hit_ratio"`
+	PeerShare float64  `json:"peer_share"`
+
+	FirstErrors []string `json:"first_errors,omitempty"`
+}
+
+type worker struct {
+	hist                          histogram
+	gets, sets, deletes, notFound int64
+	errors                        int64
+	firstErrors                   []string
+}
+
+func (w *worker) count(op trace.Op, took time.Duration) {
+	w.hist.add(took)
+	switch op {
+	case trace.OpGet:
+		w.gets += 1
+	case trace.OpSet:
+		w.sets += 1
+	case trace.OpDelete:
+		w.deletes += 1
+	}
+}
+
+func (w *worker) fail(err error) {
+	w.errors += 1
+	if len(w.firstErrors) < maxErrors {
+		w.firstErrors = append(w.firstErrors, err.Error())
+	}
+}
+
+func keyName(i int) string {
+	return KeyPrefix + strconv.Itoa(i)
+}
+
+// run drives the target until the duration or the requests are used up, or ctx is done
+func Run(ctx context.Context, t Target, opts Options) (*Report, error) {
+	if err := opts.fill(); err != nil {
+		return nil, err
+	}
+	w := opts.Workload
+
+	var zipf *zipfConst
+	if w.Distribution == Zipf {
+		z, err := newZipfConst(w.Keys, w.Skew)
+		if err != nil {
+			return nil, err
+		}
+		zipf = z
+	}
+
+	value := make([]byte, w.ValueSize)
+	for i := range value {
+		value[i] = 'a' + byte(i%26)
+	}
+
+	if opts.Preload && w.Distribution != Trace {
+		if err := preload(ctx, t, w.Keys, opts.Concurrency, value); err != nil {
+			return nil, fmt.Errorf("preload: %w", err)
+		}
+	}
+
+	before, _ := t.Counters(ctx)
+
+	runCtx := ctx
+	if opts.Duration > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, opts.Duration)
+		defer cancel()
+	}
+
+	var issued, scanPos, tracePos atomic.Int64
+	// a request is issued if it is within the budget
+	take := func() bool {
+		return opts.Requests <= 0 || issued.Add(1) <= opts.Requests
+	}
+
+	workers := make([]*worker, opts.Concurrency)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := range workers {
+		wk := &worker{}
+		workers[i] = wk
+
+		r := rand.New(rand.NewSource(w.Seed + int64(i)))
+		var keys keyGen
+		switch w.Distribution {
+		case Uniform:
+			keys = &uniformGen{r: r, n: w.Keys}
+		case Zipf:
+			keys = zipf.gen(r)
+		case Hotspot:
+			keys = &hotspotGen{r: r, n: w.Keys, hot: max(int(float64(w.Keys)*w.HotKeys), 1), hotOps: w.HotOps}
+		case Scan:
+			keys = &scanGen{pos: &scanPos, n: w.Keys}
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for runCtx.Err() == nil && take() {
+				op, key, v := trace.OpGet, "", value
+				if keys != nil {
+					key = keyName(keys.next())
+					if w.Writes > 0 && r.Float64() < w.Writes {
+						op = trace.OpSet
+					}
+				} else {
+					n := tracePos.Add(1) - 1
+					if opts.Duration <= 0 && opts.Requests <= 0 && n >= int64(len(w.Records)) {
+						return
+					}
+					rec := w.Records[n%int64(len(w.Records))]
+					op, key = rec.Op, rec.Key
+					if rec.Size > 0 {
+						v = payload(value, rec.Size)
+					}
+				}
+
+				began := time.Now()
+				var err error
+				switch op {
+				case trace.OpGet:
+					_, err = t.Get(runCtx, key)
+				case trace.OpSet:
+					err = t.Set(runCtx, key, v)
+				case trace.OpDelete:
+					err = t.Delete(runCtx, key)
+				}
+				took := time.Since(began)
+
+				// the run ended while the request was in flight
+				if err != nil && runCtx.Err() != nil {
+					return
+				}
+
+				wk.count(op, took)
+				switch {
+				case err == nil:
+				case errors.Is(err, ErrNotFound):
+					wk.notFound += 1
+				default:
+					wk.fail(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	after, err := t.Counters(ctx)
+
+	report := &Report{
+		Distribution: w.Distribution,
+		Concurrency:  opts.Concurrency,
+		Elapsed:      elapsed,
+		Counters:     after.sub(before),
+	}
+
+	var hist histogram
+	for _, wk := range workers {
+		hist.merge(&wk.hist)
+		report.Gets += wk.gets
+		report.Sets += wk.sets
+		report.Deletes += wk.deletes
+		report.NotFound += wk.notFound
+		report.Errors += wk.errors
+		for _, e := range wk.firstErrors {
+			if len(report.FirstErrors) < maxErrors {
+				report.FirstErrors = append(report.FirstErrors, e)
+			}
+		}
+	}
+
+	report.Requests = hist.count
+	if elapsed > 0 {
+		report.Throughput = float64(hist.count) / elapsed.Seconds()
+	}
+	report.Latency = Latency{
+		Mean: hist.mean(),
+		P50:  hist.percentile(0.5),
+		P90:  hist.percentile(0.9),
+		P99:  hist.percentile(0.99),
+		P999: hist.percentile(0.999),
+		Max:  hist.max,
+	}
+
+	c := report.Counters
+	if c.Gets > 0 {
+		report.HitRatio = float64(c.Hits) / float64(c.Gets)
+	}
+	if loads := c.PeerLoads + c.LocalLoads; loads > 0 {
+		report.PeerShare = float64(c.PeerLoads) / float64(loads)
+	}
+
+	if err != nil {
+		return report, fmt.Errorf("counters: %w", err)
+	}
+	return report, nil
+}
+
+// a value of the given size, cut from the workload value if it is long enough
+func payload(value []byte, size int) []byte {
+	if size <= len(value) {
+		return value[:size]
+	}
+	return make([]byte, size)
+}
+
+func preload(ctx context.Context, t Target, keys, concurrency int, value []byte) error {
+	var next atomic.Int64
+	var firstErr error
+	var once sync.Once
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i += 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				n := int(next.Add(1) - 1)
+				if n >= keys || ctx.Err() != nil {
+					return
+				}
+				if err := t.Set(ctx, keyName(n), value); err != nil {
+					once.Do(func() { firstErr = err })
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	return firstErr
+}
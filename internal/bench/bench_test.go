@@ -0,0 +1,164 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golrice/e-fis/internal/cache"
+	"github.com/golrice/e-fis/internal/trace"
+)
+
+func newBenchNode(t *testing.T, capacity int64) *cache.Node {
+	t.Helper()
+
+	node, err := cache.NewNodeWithPolicy("bench", capacity, "lru", func(key string) ([]byte, error) {
+		return make([]byte, 100), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return node
+}
+
+func TestRun_Requests(t *testing.T) {
+	node := newBenchNode(t, 0)
+	report, err := Run(context.Background(), NewNodeTarget(node), Options{
+		Workload:    Workload{Distribution: Zipf, Keys: 1000, Writes: 0.1},
+		Concurrency: 4,
+		Requests:    5000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Requests != 5000 || report.Gets+report.Sets != 5000 || report.Errors != 0 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if report.Sets == 0 || report.Latency.P50 > report.Latency.P99 || report.Latency.P99 > report.Latency.Max {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	// an unlimited cache misses every key once at most
+	if report.Counters.Gets != report.Gets || report.Counters.LocalLoads > 1000 || report.HitRatio < 0.5 {
+		t.Fatalf("unexpected counters %+v, hit ratio %v", report.Counters, report.HitRatio)
+	}
+}
+
+func TestRun_Duration(t *testing.T) {
+	report, err := Run(context.Background(), NewNodeTarget(newBenchNode(t, 0)), Options{
+		Workload: Workload{Distribution: Hotspot},
+		Duration: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Requests == 0 || report.Elapsed < 50*time.Millisecond || report.Elapsed > time.Second {
+		t.Fatalf("unexpected report %+v", report)
+	}
+}
+
+// counts the requests per key
+type countingTarget struct {
+	gets [100]atomic.Int64
+	sets atomic.Int64
+	dels atomic.Int64
+}
+
+func (c *countingTarget) Get(ctx context.Context, key string) ([]byte, error) {
+	var i int
+	fmt.Sscanf(key, KeyPrefix+"%d", &i)
+	c.gets[i].Add(1)
+	return nil, nil
+}
+
+func (c *countingTarget) Set(ctx context.Context, key string, value []byte) error {
+	c.sets.Add(1)
+	return nil
+}
+
+func (c *countingTarget) Delete(ctx context.Context, key string) error {
+	c.dels.Add(1)
+	return ErrNotFound
+}
+
+func (c *countingTarget) Counters(ctx context.Context) (Counters, error) { return Counters{}, nil }
+
+func TestRun_Distributions(t *testing.T) {
+	run := func(w Workload) *countingTarget {
+		w.Keys = 100
+		target := &countingTarget{}
+		if _, err := Run(context.Background(), target, Options{Workload: w, Concurrency: 1, Requests: 10000}); err != nil {
+			t.Fatal(err)
+		}
+		return target
+	}
+
+	zipf := run(Workload{Distribution: Zipf, Skew: 0.99})
+	if zipf.gets[0].Load() < 5*zipf.gets[50].Load() {
+		t.Fatalf("zipf should favor key 0, got %d vs %d", zipf.gets[0].Load(), zipf.gets[50].Load())
+	}
+
+	var hot int64
+	hotspot := run(Workload{Distribution: Hotspot, HotKeys: 0.1, HotOps: 0.9})
+	for i := 0; i < 10; i += 1 {
+		hot += hotspot.gets[i].Load()
+	}
+	if hot < 8500 || hot > 9500 {
+		t.Fatalf("hotspot should send 90%% to the hot keys, got %d", hot)
+	}
+
+	scan := run(Workload{Distribution: Scan})
+	for i := range scan.gets {
+		if scan.gets[i].Load() != 100 {
+			t.Fatalf("scan should read every key as often, key %d got %d", i, scan.gets[i].Load())
+		}
+	}
+
+	// the same seed, the same keys
+	a, b := run(Workload{Distribution: Uniform, Seed: 7}), run(Workload{Distribution: Uniform, Seed: 7})
+	for i := range a.gets {
+		if a.gets[i].Load() != b.gets[i].Load() {
+			t.Fatal("a seeded run should be reproducible")
+		}
+	}
+}
+
+func TestRun_Trace(t *testing.T) {
+	records := []trace.Record{
+		{Op: trace.OpGet, Key: KeyPrefix + "1"},
+		{Op: trace.OpSet, Key: KeyPrefix + "2", Size: 10},
+		{Op: trace.OpDelete, Key: KeyPrefix + "3"},
+	}
+
+	target := &countingTarget{}
+	report, err := Run(context.Background(), target, Options{Workload: Workload{Distribution: Trace, Records: records}, Concurrency: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Requests != 3 || report.Gets != 1 || report.Sets != 1 || report.Deletes != 1 || report.NotFound != 1 {
+		t.Fatalf("a trace should be replayed once, got %+v", report)
+	}
+}
+
+func TestHistogram_Percentile(t *testing.T) {
+	var h histogram
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100000; i += 1 {
+		h.add(time.Duration(r.Int63n(int64(time.Second))))
+	}
+
+	for _, p := range []float64{0.5, 0.9, 0.99} {
+		want := time.Duration(p * float64(time.Second))
+		got := h.percentile(p)
+		if diff := (got - want).Seconds() / want.Seconds(); diff < -0.05 || diff > 0.05 {
+			t.Fatalf("p%v: want about %v, got %v", p*100, want, got)
+		}
+	}
+
+	if h.percentile(1) != h.max {
+		t.Fatal("p100 should be the max")
+	}
+}
@@ -0,0 +1,84 @@
+package bench
+
+import (
+	"math/bits"
+	"time"
+)
+
+// histogram keeps latencies in log-linear buckets, 32 per power of two
+// so a percentile is off by less than 4%, whatever the run length
+const (
+	subBuckets = 32
+	linear     = 2 * subBuckets
+	numBuckets = linear + (64-7)*subBuckets
+)
+
+type histogram struct {
+	counts [numBuckets]int64
+	count  int64
+	sum    time.Duration
+	max    time.Duration
+}
+
+func bucketOf(d time.Duration) int {
+	ns := uint64(max(d, 0))
+	if ns < linear {
+		return int(ns)
+	}
+
+	shift := bits.Len64(ns) - 6
+	return linear + (shift-1)*subBuckets + int(ns>>shift) - subBuckets
+}
+
+// the upper bound of a bucket
+func bucketValue(i int) time.Duration {
+	if i < linear {
+		return time.Duration(i)
+	}
+
+	shift := (i-linear)/subBuckets + 1
+	m := (i-linear)%subBuckets + subBuckets
+	return time.Duration((uint64(m+1) << shift) - 1)
+}
+
+func (h *histogram) add(d time.Duration) {
+	h.counts[bucketOf(d)] += 1
+	h.count += 1
+	h.sum += d
+	h.max = max(h.max, d)
+}
+
+func (h *histogram) merge(o *histogram) {
+	for i, c := range o.counts {
+		h.counts[i] += c
+	}
+	h.count += o.count
+	h.sum += o.sum
+	h.max = max(h.max, o.max)
+}
+
+func (h *histogram) mean() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.count)
+}
+
+// percentile for p in [0, 1], never above the largest sample
+func (h *histogram) percentile(p float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+
+	rank := int64(p * float64(h.count))
+	rank = min(max(rank, 1), h.count)
+
+	var seen int64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			return min(bucketValue(i), h.max)
+		}
+	}
+	return h.max
+}
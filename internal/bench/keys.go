@@ -0,0 +1,122 @@
+package bench
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync/atomic"
+)
+
+// the key distributions of a workload
+const (
+	Uniform = "uniform"
+	Zipf    = "zipf"
+	Hotspot = "hotspot"
+	Scan    = "scan"
+	Trace   = "trace"
+)
+
+// keyGen picks the index of the next key, every worker owns one
+type keyGen interface {
+	next() int
+}
+
+type uniformGen struct {
+	r *rand.Rand
+	n int
+}
+
+func (g *uniformGen) next() int { return g.r.Intn(g.n) }
+
+// hot keys are the first ones
+type hotspotGen struct {
+	r      *rand.Rand
+	n, hot int
+	hotOps float64
+}
+
+func (g *hotspotGen) next() int {
+	if g.r.Float64() < g.hotOps || g.hot == g.n {
+		return g.r.Intn(g.hot)
+	}
+	return g.hot + g.r.Intn(g.n-g.hot)
+}
+
+// scan walks the key space in order, the workers share the position
+type scanGen struct {
+	pos *atomic.Int64
+	n   int
+}
+
+func (g *scanGen) next() int { return int((g.pos.Add(1) - 1) % int64(g.n)) }
+
+// zipf with a skew below 1 is the usual case, see
+// Gray et al., Quickly Generating Billion-Record Synthetic Databases
+// the constants only depend on n and the skew, the workers share them
+type zipfConst struct {
+	n                   int
+	theta, alpha, zetan float64
+	eta, halfPowTheta   float64
+}
+
+func newZipfConst(n int, theta float64) (*zipfConst, error) {
+	if theta <= 0 || theta == 1 {
+		return nil, fmt.Errorf("zipf skew must be above 0 and not 1, got %v", theta)
+	}
+	if theta > 1 {
+		return &zipfConst{n: n, theta: theta}, nil
+	}
+
+	zetan := zeta(n, theta)
+	zeta2 := zeta(2, theta)
+
+	return &zipfConst{
+		n:            n,
+		theta:        theta,
+		alpha:        1 / (1 - theta),
+		zetan:        zetan,
+		eta:          (1 - math.Pow(2/float64(n), 1-theta)) / (1 - zeta2/zetan),
+		halfPowTheta: math.Pow(0.5, theta),
+	}, nil
+}
+
+func zeta(n int, theta float64) float64 {
+	sum := 0.0
+	for i := 1; i <= n; i += 1 {
+		sum += 1 / math.Pow(float64(i), theta)
+	}
+	return sum
+}
+
+func (c *zipfConst) gen(r *rand.Rand) keyGen {
+	if c.theta > 1 {
+		// the standard library covers skews above 1
+		return &stdZipf{z: rand.NewZipf(r, c.theta, 1, uint64(c.n-1))}
+	}
+	return &grayGen{r: r, c: c}
+}
+
+type stdZipf struct{ z *rand.Zipf }
+
+func (g *stdZipf) next() int { return int(g.z.Uint64()) }
+
+type grayGen struct {
+	r *rand.Rand
+	c *zipfConst
+}
+
+func (g *grayGen) next() int {
+	c := g.c
+	u := g.r.Float64()
+	uz := u * c.zetan
+
+	if uz < 1 {
+		return 0
+	}
+	if uz < 1+c.halfPowTheta {
+		return 1
+	}
+
+	i := int(float64(c.n) * math.Pow(c.eta*u-c.eta+1, c.alpha))
+	return min(i, c.n-1)
+}
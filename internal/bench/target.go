@@ -0,0 +1,129 @@
+package bench
+
+import (
+	"context"
+	"errors"
+
+	"github.com/golrice/e-fis/client"
+	"github.com/golrice/e-fis/internal/cache"
+)
+
+// ErrNotFound is what a target returns for a key it does not have
+var ErrNotFound = errors.New("not found")
+
+// target is what the load is driven against, a cluster or a node in this process
+type Target interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte) error
+	Delete(ctx context.Context, key string) error
+	// the node counters summed over the servers, taken before and after a run
+	Counters(ctx context.Context) (Counters, error)
+}
+
+type Counters struct {
+	Gets       int64 `json:"gets"`
+	Hits       int64 `json:"hits"`
+	PeerLoads  int64 `json:"peer_loads"`
+	LocalLoads int64 `json:"local_loads"`
+}
+
+func (c Counters) sub(o Counters) Counters {
+	return Counters{
+		Gets:       c.Gets - o.Gets,
+		Hits:       c.Hits - o.Hits,
+		PeerLoads:  c.PeerLoads - o.PeerLoads,
+		LocalLoads: c.LocalLoads - o.LocalLoads,
+	}
+}
+
+func (c *Counters) add(stats map[string]any) {
+	c.Gets += counter(stats["gets"])
+	c.Hits += counter(stats["hits"])
+	c.PeerLoads += counter(stats["peer_loads"])
+	c.LocalLoads += counter(stats["local_loads"])
+}
+
+// counters are int64 in process and float64 once they went through json
+func counter(v any) int64 {
+	switch v := v.(type) {
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	}
+	return 0
+}
+
+type nodeTarget struct {
+	node *cache.Node
+}
+
+// drive a node in this process, e.g. to compare policies without a network in between
+func NewNodeTarget(node *cache.Node) Target {
+	return &nodeTarget{node: node}
+}
+
+func (t *nodeTarget) Get(ctx context.Context, key string) ([]byte, error) {
+	v, err := t.node.GetContext(ctx, key)
+	if errors.Is(err, cache.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	return v.ByteSlice(), err
+}
+
+func (t *nodeTarget) Set(ctx context.Context, key string, value []byte) error {
+	return t.node.Set(key, value, 0)
+}
+
+func (t *nodeTarget) Delete(ctx context.Context, key string) error {
+	return t.node.Delete(key)
+}
+
+func (t *nodeTarget) Counters(ctx context.Context) (Counters, error) {
+	var c Counters
+	c.add(t.node.Stats())
+	return c, nil
+}
+
+type clientTarget struct {
+	client    *client.Client
+	namespace string
+}
+
+// drive a running cluster through the client
+func NewClientTarget(cl *client.Client, namespace string) Target {
+	return &clientTarget{client: cl, namespace: namespace}
+}
+
+func (t *clientTarget) Get(ctx context.Context, key string) ([]byte, error) {
+	v, err := t.client.Get(ctx, t.namespace, key)
+	if errors.Is(err, client.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	return v, err
+}
+
+func (t *clientTarget) Set(ctx context.Context, key string, value []byte) error {
+	return t.client.Set(ctx, t.namespace, key, value, 0)
+}
+
+func (t *clientTarget) Delete(ctx context.Context, key string) error {
+	err := t.client.Delete(ctx, t.namespace, key)
+	if errors.Is(err, client.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (t *clientTarget) Counters(ctx context.Context) (Counters, error) {
+	stats, err := t.client.Stats(ctx)
+
+	var c Counters
+	for _, server := range stats {
+		nodes, _ := server["nodes"].(map[string]any)
+		if node, ok := nodes[t.namespace].(map[string]any); ok {
+			c.add(node)
+		}
+	}
+	return c, err
+}
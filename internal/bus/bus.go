@@ -0,0 +1,426 @@
+package bus
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	pb "github.com/golrice/e-fis/internal/protocal"
+	"github.com/golrice/e-fis/internal/seqlog"
+)
+
+// a bus delivers the invalidations of this server to every peer, in order and at least once
+//
+// every message gets the next sequence number of its origin and stays in a log, a sender per
+// peer pushes what the peer did not acknowledge yet and retries with backoff, so a peer which
+// was down or left the ring catches up once it answers again. a peer whose next message fell
+// out of the log sees a jump in the sequence and is told to reset, see KindReset
+//
+// the receiver applies the messages of an origin in order and drops duplicates, the epoch tells
+// a restarted origin, whose sequence starts over, from the old one
+const (
+	defaultLogSize          = 4096
+	defaultBatchSize        = 256
+	defaultRetryInterval    = 100 * time.Millisecond
+	defaultMaxRetryInterval = 5 * time.Second
+)
+
+type Kind string
+
+const (
+	// the key changed at its owner, copies anywhere else are stale
+	KindKey Kind = "key"
+	// every key with the tag, Key holds the tag
+	KindTag Kind = "tag"
+	// every key with the prefix, Key holds the prefix
+	KindPrefix Kind = "prefix"
+	// everything cached before Generation
+	KindGeneration Kind = "generation"
+	// never sent, it is delivered when messages of Origin were lost, any copy may be stale
+	KindReset Kind = "reset"
+)
+
+type Message struct {
+	Seq       uint64
+	Time      time.Time
+	Namespace string
+	Kind      Kind
+	// the key, the tag or the prefix
+	Key        string
+	Generation uint64
+	// the member which published it, it is set on delivery
+	Origin string
+}
+
+// transport hands a batch to a peer, it returns the last sequence number the peer applied
+type Transport func(ctx context.Context, peer string, in *pb.BusBatch) (delivered uint64, err error)
+
+type Options struct {
+	// messages kept for peers which fall behind, 4096 by default
+	LogSize int
+	// messages sent at once, 256 by default
+	BatchSize int
+	// the wait after a failed send, it doubles up to MaxRetryInterval
+	RetryInterval    time.Duration
+	MaxRetryInterval time.Duration
+}
+
+func (o *Options) fill() {
+	if o.LogSize <= 0 {
+		o.LogSize = defaultLogSize
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = defaultBatchSize
+	}
+	if o.RetryInterval <= 0 {
+		o.RetryInterval = defaultRetryInterval
+	}
+	if o.MaxRetryInterval < o.RetryInterval {
+		o.MaxRetryInterval = max(defaultMaxRetryInterval, o.RetryInterval)
+	}
+}
+
+type PeerStatus struct {
+	// false once the peer left, it catches up when it is back
+	Active  bool   `json:"active"`
+	Acked   uint64 `json:"acked"`
+	Pending uint64 `json:"pending"`
+	// how long the oldest message the peer did not acknowledge waits
+	LagMs     float64 `json:"lag_ms"`
+	Errors    int64   `json:"errors"`
+	LastError string  `json:"last_error,omitempty"`
+}
+
+type OriginStatus struct {
+	Epoch     int64  `json:"epoch"`
+	Delivered uint64 `json:"delivered"`
+	// from publishing to applying the last message, the clocks of the two servers may differ
+	LagMs      float64 `json:"lag_ms"`
+	MaxLagMs   float64 `json:"max_lag_ms"`
+	Duplicates int64   `json:"duplicates"`
+	Resets     int64   `json:"resets"`
+}
+
+type Status struct {
+	Self    string                  `json:"self"`
+	Epoch   int64                   `json:"epoch"`
+	Seq     uint64                  `json:"seq"`
+	Peers   map[string]PeerStatus   `json:"peers"`
+	Origins map[string]OriginStatus `json:"origins"`
+}
+
+type peerState struct {
+	active bool
+	// the highest sequence number the peer acknowledged
+	acked   uint64
+	wake    chan struct{}
+	stop    chan struct{}
+	errors  int64
+	lastErr error
+}
+
+type originState struct {
+	epoch     int64
+	delivered uint64
+	lag       time.Duration
+	maxLag    time.Duration
+	dups      int64
+	resets    int64
+}
+
+type Bus struct {
+	self      string
+	epoch     int64
+	transport Transport
+	opts      Options
+
+	mu    sync.Mutex
+	log   *seqlog.Log[Message]
+	peers map[string]*peerState
+	subs  []func(Message)
+
+	// receiving is apart, a slow subscriber does not hold up publishing
+	rmu     sync.Mutex
+	origins map[string]*originState
+
+	now func() time.Time
+}
+
+// self is the name peers know this server by, it is never sent to itself
+func New(self string, transport Transport, opts Options) *Bus {
+	opts.fill()
+
+	return &Bus{
+		self:      self,
+		epoch:     time.Now().UnixNano(),
+		transport: transport,
+		opts:      opts,
+		log:       seqlog.New[Message](opts.LogSize),
+		peers:     make(map[string]*peerState),
+		origins:   make(map[string]*originState),
+		now:       time.Now,
+	}
+}
+
+// subscribe to the messages of the other servers, fn is called in the order of each origin
+func (b *Bus) Subscribe(fn func(Message)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.subs = append(b.subs, fn)
+}
+
+// the peers to deliver to, a peer which left keeps its place in the log for when it is back
+// a new peer only gets what is published from now on, it has nothing cached from before
+func (b *Bus) SetPeers(peers ...string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	keep := make(map[string]bool, len(peers))
+	for _, addr := range peers {
+		if addr == b.self {
+			continue
+		}
+		keep[addr] = true
+
+		p, ok := b.peers[addr]
+		if !ok {
+			p = &peerState{acked: b.log.Seq(), wake: make(chan struct{}, 1)}
+			b.peers[addr] = p
+		}
+		if !p.active {
+			p.active = true
+			p.stop = make(chan struct{})
+			go b.sendLoop(addr, p, p.stop)
+			// it may have missed something while it was away
+			wake(p)
+		}
+	}
+
+	for addr, p := range b.peers {
+		if p.active && !keep[addr] {
+			p.active = false
+			close(p.stop)
+		}
+	}
+}
+
+// stop every sender, the bus can not be used after
+func (b *Bus) Close() {
+	b.SetPeers()
+}
+
+func wake(p *peerState) {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// publish hands the message to every peer, it is not delivered here, it returns its sequence number
+func (b *Bus) Publish(msg Message) uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	msg.Seq = b.log.Seq() + 1
+	msg.Origin = b.self
+	if msg.Time.IsZero() {
+		msg.Time = b.now()
+	}
+	b.log.Append(msg)
+
+	for _, p := range b.peers {
+		if p.active {
+			wake(p)
+		}
+	}
+
+	return msg.Seq
+}
+
+// the messages after what the peer acknowledged, nil if it is up to date
+func (b *Bus) nextBatch(p *peerState) *pb.BusBatch {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// what is not in the log anymore is lost, the peer sees the jump
+	messages := b.log.After(p.acked, b.opts.BatchSize)
+	if len(messages) == 0 {
+		return nil
+	}
+
+	in := &pb.BusBatch{Origin: b.self, Epoch: b.epoch}
+	for _, m := range messages {
+		in.Messages = append(in.Messages, &pb.BusMessage{
+			Seq:        m.Seq,
+			Time:       m.Time.UnixNano(),
+			NodeName:   m.Namespace,
+			Kind:       string(m.Kind),
+			Key:        m.Key,
+			Generation: m.Generation,
+		})
+	}
+	return in
+}
+
+func (b *Bus) sendLoop(addr string, p *peerState, stop chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	var backoff time.Duration
+	for {
+		in := b.nextBatch(p)
+		if in == nil {
+			select {
+			case <-p.wake:
+				continue
+			case <-stop:
+				return
+			}
+		}
+
+		delivered, err := b.transport(ctx, addr, in)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			b.fail(addr, p, err)
+
+			backoff = min(max(2*backoff, b.opts.RetryInterval), b.opts.MaxRetryInterval)
+			select {
+			case <-time.After(backoff):
+			case <-stop:
+				return
+			}
+			continue
+		}
+
+		backoff = 0
+		b.mu.Lock()
+		// the peer may be ahead, when an earlier answer got lost
+		p.acked = min(max(delivered, in.Messages[len(in.Messages)-1].Seq), b.log.Seq())
+		p.lastErr = nil
+		b.mu.Unlock()
+	}
+}
+
+func (b *Bus) fail(addr string, p *peerState, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if p.lastErr == nil {
+		log.Printf("[Bus] fail to deliver to %s, retrying: %s", addr, err.Error())
+	}
+	p.errors += 1
+	p.lastErr = err
+}
+
+// receive applies a batch from a peer, it returns the last sequence number applied of the origin
+func (b *Bus) Receive(in *pb.BusBatch) uint64 {
+	b.rmu.Lock()
+	defer b.rmu.Unlock()
+
+	now := b.now()
+	st, ok := b.origins[in.Origin]
+	if !ok || st.epoch != in.Epoch {
+		fresh := &originState{epoch: in.Epoch}
+		if len(in.Messages) > 0 {
+			fresh.delivered = in.Messages[0].Seq - 1
+		}
+		// the origin restarted, what it had not sent before is lost
+		if ok {
+			fresh.resets = st.resets + 1
+			log.Printf("[Bus] %s restarted, its earlier messages may be lost", in.Origin)
+			b.deliver(Message{Kind: KindReset, Origin: in.Origin, Time: now})
+		}
+		st = fresh
+		b.origins[in.Origin] = st
+	}
+
+	for _, m := range in.Messages {
+		if m.Seq <= st.delivered {
+			st.dups += 1
+			continue
+		}
+		if m.Seq > st.delivered+1 {
+			st.resets += 1
+			log.Printf("[Bus] lost messages %d to %d of %s", st.delivered+1, m.Seq-1, in.Origin)
+			b.deliver(Message{Kind: KindReset, Origin: in.Origin, Time: now})
+		}
+
+		msg := Message{
+			Seq:        m.Seq,
+			Time:       time.Unix(0, m.Time),
+			Namespace:  m.NodeName,
+			Kind:       Kind(m.Kind),
+			Key:        m.Key,
+			Generation: m.Generation,
+			Origin:     in.Origin,
+		}
+		b.deliver(msg)
+
+		st.delivered = m.Seq
+		st.lag = now.Sub(msg.Time)
+		st.maxLag = max(st.maxLag, st.lag)
+	}
+
+	return st.delivered
+}
+
+func (b *Bus) deliver(msg Message) {
+	b.mu.Lock()
+	subs := b.subs
+	b.mu.Unlock()
+
+	for _, fn := range subs {
+		fn(msg)
+	}
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func (b *Bus) Status() Status {
+	now := b.now()
+
+	b.mu.Lock()
+	status := Status{
+		Self:    b.self,
+		Epoch:   b.epoch,
+		Seq:     b.log.Seq(),
+		Peers:   make(map[string]PeerStatus, len(b.peers)),
+		Origins: make(map[string]OriginStatus),
+	}
+	for addr, p := range b.peers {
+		ps := PeerStatus{Active: p.active, Acked: p.acked, Pending: b.log.Seq() - p.acked, Errors: p.errors}
+		if p.lastErr != nil {
+			ps.LastError = p.lastErr.Error()
+		}
+		if next := b.log.After(p.acked, 1); len(next) > 0 {
+			ps.LagMs = ms(now.Sub(next[0].Time))
+		}
+		status.Peers[addr] = ps
+	}
+	b.mu.Unlock()
+
+	b.rmu.Lock()
+	for origin, st := range b.origins {
+		status.Origins[origin] = OriginStatus{
+			Epoch:      st.epoch,
+			Delivered:  st.delivered,
+			LagMs:      ms(st.lag),
+			MaxLagMs:   ms(st.maxLag),
+			Duplicates: st.dups,
+			Resets:     st.resets,
+		}
+	}
+	b.rmu.Unlock()
+
+	return status
+}
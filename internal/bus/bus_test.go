@@ -0,0 +1,214 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/golrice/e-fis/internal/protocal"
+)
+
+// buses which reach each other in memory, a member which is down fails every send
+type network struct {
+	mu    sync.Mutex
+	buses map[string]*Bus
+	down  map[string]bool
+}
+
+func newNetwork() *network {
+	return &network{buses: map[string]*Bus{}, down: map[string]bool{}}
+}
+
+func (n *network) send(ctx context.Context, peer string, in *pb.BusBatch) (uint64, error) {
+	n.mu.Lock()
+	b, ok := n.buses[peer]
+	down := n.down[peer]
+	n.mu.Unlock()
+
+	if !ok || down {
+		return 0, errors.New(peer + " is down")
+	}
+	return b.Receive(in), nil
+}
+
+func (n *network) setDown(peer string, down bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.down[peer] = down
+}
+
+// a member which records what it is delivered
+type member struct {
+	*Bus
+	mu  sync.Mutex
+	got []Message
+}
+
+func (n *network) join(t *testing.T, name string, opts Options) *member {
+	opts.RetryInterval = 5 * time.Millisecond
+	opts.MaxRetryInterval = 20 * time.Millisecond
+
+	m := &member{Bus: New(name, n.send, opts)}
+	m.Subscribe(func(msg Message) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.got = append(m.got, msg)
+	})
+	t.Cleanup(m.Close)
+
+	n.mu.Lock()
+	n.buses[name] = m.Bus
+	n.mu.Unlock()
+
+	return m
+}
+
+func (m *member) received() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Message(nil), m.got...)
+}
+
+func (m *member) waitFor(t *testing.T, n int) []Message {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if got := m.received(); len(got) >= n {
+			return got
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("%s got %d messages, want %d", m.self, len(m.received()), n)
+	return nil
+}
+
+func publish(b *Bus, from, to int) {
+	for i := from; i < to; i += 1 {
+		b.Publish(Message{Namespace: "scores", Kind: KindKey, Key: fmt.Sprint("k", i)})
+	}
+}
+
+// the keys in order, a reset is *
+func keysOf(msgs []Message) string {
+	s := ""
+	for _, m := range msgs {
+		if m.Kind == KindReset {
+			s += "* "
+			continue
+		}
+		s += m.Key + " "
+	}
+	return s
+}
+
+func TestBus_Delivers(t *testing.T) {
+	net := newNetwork()
+	a := net.join(t, "a", Options{BatchSize: 3})
+	b := net.join(t, "b", Options{})
+	c := net.join(t, "c", Options{})
+	a.SetPeers("a", "b", "c")
+
+	publish(a.Bus, 0, 10)
+
+	want := "k0 k1 k2 k3 k4 k5 k6 k7 k8 k9 "
+	for _, m := range []*member{b, c} {
+		if got := keysOf(m.waitFor(t, 10)); got != want {
+			t.Fatalf("%s got %q, want %q", m.self, got, want)
+		}
+	}
+	if len(a.received()) != 0 {
+		t.Fatal("a message should not be delivered to its origin")
+	}
+
+	msg := b.received()[0]
+	if msg.Origin != "a" || msg.Seq != 1 || msg.Namespace != "scores" {
+		t.Fatalf("unexpected message %+v", msg)
+	}
+	status := a.Status()
+	if status.Peers["b"].Pending != 0 || status.Peers["b"].Acked != 10 {
+		t.Fatalf("b should have acknowledged everything, got %+v", status.Peers["b"])
+	}
+	if o := b.Status().Origins["a"]; o.Delivered != 10 || o.LagMs < 0 {
+		t.Fatalf("unexpected origin status %+v", o)
+	}
+}
+
+func TestBus_CatchUp(t *testing.T) {
+	net := newNetwork()
+	a := net.join(t, "a", Options{})
+	b := net.join(t, "b", Options{})
+	a.SetPeers("b")
+
+	// b is unreachable, the messages wait for it
+	net.setDown("b", true)
+	publish(a.Bus, 0, 3)
+	time.Sleep(30 * time.Millisecond)
+	if s := a.Status().Peers["b"]; s.Pending != 3 || s.Errors == 0 {
+		t.Fatalf("want 3 pending after failures, got %+v", s)
+	}
+	net.setDown("b", false)
+	b.waitFor(t, 3)
+
+	// b leaves the ring and comes back
+	a.SetPeers()
+	publish(a.Bus, 3, 5)
+	a.SetPeers("b")
+
+	if got := keysOf(b.waitFor(t, 5)); got != "k0 k1 k2 k3 k4 " {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestBus_LostMessages(t *testing.T) {
+	net := newNetwork()
+	a := net.join(t, "a", Options{LogSize: 2})
+	b := net.join(t, "b", Options{})
+	a.SetPeers("b")
+
+	publish(a.Bus, 0, 1)
+	b.waitFor(t, 1)
+
+	net.setDown("b", true)
+	publish(a.Bus, 1, 10)
+	net.setDown("b", false)
+
+	// the log keeps two to four messages, b learns it missed the others
+	got := b.waitFor(t, 4)
+	if got[1].Kind != KindReset || got[len(got)-1].Key != "k9" {
+		t.Fatalf("want a reset and the last messages, got %q", keysOf(got))
+	}
+	if b.Status().Origins["a"].Resets != 1 {
+		t.Fatal("the reset should be counted")
+	}
+}
+
+func TestBus_DuplicatesAndRestart(t *testing.T) {
+	net := newNetwork()
+	b := net.join(t, "b", Options{})
+
+	in := &pb.BusBatch{Origin: "a", Epoch: 1, Messages: []*pb.BusMessage{
+		{Seq: 1, Kind: string(KindTag), Key: "t1"},
+		{Seq: 2, Kind: string(KindTag), Key: "t2"},
+	}}
+	if b.Receive(in) != 2 || b.Receive(in) != 2 {
+		t.Fatal("want 2 delivered")
+	}
+	if got := keysOf(b.received()); got != "t1 t2 " {
+		t.Fatalf("duplicates should be dropped, got %q", got)
+	}
+
+	// a restarted, its sequence starts over
+	in = &pb.BusBatch{Origin: "a", Epoch: 2, Messages: []*pb.BusMessage{{Seq: 1, Kind: string(KindTag), Key: "t3"}}}
+	if b.Receive(in) != 1 {
+		t.Fatal("want the new epoch delivered")
+	}
+	if got := keysOf(b.received()); got != "t1 t2 * t3 " {
+		t.Fatalf("got %q", got)
+	}
+}
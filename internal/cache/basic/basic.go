@@ -29,7 +29,10 @@ type BasicCache interface {
 	Get(key string) (value Value, ok bool)
 	RemoveByStrategy()
 	Add(key string, value Value)
-	Delete(key string)
+	// whether the key was cached
+	Delete(key string) (ok bool)
 	Update(key string, value Value) (ok bool)
 	Len() int
+	// all keys, in the order the strategy keeps them
+	Keys() []string
 }
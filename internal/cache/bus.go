@@ -0,0 +1,97 @@
+package cache
+
+import (
+	"github.com/golrice/e-fis/internal/bus"
+)
+
+// the nodes publish their writes and invalidations on the bus and apply those of the peers
+//
+// a key may be cached at any member, as a local load, a spread copy or a handoff, a write at its
+// owner drops it everywhere else. the owner keeps its value, it is the one which was written
+// messages of a peer we lost track of reset every node to the keys it owns
+func (g *Graph) Subscribe(b *bus.Bus) {
+	g.mu.Lock()
+	if g.bus != nil {
+		g.mu.Unlock()
+		panic("Subscribe called more than once")
+	}
+	g.bus = b
+	for _, node := range g.records {
+		node.setBus(b)
+	}
+	g.mu.Unlock()
+
+	b.Subscribe(g.apply)
+}
+
+func (g *Graph) apply(msg bus.Message) {
+	if msg.Kind == bus.KindReset {
+		for _, node := range g.Nodes() {
+			node.stats.Add("bus_reset_keys", int64(node.dropForeign()))
+		}
+		return
+	}
+
+	node, err := GetNode(g, msg.Namespace)
+	if err != nil {
+		// a namespace which was created at the origin only
+		return
+	}
+
+	node.stats.Inc("bus_applied")
+	switch msg.Kind {
+	case bus.KindKey:
+		if !node.owns(msg.Key) {
+			node.Remove(msg.Key)
+		}
+	case bus.KindTag:
+		node.RemoveTag(msg.Key)
+	case bus.KindPrefix:
+		node.RemovePrefix(msg.Key)
+	case bus.KindGeneration:
+		node.AdvanceGeneration(msg.Generation)
+	}
+}
+
+func (n *Node) setBus(b *bus.Bus) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.bus = b
+}
+
+// publish tells the peers, it returns false if there is no bus
+func (n *Node) publish(kind bus.Kind, key string, gen uint64) bool {
+	n.mu.Lock()
+	b := n.bus
+	n.mu.Unlock()
+
+	if b == nil {
+		return false
+	}
+	b.Publish(bus.Message{Namespace: n.name, Kind: kind, Key: key, Generation: gen})
+	n.stats.Inc("bus_published")
+
+	return true
+}
+
+// whether key belongs here, without peers everything does
+func (n *Node) owns(key string) bool {
+	if n.peers == nil {
+		return true
+	}
+	_, ok := n.pickOwner(key)
+	return !ok
+}
+
+// drop every key owned by another member, it returns how many there were
+func (n *Node) dropForeign() int {
+	dropped := 0
+	for _, key := range n.cache.keys() {
+		if !n.owns(key) {
+			n.Remove(key)
+			dropped += 1
+		}
+	}
+	return dropped
+}
@@ -0,0 +1,104 @@
+package cache
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golrice/e-fis/internal/bus"
+	"github.com/golrice/e-fis/internal/peer"
+	pb "github.com/golrice/e-fis/internal/protocal"
+)
+
+// we own the keys with the prefix, another member owns the others
+type prefixPicker struct {
+	prefix string
+	owner  peer.PeerGetter
+}
+
+func (p *prefixPicker) PickPeer(key string) (peer.PeerGetter, bool) {
+	if strings.HasPrefix(key, p.prefix) {
+		return nil, false
+	}
+	return p.owner, true
+}
+
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestGraph_Subscribe(t *testing.T) {
+	// b only listens to a
+	busB := bus.New("b", nil, bus.Options{})
+	busA := bus.New("a", func(ctx context.Context, peer string, in *pb.BusBatch) (uint64, error) {
+		return busB.Receive(in), nil
+	}, bus.Options{})
+	busA.SetPeers("b")
+	defer busA.Close()
+
+	getter := func(key string) ([]byte, error) { return nil, ErrNotFound }
+	graphA, graphB := DefaultGraph(), DefaultGraph()
+	graphA.Subscribe(busA)
+	graphB.Subscribe(busB)
+	// added after subscribing
+	a := NewNode("users", 0, getter)
+	graphA.AddNode(a)
+	b := NewNode("users", 0, getter)
+	b.RegisterPeers(&prefixPicker{prefix: "b:", owner: &invalidationPeer{}})
+	graphB.AddNode(b)
+
+	has := func(key string) func() bool {
+		return func() bool {
+			_, ok := b.Peek(key)
+			return ok
+		}
+	}
+	gone := func(key string) func() bool {
+		return func() bool {
+			_, ok := b.Peek(key)
+			return !ok
+		}
+	}
+
+	// a copy of a key owned by a, and keys which b owns itself
+	b.SetLocal("k", []byte("old"))
+	b.SetLocal("b:mine", []byte("v"))
+	b.SetLocalUntil("t", []byte("v"), time.Time{}, "tag")
+	b.SetLocal("p:1", []byte("v"))
+
+	a.Set("b:mine", []byte("v2"), 0)
+	a.Set("k", []byte("new"), 0)
+	eventually(t, "a write should drop the copy", gone("k"))
+	if !has("b:mine")() {
+		t.Fatal("the owner should keep its value")
+	}
+
+	if err := a.InvalidateTag("tag"); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the tag should be dropped", gone("t"))
+	a.InvalidatePrefix("p:")
+	eventually(t, "the prefix should be dropped", gone("p:1"))
+	if _, err := a.Bump(); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the bump should arrive", func() bool { return b.Generation() == 1 })
+
+	// b missed messages of c, it keeps only what it owns
+	b.SetLocal("k", []byte("new"))
+	b.SetLocal("b:mine", []byte("v"))
+	busB.Receive(&pb.BusBatch{Origin: "c", Epoch: 1, Messages: []*pb.BusMessage{{Seq: 1}}})
+	busB.Receive(&pb.BusBatch{Origin: "c", Epoch: 1, Messages: []*pb.BusMessage{{Seq: 5}}})
+	if has("k")() || !has("b:mine")() {
+		t.Fatalf("want only the owned keys after a reset, got %v", b.Keys())
+	}
+}
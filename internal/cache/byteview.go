@@ -1,8 +1,14 @@
 package cache
 
+import "time"
+
 // a view of bytes
 type ByteView struct {
 	b []byte
+	// zero if it never expires
+	expire time.Time
+	// the generation of the node when it was cached, see Node.Bump
+	gen uint64
 }
 
 func NewByteView(b []byte) ByteView {
@@ -21,6 +27,30 @@ func (v ByteView) ByteSlice() []byte {
 	return cloneBytes(v.b)
 }
 
+// when the value expires, zero if it never does
+func (v ByteView) Expire() time.Time {
+	return v.expire
+}
+
+func (v ByteView) expired(now time.Time) bool {
+	return !v.expire.IsZero() && !now.Before(v.expire)
+}
+
+// expire times travel between peers as unix nanoseconds, 0 means never
+func ExpireToNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func ExpireFromNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
 func cloneBytes(b []byte) []byte {
 	c := make([]byte, len(b))
 	copy(c, b)
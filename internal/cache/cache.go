@@ -1,48 +1,172 @@
 package cache
 
 import (
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/golrice/e-fis/internal/cache/basic"
 	"github.com/golrice/e-fis/internal/cache/fifo"
 	"github.com/golrice/e-fis/internal/cache/lfu"
 	"github.com/golrice/e-fis/internal/cache/lru"
+	"github.com/golrice/e-fis/internal/watch"
 )
 
 type cache struct {
 	mu       sync.Mutex
 	bc       basic.BasicCache
 	capacity int64
+
+	// tag -> keys and key -> tags, an entry leaves both when it is evicted, deleted or expires
+	tagged map[string]map[string]struct{}
+	tags   map[string][]string
+	// entries of an older generation are misses, they are dropped lazily like expired ones
+	gen atomic.Uint64
+	// every change is published there, in the order it happened
+	watcher atomic.Pointer[watcher]
+}
+
+type watcher struct {
+	hub       *watch.Hub
+	namespace string
+}
+
+// the eviction policies NewCache knows
+func Policies() []string {
+	return []string{"fifo", "lfu", "lru"}
+}
+
+func validPolicy(policy string) bool {
+	for _, p := range Policies() {
+		if p == policy {
+			return true
+		}
+	}
+	return false
 }
 
 func NewCache(capacity int64, bc string) *cache {
-	var bcache basic.BasicCache
+	c := &cache{
+		mu:       sync.Mutex{},
+		capacity: capacity,
+		tagged:   map[string]map[string]struct{}{},
+		tags:     map[string][]string{},
+	}
 	switch bc {
 	case "lru":
-		bcache = lru.New(capacity, nil)
+		c.bc = lru.New(capacity, c.onRemove)
 	case "fifo":
-		bcache = fifo.New(capacity, nil)
+		c.bc = fifo.New(capacity, c.onRemove)
 	case "lfu":
-		bcache = lfu.New(capacity, nil)
+		c.bc = lfu.New(capacity, c.onRemove)
 	}
-	return &cache{
-		mu:       sync.Mutex{},
-		bc:       bcache,
-		capacity: capacity,
+	return c
+}
+
+// every policy calls it for the entries it evicts, with c.mu held
+func (c *cache) onRemove(key string, value basic.Value) {
+	c.untagLocked(key)
+	c.emit(watch.KindEvict, key, time.Time{})
+}
+
+// called with c.mu held, so that the events are in the order of the changes
+func (c *cache) emit(kind watch.Kind, key string, expire time.Time) {
+	if w := c.watcher.Load(); w != nil {
+		w.hub.Publish(watch.Event{Namespace: w.namespace, Kind: kind, Key: key, Expire: expire})
 	}
 }
 
-func (c *cache) add(key string, value ByteView) {
+// the tags replace those the key had, no tags drop them
+func (c *cache) add(key string, value ByteView, tags ...string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	if c.bc == nil {
-		c.bc = lru.New(c.capacity, nil)
+		c.bc = lru.New(c.capacity, c.onRemove)
 	}
 
+	// tagged first, a value which does not fit is evicted at once and untagged again
+	c.untagLocked(key)
+	c.tagLocked(key, tags)
+	value.gen = c.gen.Load()
+	c.emit(watch.KindSet, key, value.expire)
 	c.bc.Add(key, value)
 }
 
+// must be called with c.mu held
+func (c *cache) tagLocked(key string, tags []string) {
+	if len(tags) == 0 {
+		return
+	}
+
+	c.tags[key] = append([]string(nil), tags...)
+	for _, tag := range tags {
+		keys, ok := c.tagged[tag]
+		if !ok {
+			keys = map[string]struct{}{}
+			c.tagged[tag] = keys
+		}
+		keys[key] = struct{}{}
+	}
+}
+
+// must be called with c.mu held
+func (c *cache) untagLocked(key string) {
+	for _, tag := range c.tags[key] {
+		delete(c.tagged[tag], key)
+		if len(c.tagged[tag]) == 0 {
+			delete(c.tagged, tag)
+		}
+	}
+	delete(c.tags, key)
+}
+
+func (c *cache) tagsOf(key string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]string(nil), c.tags[key]...)
+}
+
+// drop every key with the tag, it returns the keys
+func (c *cache) removeTag(tag string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := make([]string, 0, len(c.tagged[tag]))
+	for key := range c.tagged[tag] {
+		keys = append(keys, key)
+	}
+	for _, key := range keys {
+		c.deleteLocked(key)
+		c.emit(watch.KindDelete, key, time.Time{})
+	}
+	return keys
+}
+
+// drop every key which starts with prefix, it returns the keys
+func (c *cache) removePrefix(prefix string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.bc == nil {
+		return nil
+	}
+
+	var keys []string
+	for _, key := range c.bc.Keys() {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	for _, key := range keys {
+		c.deleteLocked(key)
+		c.emit(watch.KindDelete, key, time.Time{})
+	}
+	return keys
+}
+
 func (c *cache) get(key string) (value ByteView, ok bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -53,21 +177,45 @@ func (c *cache) get(key string) (value ByteView, ok bool) {
 
 	if v, ok := c.bc.Get(key); ok {
 		bv := v.(ByteView)
-		return NewByteView(bv.ByteSlice()), ok
+		// expired and stale values are dropped lazily, when they are read
+		// a stale one was announced with the flush already
+		if bv.expired(time.Now()) || bv.gen < c.gen.Load() {
+			c.deleteLocked(key)
+			if bv.gen == c.gen.Load() {
+				c.emit(watch.KindExpire, key, time.Time{})
+			}
+			return ByteView{}, false
+		}
+		return ByteView{b: bv.ByteSlice(), expire: bv.expire}, ok
 	}
 
 	return
 }
 
+// only a key which was cached is announced
 func (c *cache) delete(key string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if c.bc == nil {
-		return
+	if c.bc != nil && c.deleteLocked(key) {
+		c.emit(watch.KindDelete, key, time.Time{})
 	}
+}
+
+func (c *cache) drop(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	c.bc.Delete(key)
+	if c.bc != nil {
+		c.deleteLocked(key)
+	}
+}
+
+// must be called with c.mu held
+func (c *cache) deleteLocked(key string) bool {
+	ok := c.bc.Delete(key)
+	c.untagLocked(key)
+	return ok
 }
 
 func (c *cache) update(key string, value ByteView) (ok bool) {
@@ -75,8 +223,43 @@ func (c *cache) update(key string, value ByteView) (ok bool) {
 	defer c.mu.Unlock()
 
 	if c.bc == nil {
-		c.bc = lru.New(c.capacity, nil)
+		c.bc = lru.New(c.capacity, c.onRemove)
+	}
+
+	value.gen = c.gen.Load()
+	if ok = c.bc.Update(key, value); ok {
+		c.emit(watch.KindSet, key, value.expire)
+	}
+	return ok
+}
+
+func (c *cache) generation() uint64 {
+	return c.gen.Load()
+}
+
+// raise the generation to gen, it returns false if it is not higher
+func (c *cache) advance(gen uint64) bool {
+	for {
+		old := c.gen.Load()
+		if gen <= old {
+			return false
+		}
+		if c.gen.CompareAndSwap(old, gen) {
+			c.mu.Lock()
+			c.emit(watch.KindFlush, "", time.Time{})
+			c.mu.Unlock()
+			return true
+		}
+	}
+}
+
+func (c *cache) keys() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.bc == nil {
+		return nil
 	}
 
-	return c.bc.Update(key, value)
+	return c.bc.Keys()
 }
@@ -65,3 +65,68 @@ func TestCache_Get(t *testing.T) {
 // func TestCache_Remove(t *testing.T) {
 
 // }
+
+func TestCache_TagsFollowEvictions(t *testing.T) {
+	for _, policy := range Policies() {
+		t.Run(policy, func(t *testing.T) {
+			// room for about ten entries
+			c := NewCache(100, policy)
+			for i := 0; i < 50; i += 1 {
+				key := "k" + strconv.Itoa(i)
+				c.add(key, NewByteView([]byte("value")), "all", "tag"+strconv.Itoa(i%2))
+			}
+
+			cached := map[string]bool{}
+			for _, key := range c.keys() {
+				cached[key] = true
+			}
+			c.mu.Lock()
+			tagged := len(c.tags)
+			for key := range c.tagged["all"] {
+				if !cached[key] {
+					t.Errorf("%s is evicted but still tagged", key)
+				}
+			}
+			c.mu.Unlock()
+			if tagged != len(cached) {
+				t.Fatalf("%d keys are cached but %d are tagged", len(cached), tagged)
+			}
+
+			removed := c.removeTag("tag0")
+			for _, key := range removed {
+				if _, ok := c.get(key); ok {
+					t.Fatalf("%s should be gone with its tag", key)
+				}
+			}
+			if len(c.removeTag("all")) != len(cached)-len(removed) {
+				t.Fatal("the other keys should still be tagged")
+			}
+			if len(c.keys()) != 0 || len(c.tagged) != 0 || len(c.tags) != 0 {
+				t.Fatal("the index should be empty with the cache")
+			}
+
+			// a value which never fits is evicted at once, and not tagged either
+			c.add("huge", NewByteView(make([]byte, 200)), "all")
+			if tags := c.tagsOf("huge"); len(tags) != 0 {
+				t.Fatalf("want no tags for an evicted key, got %v", tags)
+			}
+		})
+	}
+}
+
+func TestCache_RemovePrefix(t *testing.T) {
+	c := NewCache(0, "lru")
+	for _, key := range []string{"user:1:name", "user:1:mail", "user:2:name", "item:1"} {
+		c.add(key, NewByteView([]byte("v")), "t")
+	}
+
+	if removed := c.removePrefix("user:1:"); len(removed) != 2 {
+		t.Fatalf("want 2 keys removed, got %v", removed)
+	}
+	if _, ok := c.get("user:2:name"); !ok {
+		t.Fatal("user:2:name should stay")
+	}
+	if len(c.tagged["t"]) != 2 {
+		t.Fatal("removed keys should leave the tag index")
+	}
+}
@@ -75,7 +75,7 @@ func (c *FifoCache) Add(key string, value basic.Value) {
 		c.RemoveByStrategy()
 	}
 }
-func (c *FifoCache) Delete(key string) {
+func (c *FifoCache) Delete(key string) (ok bool) {
 	e, ok := c.Cache[key]
 	if !ok {
 		return
@@ -86,6 +86,8 @@ func (c *FifoCache) Delete(key string) {
 	c.Mem.UsedBytes -= int64(len(key)) + int64(v.value.Len())
 	delete(c.Cache, key)
 	c.Bl.Remove(e)
+
+	return true
 }
 
 func (c *FifoCache) Update(key string, value basic.Value) (ok bool) {
@@ -109,3 +111,11 @@ func (c *FifoCache) Update(key string, value basic.Value) (ok bool) {
 func (c *FifoCache) Len() int {
 	return c.Bl.Len()
 }
+
+func (c *FifoCache) Keys() []string {
+	keys := make([]string, 0, c.Bl.Len())
+	for e := c.Bl.Front(); e != nil; e = e.Next() {
+		keys = append(keys, e.Value.(*entry).key)
+	}
+	return keys
+}
@@ -27,7 +27,7 @@ func (c *Controler) Do(key string, f func() (any, error)) (any, error) {
 		// wait for the function return
 		c.mu.Unlock()
 		v.wg.Wait()
-		return v.val, nil
+		return v.val, v.err
 	}
 
 	// first call
@@ -0,0 +1,33 @@
+package cache
+
+import (
+	"github.com/golrice/e-fis/internal/bus"
+	pb "github.com/golrice/e-fis/internal/protocal"
+)
+
+// every entry is cached with the generation of the node, entries of an older one are misses
+func (n *Node) Generation() uint64 {
+	return n.cache.generation()
+}
+
+// raise the generation to gen here, a lower one is ignored, so bumps may arrive twice or out of order
+// it returns whether the generation changed
+func (n *Node) AdvanceGeneration(gen uint64) bool {
+	if !n.cache.advance(gen) {
+		return false
+	}
+	n.stats.Inc("generation_bumps")
+	return true
+}
+
+// bump flushes the node on every peer in constant time, whatever is cached now is never read again
+// and it is evicted lazily, it returns the new generation
+func (n *Node) Bump() (uint64, error) {
+	gen := n.Generation() + 1
+	n.AdvanceGeneration(gen)
+	if n.publish(bus.KindGeneration, "", gen) {
+		return gen, nil
+	}
+
+	return gen, n.broadcast(&pb.InvalidateRequest{NodeName: n.name, Generation: gen})
+}
@@ -0,0 +1,67 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/golrice/e-fis/internal/peer"
+)
+
+func TestNode_Bump(t *testing.T) {
+	p := &invalidationPeer{}
+	loads := 0
+	node := NewNode("scores", 0, func(key string) ([]byte, error) {
+		loads += 1
+		return []byte("value"), nil
+	})
+	node.RegisterPeers(&listPicker{peers: []peer.PeerGetter{p}})
+
+	node.Get("a")
+	node.Get("a")
+	if loads != 1 {
+		t.Fatalf("want 1 load before the bump, got %d", loads)
+	}
+
+	gen, err := node.Bump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gen != 1 || node.Generation() != 1 {
+		t.Fatalf("want generation 1, got %d", gen)
+	}
+	if _, ok := node.Peek("a"); ok {
+		t.Fatal("an entry of the old generation should be a miss")
+	}
+	node.Get("a")
+	node.Get("a")
+	if loads != 2 {
+		t.Fatalf("want 1 more load after the bump, got %d", loads-1)
+	}
+
+	if len(p.reqs) != 1 || p.reqs[0].Generation != 1 || p.reqs[0].NodeName != "scores" {
+		t.Fatalf("the bump should reach every peer, got %v", p.reqs)
+	}
+
+	// bumps from peers may come twice or late
+	if node.AdvanceGeneration(1) || !node.AdvanceGeneration(5) || node.AdvanceGeneration(3) {
+		t.Fatal("only a higher generation should count")
+	}
+	if node.Generation() != 5 {
+		t.Fatalf("want generation 5, got %d", node.Generation())
+	}
+}
+
+func TestNode_BumpWhileLoading(t *testing.T) {
+	var node *Node
+	node = NewNode("scores", 0, func(key string) ([]byte, error) {
+		// the source changes under the load
+		node.AdvanceGeneration(node.Generation() + 1)
+		return []byte("old"), nil
+	})
+
+	if v, err := node.Get("a"); err != nil || v.String() != "old" {
+		t.Fatalf("the load should still answer, got %v %v", v, err)
+	}
+	if _, ok := node.Peek("a"); ok {
+		t.Fatal("a value loaded across a bump should not be cached")
+	}
+}
@@ -1,19 +1,37 @@
 package cache
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"sort"
 	"sync"
+	"time"
 
+	"github.com/golrice/e-fis/internal/bus"
 	"github.com/golrice/e-fis/internal/cache/flowcontrol"
+	"github.com/golrice/e-fis/internal/hotkey"
+	"github.com/golrice/e-fis/internal/mrc"
 	"github.com/golrice/e-fis/internal/peer"
 	pb "github.com/golrice/e-fis/internal/protocal"
+	"github.com/golrice/e-fis/internal/stats"
+	"github.com/golrice/e-fis/internal/watch"
 )
 
+// getters wrap it when the key does not exist at all, so that front-ends can tell it from a failure
+var ErrNotFound = errors.New("not found")
+
+var ErrNodeExists = errors.New("node already exists")
+
 // we define a namespace
 type Graph struct {
 	mu      sync.RWMutex
 	records map[string]*Node
+	// every node publishes its invalidations there, see Subscribe
+	bus *bus.Bus
+	// and the changes of its cache there, see Notify
+	hub *watch.Hub
 }
 
 func DefaultGraph() *Graph {
@@ -28,6 +46,45 @@ func (g *Graph) AddNode(node *Node) {
 	defer g.mu.Unlock()
 
 	g.records[node.name] = node
+	if g.bus != nil {
+		node.setBus(g.bus)
+	}
+	if g.hub != nil {
+		node.setHub(g.hub)
+	}
+}
+
+// add the node unless the name is taken
+func (g *Graph) TryAddNode(node *Node) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if _, ok := g.records[node.name]; ok {
+		return ErrNodeExists
+	}
+	g.records[node.name] = node
+	if g.bus != nil {
+		node.setBus(g.bus)
+	}
+	if g.hub != nil {
+		node.setHub(g.hub)
+	}
+
+	return nil
+}
+
+// all nodes, sorted by name
+func (g *Graph) Nodes() []*Node {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	nodes := make([]*Node, 0, len(g.records))
+	for _, node := range g.records {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].name < nodes[j].name })
+
+	return nodes
 }
 
 type Getter interface {
@@ -41,29 +98,69 @@ func (f GetterLikeFunc) Get(key string) ([]byte, error) {
 	return f(key)
 }
 
+// the hot keys listed in the stats, the admin api lists more
+const statsHotKeys = 5
+
 // define a basic node
 type Node struct {
 	name          string
+	policy        string
+	capacity      int64
 	cache         *cache
 	getter        Getter
 	peers         peer.PeerPicker
 	flowcontroler *flowcontrol.Controler
+	stats         *stats.Registry
+	// a sampled ghost of the reads, it tells how the hit ratio would change with the capacity
+	mrc *mrc.Estimator
+	// the heaviest keys lately
+	hot *hotkey.Tracker
+
+	mu         sync.Mutex
+	hedge      *HedgeOptions
+	latencies  map[any]*latencyWindow
+	spreadOpts *SpreadOptions
+	// the hot keys we own which are copied to more members
+	spread    map[string]spreadKey
+	spreadSeq uint64
+	bus       *bus.Bus
 }
 
 func NewNode(name string, capacity int64, getter GetterLikeFunc) *Node {
+	node, err := NewNodeWithPolicy(name, capacity, "lru", getter)
+	if err != nil {
+		panic(err)
+	}
+
+	return node
+}
+
+// like NewNode, evicting with one of Policies
+func NewNodeWithPolicy(name string, capacity int64, policy string, getter GetterLikeFunc) (*Node, error) {
 	if getter == nil {
-		panic("need a good getter")
+		return nil, errors.New("need a good getter")
+	}
+	if !validPolicy(policy) {
+		return nil, fmt.Errorf("unknown policy %s", policy)
 	}
 
 	node := &Node{
 		name:          name,
-		cache:         NewCache(capacity, "lru"),
+		policy:        policy,
+		capacity:      capacity,
+		cache:         NewCache(capacity, policy),
 		getter:        getter,
 		peers:         nil,
 		flowcontroler: &flowcontrol.Controler{},
+		stats:         stats.New(),
+		mrc:           mrc.New(mrc.Options{}),
+		hot:           hotkey.New(hotkey.Options{}),
 	}
+	node.stats.Gauge("mrc", node.missRatioCurve)
+	node.stats.Gauge("hot_keys", func() any { return node.hot.Top(statsHotKeys).ByRequests })
+	node.stats.Gauge("generation", func() any { return node.Generation() })
 
-	return node
+	return node, nil
 }
 
 func GetNode(graph *Graph, name string) (*Node, error) {
@@ -77,6 +174,24 @@ func GetNode(graph *Graph, name string) (*Node, error) {
 	return nil, fmt.Errorf("no such a node")
 }
 
+func (n *Node) Name() string {
+	return n.name
+}
+
+func (n *Node) Policy() string {
+	return n.policy
+}
+
+// the capacity of the local cache in bytes, 0 is unlimited
+func (n *Node) Capacity() int64 {
+	return n.capacity
+}
+
+// counters of this node, e.g. hits and where misses were loaded from
+func (n *Node) Stats() map[string]any {
+	return n.stats.Snapshot()
+}
+
 func (n *Node) RegisterPeers(peers peer.PeerPicker) {
 	if n.peers != nil {
 		panic("RegisterPeerPicker called more than once")
@@ -84,55 +199,154 @@ func (n *Node) RegisterPeers(peers peer.PeerPicker) {
 	n.peers = peers
 }
 
+// where a read was answered from
+const (
+	SourceCache = "hit"
+	SourcePeer  = "peer"
+	SourceLocal = "local"
+)
+
 func (n *Node) Get(key string) (ByteView, error) {
+	v, _, err := n.Lookup(key)
+	return v, err
+}
+
+// lookup is Get, it also tells where the value came from, one of the Source constants
+func (n *Node) Lookup(key string) (ByteView, string, error) {
 	if key == "" {
-		return NewByteView(nil), nil
+		return NewByteView(nil), SourceCache, nil
 	}
 
+	n.stats.Inc("gets")
 	if v, ok := n.cache.get(key); ok {
-		return v, nil
+		n.stats.Inc("hits")
+		n.observe(key, v, SourceCache)
+		return v, SourceCache, nil
 	}
 
 	// cache miss, fix it
-	return n.load(key)
+	v, source, err := n.load(key)
+	if err == nil {
+		n.observe(key, v, source)
+	}
+	return v, source, err
+}
+
+// every successful read feeds the miss ratio estimate and the hot keys
+func (n *Node) observe(key string, v ByteView, source string) {
+	n.mrc.Access(key, int64(len(key)+v.Len()))
+	n.hot.Add(key, v.Len())
+	// only the owner spreads a key, it is the one which serves it
+	if source != SourcePeer {
+		n.checkSpread(key)
+	}
+}
+
+// the n keys read most on this server lately, by requests and by bytes
+func (n *Node) HotKeys(top int) hotkey.Report {
+	return n.hot.Top(top)
+}
+
+// the lru hit ratio estimated for this node at another capacity in bytes
+func (n *Node) EstimateHitRatio(capacity int64) float64 {
+	return n.mrc.HitRatio(capacity)
+}
+
+// the estimate around the current capacity, or around the working set if it is unlimited
+func (n *Node) missRatioCurve() any {
+	rate, reads := n.mrc.Sampling()
+	workingSet := n.mrc.WorkingSet()
+
+	base := n.capacity
+	if base <= 0 {
+		base = workingSet
+	}
+	var capacities []int64
+	for _, f := range []float64{0.125, 0.25, 0.5, 1, 2, 4, 8} {
+		if c := int64(float64(base) * f); c > 0 {
+			capacities = append(capacities, c)
+		}
+	}
+
+	return map[string]any{
+		"sample_rate":   rate,
+		"sampled_reads": reads,
+		"working_set":   workingSet,
+		"hit_ratio":     n.mrc.Curve(capacities),
+	}
+}
+
+// like Get, it gives up when ctx is done, the load goes on for other callers
+func (n *Node) GetContext(ctx context.Context, key string) (ByteView, error) {
+	v, _, err := n.LookupContext(ctx, key)
+	return v, err
 }
 
-func (n *Node) load(key string) (value ByteView, err error) {
+// like Lookup, it gives up when ctx is done
+func (n *Node) LookupContext(ctx context.Context, key string) (ByteView, string, error) {
+	type result struct {
+		v      ByteView
+		source string
+		err    error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		v, source, err := n.Lookup(key)
+		done <- result{v, source, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.v, r.source, r.err
+	case <-ctx.Done():
+		return ByteView{}, "", ctx.Err()
+	}
+}
+
+func (n *Node) load(key string) (ByteView, string, error) {
+	type loaded struct {
+		v      ByteView
+		source string
+	}
+
 	// we load data from local or remote, it depends.
 	v, err := n.flowcontroler.Do(key, func() (any, error) {
 		if n.peers != nil {
-			if peer, ok := n.peers.PickPeer(key); ok {
-				if value, err = n.getFromPeer(peer, key); err == nil {
-					return value, nil
+			if peer, ok := n.pickReader(key); ok {
+				value, err := n.getFromPeer(peer, key)
+				if err == nil {
+					n.stats.Inc("peer_loads")
+					return loaded{value, SourcePeer}, nil
 				}
+				n.stats.Inc("peer_errors")
 				log.Println("[Cache] Failed to get from peer", err)
 			}
 		}
 
-		return n.loadLocally(key)
+		value, err := n.loadLocally(key)
+		return loaded{value, SourceLocal}, err
 	})
 
-	if err == nil {
-		return v.(ByteView), err
+	if err != nil {
+		return ByteView{}, "", err
 	}
 
-	return
+	l := v.(loaded)
+	return l.v, l.source, nil
 }
 
 func (n *Node) getFromPeer(peer peer.PeerGetter, key string) (ByteView, error) {
-	req := &pb.Request{
-		NodeName: n.name,
-		Key:      key,
+	if opts := n.hedgeOptions(); opts != nil {
+		return n.hedgedGet(opts, peer, key)
 	}
-	resp := &pb.Response{}
-	err := peer.Get(req, resp)
-	if err != nil {
-		return ByteView{}, err
-	}
-	return ByteView{b: resp.Value}, nil
+
+	return n.fetch(context.Background(), peer, key)
 }
 
 func (n *Node) loadLocally(key string) (ByteView, error) {
+	n.stats.Inc("local_loads")
+	gen := n.Generation()
 	vb, err := n.getter.Get(key)
 
 	if err != nil {
@@ -141,12 +355,105 @@ func (n *Node) loadLocally(key string) (ByteView, error) {
 
 	bv := ByteView{b: cloneBytes(vb)}
 
-	// add new item in cache
-	n.addCache(key, bv)
+	// a bump while we loaded may mean the source changed, the value is not cached then
+	if n.Generation() == gen {
+		n.addCache(key, bv)
+	}
 
 	return bv, nil
 }
 
-func (n *Node) addCache(key string, value ByteView) {
-	n.cache.add(key, value)
+func (n *Node) addCache(key string, value ByteView, tags ...string) {
+	n.cache.add(key, value, tags...)
+}
+
+// the keys cached on this server, no peer is asked
+// keys of an older generation are listed until they are read or evicted
+func (n *Node) Keys() []string {
+	return n.cache.keys()
+}
+
+// peek looks into the local cache only, it never loads
+func (n *Node) Peek(key string) (ByteView, bool) {
+	return n.cache.get(key)
+}
+
+// store a value in the local cache, e.g. when a peer hands it over
+func (n *Node) SetLocal(key string, value []byte) {
+	n.SetLocalUntil(key, value, time.Time{})
+}
+
+// like SetLocal, the value is gone after expire, a zero expire never expires
+// the copies are dropped after the value is stored, a copy pushed in between has the new value
+func (n *Node) SetLocalUntil(key string, value []byte, expire time.Time, tags ...string) {
+	n.addCache(key, ByteView{b: cloneBytes(value), expire: expire}, tags...)
+	n.invalidateCopies(key)
+}
+
+// set stores the value at the owner of the key, a ttl <= 0 never expires
+// it is stored here if we own the key or the breaker of the owner is open, an error of the owner is returned
+// the tags let InvalidateTag drop the key together with others
+// copies at other members are dropped through the bus, see Graph.Subscribe
+func (n *Node) Set(key string, value []byte, ttl time.Duration, tags ...string) error {
+	var expire time.Time
+	if ttl > 0 {
+		expire = time.Now().Add(ttl)
+	}
+
+	n.stats.Inc("sets")
+	if n.peers != nil {
+		if p, ok := n.pickOwner(key); ok {
+			if setter, ok := p.(peer.PeerSetter); ok {
+				// a copy from an earlier local load would be stale now
+				n.Remove(key)
+				err := setter.Set(&pb.SetRequest{
+					NodeName: n.name,
+					Entries:  []*pb.Entry{{Key: key, Value: value, Expire: ExpireToNano(expire), Tags: tags}},
+				})
+				if err != nil {
+					return err
+				}
+				n.publish(bus.KindKey, key, 0)
+				return nil
+			}
+		}
+	}
+
+	n.SetLocalUntil(key, value, expire, tags...)
+	n.publish(bus.KindKey, key, 0)
+
+	return nil
+}
+
+// delete drops the key at its owner and here, the bus drops it everywhere else
+func (n *Node) Delete(key string) error {
+	n.stats.Inc("deletes")
+	n.Remove(key)
+
+	if n.peers != nil {
+		if p, ok := n.pickOwner(key); ok {
+			if deleter, ok := p.(peer.PeerDeleter); ok {
+				if err := deleter.Delete(&pb.Request{NodeName: n.name, Key: key}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	n.publish(bus.KindKey, key, 0)
+
+	return nil
+}
+
+// drop a key from the local cache
+func (n *Node) Remove(key string) {
+	n.cache.delete(key)
+	n.mrc.Remove(key)
+	n.invalidateCopies(key)
+}
+
+// drop a key which moved to another member, it still exists there, so watchers are not told
+func (n *Node) Drop(key string) {
+	n.cache.drop(key)
+	n.mrc.Remove(key)
+	n.invalidateCopies(key)
 }
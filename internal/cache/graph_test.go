@@ -1,8 +1,13 @@
 package cache
 
 import (
+	"errors"
 	"fmt"
 	"testing"
+	"time"
+
+	"github.com/golrice/e-fis/internal/peer"
+	pb "github.com/golrice/e-fis/internal/protocal"
 )
 
 func TestNode_New(t *testing.T) {
@@ -65,3 +70,115 @@ func TestGraph_Get(t *testing.T) {
 		t.Fatal("it does not cause error when get a Not Exists item")
 	}
 }
+
+func TestNode_SetTTL(t *testing.T) {
+	node := NewNode("kv", 2<<10, func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+	})
+
+	if err := node.Set("a", []byte("1"), 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	node.Set("b", []byte("2"), 0)
+
+	v, err := node.Get("a")
+	if err != nil || v.String() != "1" || v.Expire().IsZero() {
+		t.Fatalf("got %q, %v, expire %v", v.String(), err, v.Expire())
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, err := node.Get("a"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("an expired key should be gone, got %v", err)
+	}
+	if v, err := node.Get("b"); err != nil || !v.Expire().IsZero() {
+		t.Fatalf("b should never expire, got %v, %v", v.Expire(), err)
+	}
+
+	node.Delete("b")
+	if _, err := node.Get("b"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("a deleted key should be gone, got %v", err)
+	}
+}
+
+// a peer which owns every key
+type ownerPeer struct {
+	sets    []*pb.SetRequest
+	deletes []string
+}
+
+func (p *ownerPeer) PickPeer(key string) (peer.PeerGetter, bool) { return p, true }
+func (p *ownerPeer) Get(in *pb.Request, out *pb.Response) error  { return ErrNotFound }
+func (p *ownerPeer) Set(in *pb.SetRequest) error {
+	p.sets = append(p.sets, in)
+	return nil
+}
+func (p *ownerPeer) Delete(in *pb.Request) error {
+	p.deletes = append(p.deletes, in.Key)
+	return nil
+}
+
+func TestNode_SetRoutesToOwner(t *testing.T) {
+	owner := &ownerPeer{}
+	node := NewNode("kv", 2<<10, func(key string) ([]byte, error) {
+		return []byte("local"), nil
+	})
+	node.RegisterPeers(owner)
+
+	node.SetLocal("a", []byte("stale"))
+	if err := node.Set("a", []byte("1"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if len(owner.sets) != 1 || owner.sets[0].Entries[0].Expire == 0 || string(owner.sets[0].Entries[0].Value) != "1" {
+		t.Fatalf("the owner should get the entry with its expire, got %v", owner.sets)
+	}
+	if _, ok := node.Peek("a"); ok {
+		t.Fatal("the stale local copy should be dropped")
+	}
+
+	node.Delete("a")
+	if len(owner.deletes) != 1 || owner.deletes[0] != "a" {
+		t.Fatalf("the owner should get the delete, got %v", owner.deletes)
+	}
+}
+
+func TestNode_EstimateHitRatio(t *testing.T) {
+	node := NewNode("mrc", 0, func(key string) ([]byte, error) {
+		return []byte("value"), nil
+	})
+
+	// a loop over 2000 keys, every read after the first round hits once they all fit
+	for round := 0; round < 5; round += 1 {
+		for i := 0; i < 2000; i += 1 {
+			node.Get(fmt.Sprint("key", i))
+		}
+	}
+
+	if r := node.EstimateHitRatio(1 << 20); r < 0.7 || r > 0.9 {
+		t.Fatalf("want about 0.8 with room for every key, got %v", r)
+	}
+	if r := node.EstimateHitRatio(1 << 10); r > 0.1 {
+		t.Fatalf("want about 0 with room for a few keys, got %v", r)
+	}
+	if _, ok := node.Stats()["mrc"].(map[string]any); !ok {
+		t.Fatal("the estimate should be part of the stats")
+	}
+}
+
+func TestNode_HotKeys(t *testing.T) {
+	node := NewNode("hot", 0, func(key string) ([]byte, error) {
+		return []byte(key), nil
+	})
+
+	for i := 0; i < 100; i += 1 {
+		node.Get("hot")
+		node.Get(fmt.Sprint("cold", i))
+	}
+
+	top := node.HotKeys(1)
+	if len(top.ByRequests) != 1 || top.ByRequests[0].Key != "hot" {
+		t.Fatalf("want hot on top, got %+v", top.ByRequests)
+	}
+	if _, ok := node.Stats()["hot_keys"]; !ok {
+		t.Fatal("the hot keys should be part of the stats")
+	}
+}
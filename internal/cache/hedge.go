@@ -0,0 +1,158 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/golrice/e-fis/internal/peer"
+	pb "github.com/golrice/e-fis/internal/protocal"
+)
+
+const defaultHedgeDelay = 50 * time.Millisecond
+
+type HedgeOptions struct {
+	// send the hedge when the first peer did not answer after this delay
+	Delay time.Duration
+	// wait for the observed p95 of the peer instead, Delay is used until it is known
+	Adaptive bool
+}
+
+// after a delay, ask another member which holds the key, or our getter, as well and take the first answer
+func (n *Node) EnableHedging(opts HedgeOptions) {
+	if opts.Delay <= 0 {
+		opts.Delay = defaultHedgeDelay
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.hedge = &opts
+}
+
+func (n *Node) hedgeOptions() *HedgeOptions {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.hedge
+}
+
+// the window of a named peer outlives its getter, the getters are rebuilt on every change of
+// the members or of the options
+func (n *Node) latencyOf(p peer.PeerGetter) *latencyWindow {
+	var id any = p
+	if named, ok := p.(peer.Named); ok {
+		id = named.Name()
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.latencies == nil {
+		n.latencies = map[any]*latencyWindow{}
+	}
+	w, ok := n.latencies[id]
+	if !ok {
+		w = &latencyWindow{}
+		n.latencies[id] = w
+	}
+
+	return w
+}
+
+func (n *Node) hedgeDelay(opts *HedgeOptions, p peer.PeerGetter) time.Duration {
+	if opts.Adaptive {
+		if d, ok := n.latencyOf(p).percentile(0.95); ok {
+			return d
+		}
+	}
+
+	return opts.Delay
+}
+
+// fetch asks a single peer, and remembers how long it took
+func (n *Node) fetch(ctx context.Context, p peer.PeerGetter, key string) (ByteView, error) {
+	req := &pb.Request{
+		NodeName: n.name,
+		Key:      key,
+	}
+	resp := &pb.Response{}
+
+	start := time.Now()
+	var err error
+	if cg, ok := p.(peer.ContextGetter); ok {
+		err = cg.GetContext(ctx, req, resp)
+	} else {
+		err = p.Get(req, resp)
+	}
+	if err != nil {
+		return ByteView{}, err
+	}
+	n.latencyOf(p).add(time.Since(start))
+	// the member tells whether the key is spread, so that our next reads are spread too
+	if sp, ok := n.peers.(peer.Spreader); ok {
+		sp.Spread(n.name, key, int(resp.Copies), ExpireFromNano(resp.SpreadUntil))
+	}
+
+	return ByteView{b: resp.Value, expire: ExpireFromNano(resp.Expire)}, nil
+}
+
+type hedgeResult struct {
+	value ByteView
+	err   error
+	hedge bool
+}
+
+func (n *Node) hedgedGet(opts *HedgeOptions, primary peer.PeerGetter, key string) (ByteView, error) {
+	// the loser is canceled when we return
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	results := make(chan hedgeResult, 2)
+	go func() {
+		v, err := n.fetch(ctx, primary, key)
+		results <- hedgeResult{value: v, err: err}
+	}()
+
+	timer := time.NewTimer(n.hedgeDelay(opts, primary))
+	defer timer.Stop()
+	hedgeC := timer.C
+
+	pending := 1
+	for {
+		select {
+		case <-hedgeC:
+			hedgeC = nil
+			pending += 1
+			n.stats.Inc("hedged_requests")
+
+			go func() {
+				var v ByteView
+				var err error
+				// the owner, unless it is the slow one, or a member holding a copy
+				if rp, ok := n.peers.(peer.ReplicaPicker); ok {
+					for _, second := range rp.PickReplicas(n.name, key) {
+						if second != primary {
+							v, err = n.fetch(ctx, second, key)
+							results <- hedgeResult{value: v, err: err, hedge: true}
+							return
+						}
+					}
+				}
+				v, err = n.loadLocally(key)
+				results <- hedgeResult{value: v, err: err, hedge: true}
+			}()
+		case r := <-results:
+			pending -= 1
+			if r.err == nil {
+				if r.hedge {
+					n.stats.Inc("hedge_wins")
+				}
+				return r.value, nil
+			}
+			// the first peer failed before we hedged, the caller falls back on its own
+			if pending == 0 {
+				return ByteView{}, r.err
+			}
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package cache
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golrice/e-fis/internal/peer"
+	pb "github.com/golrice/e-fis/internal/protocal"
+)
+
+// a peer which answers after a delay, unless it is canceled
+type slowPeer struct {
+	value    string
+	delay    time.Duration
+	calls    atomic.Int32
+	canceled atomic.Int32
+}
+
+func (p *slowPeer) Get(in *pb.Request, out *pb.Response) error {
+	return p.GetContext(context.Background(), in, out)
+}
+
+func (p *slowPeer) GetContext(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	p.calls.Add(1)
+	select {
+	case <-time.After(p.delay):
+		out.Value = []byte(p.value)
+		return nil
+	case <-ctx.Done():
+		p.canceled.Add(1)
+		return ctx.Err()
+	}
+}
+
+// picks the primary first, the holders are the owner and the members with a copy
+type replicaPicker struct {
+	primary peer.PeerGetter
+	holders []peer.PeerGetter
+}
+
+func (r *replicaPicker) PickPeer(key string) (peer.PeerGetter, bool) {
+	return r.primary, true
+}
+
+func (r *replicaPicker) PickReplicas(namespace, key string) []peer.PeerGetter {
+	return r.holders
+}
+
+func newHedgedNode(picker peer.PeerPicker, delay time.Duration) *Node {
+	node := NewNode("scores", 2<<10, func(key string) ([]byte, error) {
+		return []byte("local"), nil
+	})
+	node.RegisterPeers(picker)
+	node.EnableHedging(HedgeOptions{Delay: delay})
+
+	return node
+}
+
+func TestNode_HedgeToReplica(t *testing.T) {
+	primary := &slowPeer{value: "primary", delay: time.Second}
+	replica := &slowPeer{value: "replica"}
+	node := newHedgedNode(&replicaPicker{primary: primary, holders: []peer.PeerGetter{primary, replica}}, 10*time.Millisecond)
+
+	v, err := node.Get("Tom")
+	if err != nil || v.String() != "replica" {
+		t.Fatalf("we want the replica to win, got %q, %v", v.String(), err)
+	}
+
+	// the slow request is canceled once the hedge won
+	deadline := time.Now().Add(time.Second)
+	for primary.canceled.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if primary.canceled.Load() != 1 {
+		t.Fatal("the losing request should be canceled")
+	}
+
+	// the slow owner is asked only once, the hedge went to the copy
+	if primary.calls.Load() != 1 || replica.calls.Load() != 1 {
+		t.Fatalf("we want one call each, got %d and %d", primary.calls.Load(), replica.calls.Load())
+	}
+
+	stats := node.Stats()
+	if stats["hedged_requests"] != int64(1) || stats["hedge_wins"] != int64(1) || stats["peer_loads"] != int64(1) {
+		t.Fatalf("unexpected stats %v", stats)
+	}
+}
+
+func TestNode_HedgeFromCopyToOwner(t *testing.T) {
+	holder := &slowPeer{value: "copy", delay: time.Second}
+	owner := &slowPeer{value: "owner"}
+	node := newHedgedNode(&replicaPicker{primary: holder, holders: []peer.PeerGetter{holder, owner}}, 10*time.Millisecond)
+
+	// the slow one held a copy, the owner answers the hedge
+	if v, err := node.Get("Tom"); err != nil || v.String() != "owner" {
+		t.Fatalf("we want the owner to win, got %q, %v", v.String(), err)
+	}
+	if holder.calls.Load() != 1 {
+		t.Fatalf("we want one call to the copy, got %d", holder.calls.Load())
+	}
+}
+
+func TestNode_HedgeToGetter(t *testing.T) {
+	primary := &slowPeer{value: "primary", delay: time.Second}
+	node := newHedgedNode(&replicaPicker{primary: primary, holders: []peer.PeerGetter{primary}}, 10*time.Millisecond)
+
+	// the key is not spread, only the slow owner holds it and the local getter is the hedge
+	if v, err := node.Get("Tom"); err != nil || v.String() != "local" {
+		t.Fatalf("we want the local getter to win, got %q, %v", v.String(), err)
+	}
+}
+
+func TestNode_NoHedgeWhenFast(t *testing.T) {
+	primary := &slowPeer{value: "primary"}
+	replica := &slowPeer{value: "replica"}
+	node := newHedgedNode(&replicaPicker{primary: primary, holders: []peer.PeerGetter{primary, replica}}, time.Second)
+
+	if v, err := node.Get("Tom"); err != nil || v.String() != "primary" {
+		t.Fatalf("we want the primary, got %q, %v", v.String(), err)
+	}
+	if replica.calls.Load() != 0 || node.Stats()["hedged_requests"] != nil {
+		t.Fatal("a fast answer should not be hedged")
+	}
+}
+
+func TestLatencyWindow_Percentile(t *testing.T) {
+	w := &latencyWindow{}
+	for i := 1; i < latencyMinSamples; i += 1 {
+		w.add(time.Duration(i) * time.Millisecond)
+	}
+	if _, ok := w.percentile(0.95); ok {
+		t.Fatal("not enough samples yet")
+	}
+
+	w = &latencyWindow{}
+	for i := 1; i <= 100; i += 1 {
+		w.add(time.Duration(i) * time.Millisecond)
+	}
+	if d, ok := w.percentile(0.95); !ok || d != 95*time.Millisecond {
+		t.Fatalf("we want 95ms, got %v", d)
+	}
+
+	// old samples fall out of the window
+	for i := 0; i < latencySamples; i += 1 {
+		w.add(time.Millisecond)
+	}
+	if d, _ := w.percentile(0.95); d != time.Millisecond {
+		t.Fatalf("we want 1ms, got %v", d)
+	}
+}
+
+func TestNode_LatencyOfNamedPeer(t *testing.T) {
+	node := newHedgedNode(&replicaPicker{}, time.Second)
+	// the getter of a member is rebuilt when the options change
+	for i := 0; i < 10; i += 1 {
+		node.latencyOf(peer.NewHttpGetter("http://a/efis/", peer.HttpGetterOptions{})).add(time.Millisecond)
+	}
+	if len(node.latencies) != 1 {
+		t.Fatalf("we want one window per member, got %d", len(node.latencies))
+	}
+}
@@ -0,0 +1,48 @@
+package cache
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	latencySamples    = 128
+	latencyMinSamples = 20
+)
+
+// the latencies of the last calls to a peer
+type latencyWindow struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+func (w *latencyWindow) add(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.samples) < latencySamples {
+		w.samples = append(w.samples, d)
+		return
+	}
+	w.samples[w.next] = d
+	w.next = (w.next + 1) % latencySamples
+}
+
+// ok is false until we have seen enough calls
+func (w *latencyWindow) percentile(p float64) (time.Duration, bool) {
+	w.mu.Lock()
+	sorted := append([]time.Duration(nil), w.samples...)
+	w.mu.Unlock()
+
+	if len(sorted) < latencyMinSamples {
+		return 0, false
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	idx := int(p*float64(len(sorted))+0.5) - 1
+	idx = max(0, min(idx, len(sorted)-1))
+
+	return sorted[idx], true
+}
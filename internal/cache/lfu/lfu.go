@@ -88,7 +88,7 @@ func (c *LfuCache) Add(key string, value basic.Value) {
 	}
 }
 
-func (c *LfuCache) Delete(key string) {
+func (c *LfuCache) Delete(key string) (ok bool) {
 	e, ok := c.Cache[key]
 	if !ok {
 		return
@@ -99,6 +99,8 @@ func (c *LfuCache) Delete(key string) {
 	c.Mem.UsedBytes -= int64(len(key)) + int64(v.value.Len())
 	delete(c.Cache, key)
 	c.Bl.Remove(e)
+
+	return true
 }
 
 func (c *LfuCache) Update(key string, value basic.Value) (ok bool) {
@@ -123,3 +125,11 @@ func (c *LfuCache) Update(key string, value basic.Value) (ok bool) {
 func (c *LfuCache) Len() int {
 	return c.Bl.Len()
 }
+
+func (c *LfuCache) Keys() []string {
+	keys := make([]string, 0, c.Bl.Len())
+	for e := c.Bl.Front(); e != nil; e = e.Next() {
+		keys = append(keys, e.Value.(*entry).key)
+	}
+	return keys
+}
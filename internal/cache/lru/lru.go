@@ -81,7 +81,7 @@ func (c *LruCache) Add(key string, value basic.Value) {
 	}
 }
 
-func (c *LruCache) Delete(key string) {
+func (c *LruCache) Delete(key string) (ok bool) {
 	// check whether the kv is in cache
 	e, ok := c.Cache[key]
 	if !ok {
@@ -93,6 +93,8 @@ func (c *LruCache) Delete(key string) {
 	c.Mem.UsedBytes -= int64(len(key)) + int64(v.value.Len())
 	delete(c.Cache, key)
 	c.Bl.Remove(e)
+
+	return true
 }
 
 func (c *LruCache) Update(key string, value basic.Value) (ok bool) {
@@ -117,3 +119,11 @@ func (c *LruCache) Update(key string, value basic.Value) (ok bool) {
 func (c *LruCache) Len() int {
 	return c.Bl.Len()
 }
+
+func (c *LruCache) Keys() []string {
+	keys := make([]string, 0, c.Bl.Len())
+	for e := c.Bl.Front(); e != nil; e = e.Next() {
+		keys = append(keys, e.Value.(*entry).key)
+	}
+	return keys
+}
@@ -66,3 +66,15 @@ func TestLru_OnRemove(t *testing.T) {
 		t.Fatalf("Call OnEvicted failed, expect keys equals to %s, but we get %s", expect, keys)
 	}
 }
+
+func TestLru_Keys(t *testing.T) {
+	cache := New(int64(0), nil)
+	cache.Add("k1", String("v1"))
+	cache.Add("k2", String("v2"))
+	cache.Get("k1")
+
+	// most recently used first
+	if keys := cache.Keys(); !reflect.DeepEqual(keys, []string{"k1", "k2"}) {
+		t.Fatalf("we want [k1 k2], got %v", keys)
+	}
+}
@@ -0,0 +1,235 @@
+package cache
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/golrice/e-fis/internal/peer"
+	pb "github.com/golrice/e-fis/internal/protocal"
+)
+
+const (
+	defaultSpreadThreshold = 100
+	defaultSpreadCopies    = 2
+	defaultSpreadLease     = 30 * time.Second
+)
+
+type SpreadOptions struct {
+	// a key is spread once its owner serves more requests per second, it is demoted below half of it
+	Threshold float64
+	// the members after the owner which get a copy
+	Copies int
+	// the copies expire unless the owner renews them, it does so every half lease while the key is hot
+	Lease time.Duration
+}
+
+type spreadKey struct {
+	// 0 if we do not own the key, it is looked at again after renew
+	copies int
+	renew  time.Time
+	// tells a promotion apart from a later one, a write deletes the entry in between
+	seq uint64
+	// closed once the copies were pushed, they are dropped after that, nil before the first push
+	pushed chan struct{}
+}
+
+// the owner of a hot key copies it to the next members on the ring, the peers must be a peer.Spreader
+// a write to the key drops every copy, a key which cooled down is demoted the same way
+func (n *Node) EnableSpreading(opts SpreadOptions) {
+	if opts.Threshold <= 0 {
+		opts.Threshold = defaultSpreadThreshold
+	}
+	if opts.Copies <= 0 {
+		opts.Copies = defaultSpreadCopies
+	}
+	if opts.Lease <= 0 {
+		opts.Lease = defaultSpreadLease
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.spreadOpts = &opts
+	n.spread = map[string]spreadKey{}
+	n.stats.Gauge("spread_keys", func() any { return len(n.SpreadKeys()) })
+}
+
+func (n *Node) spreadOptions() *SpreadOptions {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.spreadOpts
+}
+
+// writes go to the owner, PickHolder may pick a member which only holds a copy
+func (n *Node) pickOwner(key string) (peer.PeerGetter, bool) {
+	if sp, ok := n.peers.(peer.Spreader); ok {
+		return sp.PickOwner(key)
+	}
+	return n.peers.PickPeer(key)
+}
+
+// reads of a spread key go to its owner or a member holding a copy
+func (n *Node) pickReader(key string) (peer.PeerGetter, bool) {
+	if sp, ok := n.peers.(peer.Spreader); ok {
+		return sp.PickHolder(n.name, key)
+	}
+	return n.peers.PickPeer(key)
+}
+
+// called for reads we served ourselves, it promotes, renews or demotes key
+//
+// a write stores the value and then drops the entry of the key, the copies are pushed only if
+// the entry is still there once they are counted in it, and with the value cached then. so a
+// write either comes before and its value is pushed, or it comes after and drops the copies,
+// which waits for the push
+func (n *Node) checkSpread(key string) {
+	opts := n.spreadOptions()
+	sp, ok := n.peers.(peer.Spreader)
+	if opts == nil || !ok {
+		return
+	}
+
+	var rate float64
+	if e, ok := n.hot.Requests(key); ok {
+		rate = e.Rate
+	}
+
+	now := time.Now()
+	n.mu.Lock()
+	s, spread := n.spread[key]
+	if spread && now.Before(s.renew) || !spread && rate < opts.Threshold {
+		n.mu.Unlock()
+		return
+	}
+	if spread && rate < opts.Threshold/2 {
+		delete(n.spread, key)
+		n.mu.Unlock()
+
+		if s.copies > 0 {
+			n.stats.Inc("spread_demotions")
+			n.dropCopies(sp, key, s.copies, s.pushed)
+		}
+		return
+	}
+	// other readers of the key leave it to us until the next renewal, a write until then
+	// still drops the copies of the last push
+	n.spreadSeq += 1
+	seq := n.spreadSeq
+	n.spread[key] = spreadKey{copies: s.copies, renew: now.Add(opts.Lease / 2), seq: seq, pushed: s.pushed}
+	n.mu.Unlock()
+
+	holders := sp.PickCopies(key, opts.Copies)
+	if len(holders) == 0 {
+		// someone else owns it, or we are alone
+		return
+	}
+
+	pushed := make(chan struct{})
+	n.mu.Lock()
+	if cur, ok := n.spread[key]; !ok || cur.seq != seq {
+		// written in the meantime, the next read spreads the key again
+		n.mu.Unlock()
+		return
+	}
+	n.spread[key] = spreadKey{copies: len(holders), renew: now.Add(opts.Lease / 2), seq: seq, pushed: pushed}
+	n.mu.Unlock()
+
+	v, ok := n.Peek(key)
+	if !ok {
+		// evicted or expired, a write drops the copies of the last push anyway
+		close(pushed)
+		return
+	}
+
+	until := now.Add(opts.Lease)
+	expire := until
+	if !v.expire.IsZero() && v.expire.Before(until) {
+		expire = v.expire
+	}
+	sp.Spread(n.name, key, len(holders), until)
+	if !spread || s.copies == 0 {
+		n.stats.Inc("spread_promotions")
+		log.Printf("[Cache] spread %s/%s to %d more members", n.name, key, len(holders))
+	}
+
+	in := &pb.SetRequest{
+		NodeName: n.name,
+		Entries:  []*pb.Entry{{Key: key, Value: v.ByteSlice(), Expire: ExpireToNano(expire), Tags: n.Tags(key)}},
+		Copies:   int32(len(holders)),
+	}
+	// the read does not wait, a member without its copy yet asks us
+	// a renewal goes out after the last push, so that the copies arrive in order
+	var wg sync.WaitGroup
+	for _, h := range holders {
+		if setter, ok := h.(peer.PeerSetter); ok {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if s.pushed != nil {
+					<-s.pushed
+				}
+				if err := setter.Set(in); err != nil {
+					n.stats.Inc("spread_errors")
+					log.Println("[Cache] Failed to copy to peer", err)
+				}
+			}()
+		}
+	}
+	go func() {
+		wg.Wait()
+		close(pushed)
+	}()
+}
+
+// a write makes every copy stale, the key is spread again if it stays hot
+func (n *Node) invalidateCopies(key string) {
+	n.mu.Lock()
+	s, ok := n.spread[key]
+	delete(n.spread, key)
+	n.mu.Unlock()
+
+	if !ok || s.copies == 0 {
+		return
+	}
+	if sp, ok := n.peers.(peer.Spreader); ok {
+		n.stats.Inc("spread_invalidations")
+		n.dropCopies(sp, key, s.copies, s.pushed)
+	}
+}
+
+// the copies we miss, e.g. after the ring changed, expire with their lease
+// a push still under way is waited for, otherwise its copy could land after the drop
+func (n *Node) dropCopies(sp peer.Spreader, key string, copies int, pushed chan struct{}) {
+	sp.Spread(n.name, key, 0, time.Time{})
+
+	in := &pb.Request{NodeName: n.name, Key: key}
+	for _, h := range sp.PickCopies(key, copies) {
+		if deleter, ok := h.(peer.PeerDeleter); ok {
+			go func() {
+				if pushed != nil {
+					<-pushed
+				}
+				if err := deleter.Delete(in); err != nil {
+					n.stats.Inc("spread_errors")
+					log.Println("[Cache] Failed to drop the copy at peer", err)
+				}
+			}()
+		}
+	}
+}
+
+// the spread keys we own and how many copies each has
+func (n *Node) SpreadKeys() map[string]int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	keys := make(map[string]int)
+	for key, s := range n.spread {
+		if s.copies > 0 {
+			keys[key] = s.copies
+		}
+	}
+	return keys
+}
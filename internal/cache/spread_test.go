@@ -0,0 +1,235 @@
+package cache
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golrice/e-fis/internal/peer"
+	pb "github.com/golrice/e-fis/internal/protocal"
+)
+
+// a member which gets copies pushed and dropped
+type copyHolder struct {
+	sets    chan *pb.SetRequest
+	deletes chan *pb.Request
+}
+
+func newCopyHolder() *copyHolder {
+	return &copyHolder{sets: make(chan *pb.SetRequest, 16), deletes: make(chan *pb.Request, 16)}
+}
+
+func (h *copyHolder) Get(in *pb.Request, out *pb.Response) error {
+	out.Value = []byte("copy")
+	out.Copies = 1
+	out.SpreadUntil = time.Now().Add(time.Minute).UnixNano()
+	return nil
+}
+
+func (h *copyHolder) Set(in *pb.SetRequest) error {
+	h.sets <- in
+	return nil
+}
+
+func (h *copyHolder) Delete(in *pb.Request) error {
+	h.deletes <- in
+	return nil
+}
+
+// we own every key unless remote is set, holder is the next member
+type fakeSpreader struct {
+	remote peer.PeerGetter
+	holder *copyHolder
+
+	mu    sync.Mutex
+	marks map[string]int
+}
+
+func (s *fakeSpreader) PickPeer(key string) (peer.PeerGetter, bool) {
+	return s.remote, s.remote != nil
+}
+
+func (s *fakeSpreader) PickOwner(key string) (peer.PeerGetter, bool) {
+	return s.remote, s.remote != nil
+}
+
+func (s *fakeSpreader) PickHolder(namespace, key string) (peer.PeerGetter, bool) {
+	return s.remote, s.remote != nil
+}
+
+func (s *fakeSpreader) PickCopies(key string, n int) []peer.PeerGetter {
+	if s.remote != nil {
+		return nil
+	}
+	return []peer.PeerGetter{s.holder}
+}
+
+func (s *fakeSpreader) Spread(namespace, key string, copies int, until time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.marks == nil {
+		s.marks = map[string]int{}
+	}
+	s.marks[namespace+"/"+key] = copies
+}
+
+func (s *fakeSpreader) Spreading(namespace, key string) (int, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.marks[namespace+"/"+key], time.Time{}
+}
+
+func newSpreadNode(sp peer.PeerPicker) *Node {
+	node := NewNode("scores", 2<<10, func(key string) ([]byte, error) {
+		return []byte("value"), nil
+	})
+	node.RegisterPeers(sp)
+	// a few reads a minute are hot enough
+	node.EnableSpreading(SpreadOptions{Threshold: 0.1, Copies: 1})
+
+	return node
+}
+
+func TestNode_SpreadHotKey(t *testing.T) {
+	sp := &fakeSpreader{holder: newCopyHolder()}
+	node := newSpreadNode(sp)
+
+	node.Get("cold")
+	for i := 0; i < 20; i += 1 {
+		node.Get("hot")
+	}
+
+	select {
+	case in := <-sp.holder.sets:
+		if in.Copies != 1 || len(in.Entries) != 1 || in.Entries[0].Key != "hot" || string(in.Entries[0].Value) != "value" {
+			t.Fatalf("unexpected copy %v", in)
+		}
+		if in.Entries[0].Expire == 0 {
+			t.Fatal("a copy should expire with its lease")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the hot key was not copied")
+	}
+	if copies, _ := sp.Spreading("scores", "hot"); copies != 1 {
+		t.Fatalf("want the key marked with 1 copy, got %d", copies)
+	}
+	if copies, _ := sp.Spreading("other", "hot"); copies != 0 {
+		t.Fatal("the key is hot in its namespace only")
+	}
+	if _, ok := node.SpreadKeys()["cold"]; ok {
+		t.Fatal("a cold key should not be spread")
+	}
+
+	// a write drops the copies
+	if err := node.Set("hot", []byte("new"), 0); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case in := <-sp.holder.deletes:
+		if in.Key != "hot" {
+			t.Fatalf("want hot dropped, got %s", in.Key)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the write did not drop the copy")
+	}
+	if copies, _ := sp.Spreading("scores", "hot"); copies != 0 {
+		t.Fatalf("want the mark gone after a write, got %d copies", copies)
+	}
+}
+
+func TestNode_SpreadLearnedFromPeer(t *testing.T) {
+	holder := newCopyHolder()
+	sp := &fakeSpreader{remote: holder, holder: holder}
+	node := newSpreadNode(sp)
+
+	for i := 0; i < 20; i += 1 {
+		if _, err := node.Get("hot"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if copies, _ := sp.Spreading("scores", "hot"); copies != 1 {
+		t.Fatalf("want the mark of the answer, got %d copies", copies)
+	}
+	// only the owner copies a key
+	if len(holder.sets) != 0 || len(node.SpreadKeys()) != 0 {
+		t.Fatal("a reader should not copy the key")
+	}
+}
+
+// a member which applies the copies and drops in the order they arrive, a copy takes a while
+type stateHolder struct {
+	mu    sync.Mutex
+	value map[string]string
+}
+
+func (h *stateHolder) Get(in *pb.Request, out *pb.Response) error {
+	return ErrNotFound
+}
+
+func (h *stateHolder) Set(in *pb.SetRequest) error {
+	time.Sleep(time.Duration(rand.Intn(1000)) * time.Microsecond)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, e := range in.Entries {
+		h.value[e.Key] = string(e.Value)
+	}
+	return nil
+}
+
+func (h *stateHolder) Delete(in *pb.Request) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.value, in.Key)
+	return nil
+}
+
+type stateSpreader struct {
+	fakeSpreader
+	holder *stateHolder
+}
+
+func (s *stateSpreader) PickCopies(key string, n int) []peer.PeerGetter {
+	return []peer.PeerGetter{s.holder}
+}
+
+// a copy pushed while the key is written must not outlive the write
+func TestNode_SpreadWhileWriting(t *testing.T) {
+	holder := &stateHolder{value: map[string]string{}}
+	node := newSpreadNode(&stateSpreader{holder: holder})
+
+	var wg sync.WaitGroup
+	var stop atomic.Bool
+	for r := 0; r < 4; r += 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !stop.Load() {
+				node.Get("hot")
+				runtime.Gosched()
+			}
+		}()
+	}
+	for i := 0; i < 200; i += 1 {
+		node.SetLocal("hot", []byte(fmt.Sprint("v", i)))
+		time.Sleep(50 * time.Microsecond)
+	}
+	stop.Store(true)
+	wg.Wait()
+
+	// the pushes and drops still under way
+	time.Sleep(100 * time.Millisecond)
+	holder.mu.Lock()
+	defer holder.mu.Unlock()
+	if v, ok := holder.value["hot"]; ok && v != "v199" {
+		t.Fatalf("the copy is stale, got %s", v)
+	}
+}
@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/golrice/e-fis/internal/bus"
+	"github.com/golrice/e-fis/internal/peer"
+	pb "github.com/golrice/e-fis/internal/protocal"
+)
+
+// the tags the key was stored with here
+func (n *Node) Tags(key string) []string {
+	return n.cache.tagsOf(key)
+}
+
+// drop every key with the tag from the local cache, it returns how many there were
+func (n *Node) RemoveTag(tag string) int {
+	keys := n.cache.removeTag(tag)
+	n.forget(keys)
+	return len(keys)
+}
+
+// drop every key which starts with prefix from the local cache, it returns how many there were
+func (n *Node) RemovePrefix(prefix string) int {
+	keys := n.cache.removePrefix(prefix)
+	n.forget(keys)
+	return len(keys)
+}
+
+func (n *Node) forget(keys []string) {
+	for _, key := range keys {
+		n.mrc.Remove(key)
+		n.invalidateCopies(key)
+	}
+}
+
+// drop every key with the tag here and at every peer
+func (n *Node) InvalidateTag(tag string) error {
+	if tag == "" {
+		return errors.New("empty tag")
+	}
+
+	n.stats.Inc("tag_invalidations")
+	n.RemoveTag(tag)
+	if n.publish(bus.KindTag, tag, 0) {
+		return nil
+	}
+
+	return n.broadcast(&pb.InvalidateRequest{NodeName: n.name, Tag: tag})
+}
+
+// drop every key which starts with prefix here and at every peer
+func (n *Node) InvalidatePrefix(prefix string) error {
+	if prefix == "" {
+		return errors.New("empty prefix")
+	}
+
+	n.stats.Inc("prefix_invalidations")
+	n.RemovePrefix(prefix)
+	if n.publish(bus.KindPrefix, prefix, 0) {
+		return nil
+	}
+
+	return n.broadcast(&pb.InvalidateRequest{NodeName: n.name, Prefix: prefix})
+}
+
+// a key may be cached anywhere, as a local load, a copy or a handoff, so every member is asked
+// a bump travels the same way, the bus replaces it when there is one, it retries for the peers
+// which are down
+// a member which is out of the ring right now keeps what it has
+func (n *Node) broadcast(in *pb.InvalidateRequest) error {
+	lister, ok := n.peers.(peer.PeerLister)
+	if !ok {
+		return nil
+	}
+
+	var mu sync.Mutex
+	var errs []error
+	var wg sync.WaitGroup
+	for _, p := range lister.PickAll() {
+		invalidator, ok := p.(peer.PeerInvalidator)
+		if !ok {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := invalidator.Invalidate(in); err != nil {
+				n.stats.Inc("invalidation_errors")
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("invalidate at peer: %w", err))
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
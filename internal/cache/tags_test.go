@@ -0,0 +1,77 @@
+package cache
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/golrice/e-fis/internal/peer"
+	pb "github.com/golrice/e-fis/internal/protocal"
+)
+
+// a member which only counts invalidations
+type invalidationPeer struct {
+	mu   sync.Mutex
+	reqs []*pb.InvalidateRequest
+}
+
+func (p *invalidationPeer) Get(in *pb.Request, out *pb.Response) error {
+	return ErrNotFound
+}
+
+func (p *invalidationPeer) Invalidate(in *pb.InvalidateRequest) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.reqs = append(p.reqs, in)
+	return nil
+}
+
+type listPicker struct {
+	peers []peer.PeerGetter
+}
+
+func (l *listPicker) PickPeer(key string) (peer.PeerGetter, bool) {
+	return nil, false
+}
+
+func (l *listPicker) PickAll() []peer.PeerGetter {
+	return l.peers
+}
+
+func TestNode_InvalidateFansOut(t *testing.T) {
+	a, b := &invalidationPeer{}, &invalidationPeer{}
+	node := NewNode("users", 0, func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	})
+	node.RegisterPeers(&listPicker{peers: []peer.PeerGetter{a, b}})
+
+	node.Set("user:1:name", []byte("ann"), 0, "user:1")
+	node.Set("user:1:mail", []byte("ann@example.com"), 0, "user:1", "mail")
+	node.Set("user:2:name", []byte("bob"), 0, "user:2")
+
+	if err := node.InvalidateTag("user:1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := node.Peek("user:1:mail"); ok {
+		t.Fatal("the tagged key should be gone here")
+	}
+	if _, ok := node.Peek("user:2:name"); !ok {
+		t.Fatal("another tag should stay")
+	}
+
+	if err := node.InvalidatePrefix("user:"); err != nil {
+		t.Fatal(err)
+	}
+	if len(node.Keys()) != 0 {
+		t.Fatalf("want every user key gone, got %v", node.Keys())
+	}
+
+	for _, p := range []*invalidationPeer{a, b} {
+		if len(p.reqs) != 2 || p.reqs[0].Tag != "user:1" || p.reqs[1].Prefix != "user:" || p.reqs[0].NodeName != "users" {
+			t.Fatalf("every peer should be asked, got %v", p.reqs)
+		}
+	}
+	if node.InvalidatePrefix("") == nil {
+		t.Fatal("an empty prefix would drop everything")
+	}
+}
@@ -0,0 +1,24 @@
+package cache
+
+import (
+	"github.com/golrice/e-fis/internal/watch"
+)
+
+// every change of the local caches is published to h: sets, deletes, evictions, a flush for a bump,
+// and expirations once the expired entry is read, a set tells when the value expires
+func (g *Graph) Notify(h *watch.Hub) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.hub != nil {
+		panic("Notify called more than once")
+	}
+	g.hub = h
+	for _, node := range g.records {
+		node.setHub(h)
+	}
+}
+
+func (n *Node) setHub(h *watch.Hub) {
+	n.cache.watcher.Store(&watcher{hub: h, namespace: n.name})
+}
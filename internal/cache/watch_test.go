@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golrice/e-fis/internal/watch"
+)
+
+func TestGraph_Notify(t *testing.T) {
+	hub := watch.New(watch.Options{})
+	graph := DefaultGraph()
+	graph.Notify(hub)
+	// c does not fit next to a and b
+	node := NewNode("users", 10, func(key string) ([]byte, error) {
+		return []byte("loaded"), nil
+	})
+	graph.AddNode(node)
+
+	s, err := hub.Subscribe(watch.Filter{Namespace: "users"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	node.Set("a", []byte("1"), 0)
+	node.Set("b", []byte("2"), time.Millisecond, "t")
+	node.Get("c")
+	// a was evicted, only c is still there to delete
+	node.Delete("a")
+	node.Delete("c")
+	time.Sleep(2 * time.Millisecond)
+	node.Peek("b")
+	node.Set("d", []byte("4"), 0, "t")
+	node.RemoveTag("t")
+	node.Bump()
+
+	want := []string{
+		"progress ", "set a", "set b", "set c", "evict a", "delete c",
+		"expire b", "set d", "delete d", "flush ",
+	}
+	for i, w := range want {
+		select {
+		case ev := <-s.C:
+			if got := string(ev.Kind) + " " + ev.Key; got != w {
+				t.Fatalf("event %d: got %q, want %q", i, got, w)
+			}
+			if ev.Kind == watch.KindSet && ev.Key == "b" && ev.Expire.IsZero() {
+				t.Fatal("a set should tell when the value expires")
+			}
+		default:
+			t.Fatalf("event %d: want %q, got nothing", i, w)
+		}
+	}
+}
+
+func TestNode_DropIsNotNotified(t *testing.T) {
+	hub := watch.New(watch.Options{})
+	graph := DefaultGraph()
+	graph.Notify(hub)
+	node := NewNode("users", 2<<10, func(key string) ([]byte, error) {
+		return []byte("loaded"), nil
+	})
+	graph.AddNode(node)
+
+	s, err := hub.Subscribe(watch.Filter{Namespace: "users"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// a moved to another member, b is deleted
+	node.SetLocal("a", []byte("1"))
+	node.SetLocal("b", []byte("2"))
+	node.Drop("a")
+	node.Remove("b")
+
+	if _, ok := node.Peek("a"); ok {
+		t.Fatal("a should be dropped")
+	}
+	for i, w := range []string{"progress ", "set a", "set b", "delete b"} {
+		select {
+		case ev := <-s.C:
+			if got := string(ev.Kind) + " " + ev.Key; got != w {
+				t.Fatalf("event %d: got %q, want %q", i, got, w)
+			}
+		default:
+			t.Fatalf("event %d: want %q, got nothing", i, w)
+		}
+	}
+}
@@ -51,7 +51,7 @@ func (m *DHTMap) Add(realNodes ...string) {
 
 // only get the real node name, not the value
 func (m *DHTMap) Get(key string) string {
-	if key == "" {
+	if key == "" || len(m.nodes) == 0 {
 		return ""
 	}
 
@@ -64,3 +64,47 @@ func (m *DHTMap) Get(key string) string {
 	// get the real node
 	return m.origins[m.nodes[idx%len(m.nodes)]]
 }
+
+// get the first n distinct real nodes clockwise from the key, the owner comes first
+func (m *DHTMap) GetN(key string, n int) []string {
+	if key == "" || len(m.nodes) == 0 || n <= 0 {
+		return nil
+	}
+
+	hash := m.hash([]byte(key))
+	idx := sort.SearchInts(m.nodes, int(hash))
+
+	seen := make(map[string]bool, n)
+	out := make([]string, 0, n)
+	for i := 0; i < len(m.nodes) && len(out) < n; i += 1 {
+		name := m.origins[m.nodes[(idx+i)%len(m.nodes)]]
+		if !seen[name] {
+			seen[name] = true
+			out = append(out, name)
+		}
+	}
+
+	return out
+}
+
+// the fraction of the hash space every real node owns
+func (m *DHTMap) Share() map[string]float64 {
+	share := make(map[string]float64)
+	if len(m.nodes) == 0 {
+		return share
+	}
+
+	const space = float64(1 << 32)
+	for i, id := range m.nodes {
+		// a virtual node owns the arc after the one before it, the first one wraps around
+		var arc float64
+		if i == 0 {
+			arc = float64(id) + space - float64(m.nodes[len(m.nodes)-1])
+		} else {
+			arc = float64(id - m.nodes[i-1])
+		}
+		share[m.origins[id]] += arc / space
+	}
+
+	return share
+}
@@ -1,6 +1,7 @@
 package consistenthash
 
 import (
+	"reflect"
 	"strconv"
 	"testing"
 )
@@ -53,3 +54,57 @@ func TestHashing(t *testing.T) {
 	}
 
 }
+
+func TestGetN(t *testing.T) {
+	hash := New(3, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+
+	// 2, 4, 6, 12, 14, 16, 22, 24, 26
+	hash.Add("6", "4", "2")
+
+	testCases := map[string][]string{
+		"11": {"2", "4", "6"},
+		"23": {"4", "6", "2"},
+		"27": {"2", "4", "6"},
+	}
+
+	for k, v := range testCases {
+		if got := hash.GetN(k, 3); !reflect.DeepEqual(got, v) {
+			t.Errorf("Asking for %s, should have yielded %v, got %v", k, v, got)
+		}
+		if got := hash.GetN(k, 1); got[0] != hash.Get(k) {
+			t.Errorf("the first of %s should be its owner", k)
+		}
+	}
+
+	// never more than the real nodes
+	if got := hash.GetN("11", 10); len(got) != 3 {
+		t.Errorf("we want 3 nodes, got %v", got)
+	}
+}
+
+func TestShare(t *testing.T) {
+	hash := New(1, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+	// "0a" does not parse, so "a" lands at 0 and "1" owns the rest of the ring
+	hash.Add("a", "1")
+
+	share := hash.Share()
+	if share["a"] != 1-share["1"] || share["1"] != 1/float64(1<<32) {
+		t.Fatalf("got %v", share)
+	}
+
+	m := New(50, nil)
+	m.Add("http://localhost:8001", "http://localhost:8002", "http://localhost:8003")
+	var total float64
+	for _, s := range m.Share() {
+		total += s
+	}
+	if total < 0.999999 || total > 1.000001 {
+		t.Fatalf("the shares should add up to 1, got %v", total)
+	}
+}
@@ -0,0 +1,173 @@
+package discovery
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// discovery tells us who the cache peers are, and when that changes
+type Discovery interface {
+	// the current peer set
+	Peers() []string
+	// every receive is the full peer set after a change, the channel is closed on Close
+	// slow watchers only see the latest set
+	Watch() <-chan []string
+	Close() error
+}
+
+// trim, dedupe and sort, so that two sets can be compared
+func normalize(peers []string) []string {
+	seen := make(map[string]bool, len(peers))
+	out := make([]string, 0, len(peers))
+	for _, p := range peers {
+		p = strings.TrimSpace(p)
+		if p == "" || seen[p] {
+			continue
+		}
+		seen[p] = true
+		out = append(out, p)
+	}
+	sort.Strings(out)
+
+	return out
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// notifier keeps the current set and fans changes out to the watchers
+type notifier struct {
+	mu       sync.Mutex
+	peers    []string
+	watchers []chan []string
+	closed   bool
+}
+
+func (n *notifier) current() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return append([]string(nil), n.peers...)
+}
+
+func (n *notifier) watch() <-chan []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	ch := make(chan []string, 1)
+	if n.closed {
+		close(ch)
+		return ch
+	}
+	n.watchers = append(n.watchers, ch)
+
+	return ch
+}
+
+// publish returns false if nothing changed
+func (n *notifier) publish(peers []string) bool {
+	peers = normalize(peers)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.closed || equal(n.peers, peers) {
+		return false
+	}
+	n.peers = peers
+
+	for _, ch := range n.watchers {
+		// replace the value the watcher has not picked up yet
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- append([]string(nil), peers...)
+	}
+
+	return true
+}
+
+func (n *notifier) close() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.closed {
+		return
+	}
+	n.closed = true
+	for _, ch := range n.watchers {
+		close(ch)
+	}
+	n.watchers = nil
+}
+
+// a fixed peer list
+type Static struct {
+	n notifier
+}
+
+func NewStatic(peers ...string) *Static {
+	s := &Static{}
+	s.n.publish(peers)
+
+	return s
+}
+
+func (s *Static) Peers() []string {
+	return s.n.current()
+}
+
+func (s *Static) Watch() <-chan []string {
+	return s.n.watch()
+}
+
+func (s *Static) Close() error {
+	s.n.close()
+	return nil
+}
+
+// a peer list changed by hand, mostly for tests
+type Memory struct {
+	n notifier
+}
+
+func NewMemory(peers ...string) *Memory {
+	m := &Memory{}
+	m.n.publish(peers)
+
+	return m
+}
+
+func (m *Memory) Set(peers ...string) {
+	m.n.publish(peers)
+}
+
+func (m *Memory) Peers() []string {
+	return m.n.current()
+}
+
+func (m *Memory) Watch() <-chan []string {
+	return m.n.watch()
+}
+
+func (m *Memory) Close() error {
+	m.n.close()
+	return nil
+}
+
+var (
+	_ Discovery = (*Static)(nil)
+	_ Discovery = (*Memory)(nil)
+)
@@ -0,0 +1,117 @@
+package discovery
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func receive(t *testing.T, ch <-chan []string) []string {
+	t.Helper()
+
+	select {
+	case peers, ok := <-ch:
+		if !ok {
+			t.Fatal("watch channel closed")
+		}
+		return peers
+	case <-time.After(2 * time.Second):
+		t.Fatal("no change notification")
+	}
+
+	return nil
+}
+
+func TestStatic(t *testing.T) {
+	s := NewStatic("b", "a", " a ", "")
+
+	if got := s.Peers(); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Fatalf("we want normalized peers, got %v", got)
+	}
+
+	ch := s.Watch()
+	s.Close()
+	if _, ok := <-ch; ok {
+		t.Fatal("watch channel should be closed")
+	}
+}
+
+func TestMemory_Watch(t *testing.T) {
+	m := NewMemory("a")
+	ch := m.Watch()
+
+	// nothing changed, nothing is sent
+	m.Set("a")
+	select {
+	case peers := <-ch:
+		t.Fatalf("unexpected notification %v", peers)
+	default:
+	}
+
+	// a slow watcher only sees the latest set
+	m.Set("a", "b")
+	m.Set("a", "b", "c")
+	if got := receive(t, ch); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+		t.Fatalf("unexpected peers %v", got)
+	}
+}
+
+func TestFile_Reload(t *testing.T) {
+	dir := t.TempDir()
+
+	cases := []struct {
+		name    string
+		first   string
+		second  string
+		initial []string
+		changed []string
+	}{
+		{"peers.json", `["http://a:8001"]`, `{"peers": ["http://a:8001", "http://b:8001"]}`, []string{"http://a:8001"}, []string{"http://a:8001", "http://b:8001"}},
+		{"peers.yaml", "peers:\n  - http://a:8001\n", "- http://b:8001\n", []string{"http://a:8001"}, []string{"http://b:8001"}},
+	}
+
+	for _, c := range cases {
+		path := filepath.Join(dir, c.name)
+		if err := os.WriteFile(path, []byte(c.first), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := NewFile(path, 10*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := f.Peers(); !reflect.DeepEqual(got, c.initial) {
+			t.Fatalf("%s: we want %v, got %v", c.name, c.initial, got)
+		}
+
+		ch := f.Watch()
+
+		// a broken or empty file keeps the last good set
+		for _, bad := range []string{"{{{", "", "peers: []\n"} {
+			if err := os.WriteFile(path, []byte(bad), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(50 * time.Millisecond)
+			if got := f.Peers(); !reflect.DeepEqual(got, c.initial) {
+				t.Fatalf("%s: a bad file %q should be ignored, got %v", c.name, bad, got)
+			}
+		}
+
+		if err := os.WriteFile(path, []byte(c.second), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if got := receive(t, ch); !reflect.DeepEqual(got, c.changed) {
+			t.Fatalf("%s: we want %v, got %v", c.name, c.changed, got)
+		}
+
+		f.Close()
+	}
+}
+
+func TestFile_Missing(t *testing.T) {
+	if _, err := NewFile(filepath.Join(t.TempDir(), "nope.json"), 0); err == nil {
+		t.Fatal("a missing file should be an error")
+	}
+}
@@ -0,0 +1,166 @@
+package discovery
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const defaultPollInterval = 2 * time.Second
+
+// a peer list read from a json or yaml file, the file is reloaded when it changes
+//
+// both a plain list and an object with a peers field are accepted:
+//
+//	["http://10.0.0.1:8001", "http://10.0.0.2:8001"]
+//	peers:
+//	  - http://10.0.0.1:8001
+type File struct {
+	path     string
+	interval time.Duration
+	n        notifier
+
+	modTime time.Time
+	content []byte
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type peerFile struct {
+	Peers []string `json:"peers" yaml:"peers"`
+}
+
+func NewFile(path string, interval time.Duration) (*File, error) {
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+
+	f := &File{
+		path:     path,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if _, err := f.reload(); err != nil {
+		return nil, err
+	}
+
+	go f.poll()
+
+	return f, nil
+}
+
+func (f *File) Peers() []string {
+	return f.n.current()
+}
+
+func (f *File) Watch() <-chan []string {
+	return f.n.watch()
+}
+
+func (f *File) Close() error {
+	select {
+	case <-f.stop:
+		return nil
+	default:
+	}
+
+	close(f.stop)
+	<-f.done
+	f.n.close()
+
+	return nil
+}
+
+func (f *File) poll() {
+	defer close(f.done)
+
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			changed, err := f.reload()
+			if err != nil {
+				// keep the last good peer set
+				log.Printf("[Discovery] fail to reload %s: %s", f.path, err.Error())
+				continue
+			}
+			if changed {
+				log.Printf("[Discovery] peers changed in %s: %v", f.path, f.n.current())
+			}
+		case <-f.stop:
+			return
+		}
+	}
+}
+
+// reload parses the file again if it was modified, and reports whether the peer set changed
+func (f *File) reload() (bool, error) {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return false, err
+	}
+	if f.content != nil && info.ModTime().Equal(f.modTime) {
+		return false, nil
+	}
+
+	content, err := os.ReadFile(f.path)
+	if err != nil {
+		return false, err
+	}
+	// the mtime moved but the content did not, e.g. a touch
+	if f.content != nil && bytes.Equal(content, f.content) {
+		f.modTime = info.ModTime()
+		return false, nil
+	}
+
+	peers, err := parsePeers(f.path, content)
+	if err != nil {
+		return false, err
+	}
+	// an empty or half-written file would wipe the ring, it is never what was meant
+	if len(peers) == 0 {
+		return false, fmt.Errorf("no peers in %s", f.path)
+	}
+	f.modTime, f.content = info.ModTime(), content
+
+	return f.n.publish(peers), nil
+}
+
+func parsePeers(path string, content []byte) ([]string, error) {
+	var list []string
+	var obj peerFile
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		if err := json.Unmarshal(content, &list); err == nil {
+			return list, nil
+		}
+		if err := json.Unmarshal(content, &obj); err != nil {
+			return nil, fmt.Errorf("bad peer file %s: %w", path, err)
+		}
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(content, &list); err == nil {
+			return list, nil
+		}
+		if err := yaml.Unmarshal(content, &obj); err != nil {
+			return nil, fmt.Errorf("bad peer file %s: %w", path, err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown peer file format %s", path)
+	}
+
+	return obj.Peers, nil
+}
+
+var _ Discovery = (*File)(nil)
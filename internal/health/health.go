@@ -0,0 +1,216 @@
+package health
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultInterval = 2 * time.Second
+	defaultFall     = 3
+	defaultRise     = 2
+)
+
+// a prober returns nil if the peer at addr answers
+type Prober func(addr string) error
+
+type Options struct {
+	// how often every peer is probed
+	Interval time.Duration
+	// consecutive failures before a peer is marked unhealthy
+	Fall int
+	// consecutive successes before an unhealthy peer is marked healthy again
+	Rise int
+	// called without any lock held whenever a peer changes state
+	OnChange func(addr string, healthy bool)
+}
+
+// the health state of a single peer
+type Status struct {
+	Healthy   bool      `json:"healthy"`
+	Failures  int       `json:"failures"`
+	Successes int       `json:"successes"`
+	LastError string    `json:"last_error,omitempty"`
+	LastCheck time.Time `json:"last_check"`
+	Since     time.Time `json:"since"`
+}
+
+// checker probes a set of peers on a schedule and tracks consecutive failures
+type Checker struct {
+	probe Prober
+	opts  Options
+
+	mu    sync.Mutex
+	peers map[string]*Status
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func New(probe Prober, opts Options) *Checker {
+	if probe == nil {
+		panic("need a good prober")
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = defaultInterval
+	}
+	if opts.Fall <= 0 {
+		opts.Fall = defaultFall
+	}
+	if opts.Rise <= 0 {
+		opts.Rise = defaultRise
+	}
+
+	return &Checker{
+		probe: probe,
+		opts:  opts,
+		mu:    sync.Mutex{},
+		peers: map[string]*Status{},
+	}
+}
+
+// set replaces the watched peers, known peers keep their state and new peers start healthy
+func (c *Checker) Set(addrs ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	peers := make(map[string]*Status, len(addrs))
+	for _, addr := range addrs {
+		if s, ok := c.peers[addr]; ok {
+			peers[addr] = s
+			continue
+		}
+		peers[addr] = &Status{Healthy: true, Since: time.Now()}
+	}
+	c.peers = peers
+}
+
+// unknown peers are treated as healthy
+func (c *Checker) Healthy(addr string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if s, ok := c.peers[addr]; ok {
+		return s.Healthy
+	}
+
+	return true
+}
+
+func (c *Checker) Status() map[string]Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	out := make(map[string]Status, len(c.peers))
+	for addr, s := range c.peers {
+		out[addr] = *s
+	}
+
+	return out
+}
+
+func (c *Checker) Start() {
+	c.mu.Lock()
+	if c.stop != nil {
+		c.mu.Unlock()
+		return
+	}
+	c.stop = make(chan struct{})
+	c.done = make(chan struct{})
+	stop, done := c.stop, c.done
+	c.mu.Unlock()
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(c.opts.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				c.CheckOnce()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func (c *Checker) Stop() {
+	c.mu.Lock()
+	stop, done := c.stop, c.done
+	c.stop, c.done = nil, nil
+	c.mu.Unlock()
+
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+}
+
+// probe all peers concurrently and wait for the results
+func (c *Checker) CheckOnce() {
+	c.mu.Lock()
+	addrs := make([]string, 0, len(c.peers))
+	for addr := range c.peers {
+		addrs = append(addrs, addr)
+	}
+	c.mu.Unlock()
+	sort.Strings(addrs)
+
+	errs := make([]error, len(addrs))
+	var wg sync.WaitGroup
+	for i, addr := range addrs {
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+			errs[i] = c.probe(addr)
+		}(i, addr)
+	}
+	wg.Wait()
+
+	for i, addr := range addrs {
+		if healthy, changed := c.record(addr, errs[i]); changed && c.opts.OnChange != nil {
+			c.opts.OnChange(addr, healthy)
+		}
+	}
+}
+
+func (c *Checker) record(addr string, err error) (healthy bool, changed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.peers[addr]
+	// the peer was removed while we were probing
+	if !ok {
+		return false, false
+	}
+
+	now := time.Now()
+	s.LastCheck = now
+	if err != nil {
+		s.Failures += 1
+		s.Successes = 0
+		s.LastError = err.Error()
+		if s.Healthy && s.Failures >= c.opts.Fall {
+			s.Healthy = false
+			s.Since = now
+			return false, true
+		}
+		return s.Healthy, false
+	}
+
+	s.Successes += 1
+	s.Failures = 0
+	s.LastError = ""
+	if !s.Healthy && s.Successes >= c.opts.Rise {
+		s.Healthy = true
+		s.Since = now
+		return true, true
+	}
+
+	return s.Healthy, false
+}
@@ -0,0 +1,115 @@
+package health
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakePeers struct {
+	mu   sync.Mutex
+	down map[string]bool
+}
+
+func (f *fakePeers) set(addr string, down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down[addr] = down
+}
+
+func (f *fakePeers) probe(addr string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down[addr] {
+		return fmt.Errorf("%s is down", addr)
+	}
+	return nil
+}
+
+func TestChecker_EjectAndRecover(t *testing.T) {
+	peers := &fakePeers{down: map[string]bool{}}
+
+	var changes []string
+	c := New(peers.probe, Options{
+		Fall: 2,
+		Rise: 2,
+		OnChange: func(addr string, healthy bool) {
+			changes = append(changes, fmt.Sprintf("%s=%v", addr, healthy))
+		},
+	})
+	c.Set("a", "b")
+
+	peers.set("a", true)
+	c.CheckOnce()
+	if !c.Healthy("a") {
+		t.Fatal("a single failure should not eject a peer")
+	}
+
+	c.CheckOnce()
+	if c.Healthy("a") || !c.Healthy("b") {
+		t.Fatal("a should be ejected after two failures, b should stay")
+	}
+
+	peers.set("a", false)
+	c.CheckOnce()
+	if c.Healthy("a") {
+		t.Fatal("a single success should not recover a peer")
+	}
+
+	c.CheckOnce()
+	if !c.Healthy("a") {
+		t.Fatal("a should recover after two successes")
+	}
+
+	if len(changes) != 2 || changes[0] != "a=false" || changes[1] != "a=true" {
+		t.Fatalf("unexpected state changes %v", changes)
+	}
+
+	if s := c.Status()["a"]; !s.Healthy || s.Successes != 2 || s.Failures != 0 {
+		t.Fatalf("unexpected status %+v", s)
+	}
+}
+
+func TestChecker_Set(t *testing.T) {
+	peers := &fakePeers{down: map[string]bool{"a": true}}
+	c := New(peers.probe, Options{Fall: 1})
+	c.Set("a")
+	c.CheckOnce()
+
+	// known peers keep their state, removed peers are forgotten
+	c.Set("a", "c")
+	if c.Healthy("a") || !c.Healthy("c") {
+		t.Fatal("set should keep the state of known peers")
+	}
+
+	c.Set("c")
+	if _, ok := c.Status()["a"]; ok {
+		t.Fatal("a should be forgotten")
+	}
+}
+
+func TestChecker_Start(t *testing.T) {
+	peers := &fakePeers{down: map[string]bool{"a": true}}
+
+	changed := make(chan string, 1)
+	c := New(peers.probe, Options{
+		Interval: 5 * time.Millisecond,
+		Fall:     1,
+		OnChange: func(addr string, healthy bool) {
+			changed <- addr
+		},
+	})
+	c.Set("a")
+	c.Start()
+	defer c.Stop()
+
+	select {
+	case addr := <-changed:
+		if addr != "a" {
+			t.Fatalf("unexpected peer %s", addr)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the background checker never ejected a")
+	}
+}
@@ -0,0 +1,206 @@
+package hotkey
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// a tracker finds the heaviest keys with two space-saving summaries, one weighs requests
+// and one the bytes served, see Metwally et al., Efficient Computation of Frequent and
+// Top-k Elements in Data Streams
+//
+// counts decay exponentially, a request counts half as much after HalfLife, so a key
+// which cooled down leaves the top soon. instead of touching every counter, a new request
+// weighs 2^(age/HalfLife) and the counters are divided by the same when they are read
+const (
+	defaultK        = 64
+	defaultHalfLife = time.Minute
+	// the weights are rescaled before they can overflow
+	maxExponent = 64
+)
+
+type Options struct {
+	// keys kept per summary, the top is exact for keys well above the k-th heaviest
+	K int
+	// how fast counts decay
+	HalfLife time.Duration
+}
+
+type Entry struct {
+	Key string `json:"key"`
+	// the decayed count, requests or bytes
+	Count float64 `json:"count"`
+	// the count is at most this much too high
+	Error float64 `json:"error"`
+	// the count per second of a key which is read at a steady rate
+	Rate float64 `json:"rate"`
+	// the fraction of the decayed total of all keys
+	Share float64 `json:"share"`
+}
+
+type Report struct {
+	ByRequests []Entry `json:"by_requests"`
+	ByBytes    []Entry `json:"by_bytes"`
+}
+
+type counter struct {
+	key   string
+	value float64
+	err   float64
+}
+
+type summary struct {
+	k        int
+	counters map[string]*counter
+	total    float64
+}
+
+func newSummary(k int) *summary {
+	return &summary{k: k, counters: make(map[string]*counter, k)}
+}
+
+// a new key takes over the smallest counter, inheriting its value as the error
+func (s *summary) add(key string, w float64) {
+	s.total += w
+	if c, ok := s.counters[key]; ok {
+		c.value += w
+		return
+	}
+	if len(s.counters) < s.k {
+		s.counters[key] = &counter{key: key, value: w}
+		return
+	}
+
+	var smallest *counter
+	for _, c := range s.counters {
+		if smallest == nil || c.value < smallest.value {
+			smallest = c
+		}
+	}
+	delete(s.counters, smallest.key)
+	s.counters[key] = &counter{key: key, value: smallest.value + w, err: smallest.value}
+}
+
+func (s *summary) scale(f float64) {
+	s.total *= f
+	for _, c := range s.counters {
+		c.value *= f
+		c.err *= f
+	}
+}
+
+// a steady rate r adds up to a decayed count of r * halfLife / ln 2
+func rateOf(count float64, halfLife time.Duration) float64 {
+	return count * math.Ln2 / halfLife.Seconds()
+}
+
+func (s *summary) entry(c *counter, scale float64, halfLife time.Duration) Entry {
+	e := Entry{Key: c.key, Count: c.value / scale, Error: c.err / scale}
+	e.Rate = rateOf(e.Count, halfLife)
+	if s.total > 0 {
+		e.Share = c.value / s.total
+	}
+	return e
+}
+
+func (s *summary) top(n int, scale float64, halfLife time.Duration) []Entry {
+	list := make([]*counter, 0, len(s.counters))
+	for _, c := range s.counters {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].value != list[j].value {
+			return list[i].value > list[j].value
+		}
+		return list[i].key < list[j].key
+	})
+	if n > 0 && len(list) > n {
+		list = list[:n]
+	}
+
+	entries := make([]Entry, len(list))
+	for i, c := range list {
+		entries[i] = s.entry(c, scale, halfLife)
+	}
+	return entries
+}
+
+type Tracker struct {
+	mu       sync.Mutex
+	halfLife time.Duration
+	requests *summary
+	bytes    *summary
+	// the weights are relative to this time
+	landmark time.Time
+
+	now func() time.Time
+}
+
+func New(opts Options) *Tracker {
+	if opts.K <= 0 {
+		opts.K = defaultK
+	}
+	if opts.HalfLife <= 0 {
+		opts.HalfLife = defaultHalfLife
+	}
+
+	return &Tracker{
+		halfLife: opts.HalfLife,
+		requests: newSummary(opts.K),
+		bytes:    newSummary(opts.K),
+		landmark: time.Now(),
+		now:      time.Now,
+	}
+}
+
+// the weight of a request now, relative to the landmark
+func (t *Tracker) weightLocked(now time.Time) float64 {
+	e := float64(now.Sub(t.landmark)) / float64(t.halfLife)
+	if e > maxExponent {
+		// move the landmark to now, everything counted so far shrinks accordingly
+		f := math.Exp2(-e)
+		t.requests.scale(f)
+		t.bytes.scale(f)
+		t.landmark = now
+		e = 0
+	}
+	return math.Exp2(e)
+}
+
+// add counts a request for key which served size bytes
+func (t *Tracker) Add(key string, size int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	w := t.weightLocked(t.now())
+	t.requests.add(key, w)
+	if size > 0 {
+		t.bytes.add(key, w*float64(size))
+	}
+}
+
+// the n heaviest keys by requests and by bytes, all tracked keys if n <= 0
+func (t *Tracker) Top(n int) Report {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	scale := t.weightLocked(t.now())
+	return Report{
+		ByRequests: t.requests.top(n, scale, t.halfLife),
+		ByBytes:    t.bytes.top(n, scale, t.halfLife),
+	}
+}
+
+// the decayed requests of key, false if it is not among the tracked ones
+func (t *Tracker) Requests(key string) (Entry, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c, ok := t.requests.counters[key]
+	if !ok {
+		return Entry{}, false
+	}
+	return t.requests.entry(c, t.weightLocked(t.now()), t.halfLife), true
+}
@@ -0,0 +1,84 @@
+package hotkey
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func newTracker(opts Options) (*Tracker, *fakeClock) {
+	clock := &fakeClock{t: time.Now()}
+	t := New(opts)
+	t.now = clock.now
+	t.landmark = clock.t
+
+	return t, clock
+}
+
+func TestTracker_Top(t *testing.T) {
+	tracker, _ := newTracker(Options{K: 16})
+
+	// three heavy keys in a stream of many light ones
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 20000; i += 1 {
+		switch n := r.Intn(10); {
+		case n < 3:
+			tracker.Add("hot", 10)
+		case n < 5:
+			tracker.Add("warm", 10)
+		case n < 6:
+			tracker.Add("big", 1000)
+		default:
+			tracker.Add(fmt.Sprint("cold", r.Intn(5000)), 10)
+		}
+	}
+
+	report := tracker.Top(3)
+	var keys []string
+	for _, e := range report.ByRequests {
+		keys = append(keys, e.Key)
+	}
+	if fmt.Sprint(keys) != "[hot warm big]" {
+		t.Fatalf("want hot, warm and big by requests, got %v", keys)
+	}
+	if e := report.ByRequests[0]; math.Abs(e.Share-0.3) > 0.02 || e.Error != 0 {
+		t.Fatalf("hot should have a share of 0.3, got %+v", e)
+	}
+	if report.ByBytes[0].Key != "big" {
+		t.Fatalf("big should serve the most bytes, got %+v", report.ByBytes[0])
+	}
+}
+
+func TestTracker_Decay(t *testing.T) {
+	tracker, clock := newTracker(Options{K: 4, HalfLife: time.Second})
+
+	for i := 0; i < 100; i += 1 {
+		tracker.Add("old", 1)
+	}
+	clock.t = clock.t.Add(2 * time.Second)
+	if e, _ := tracker.Requests("old"); math.Abs(e.Count-25) > 0.001 {
+		t.Fatalf("two half lives should leave a quarter, got %v", e.Count)
+	}
+
+	for i := 0; i < 50; i += 1 {
+		tracker.Add("new", 1)
+	}
+	if top := tracker.Top(1).ByRequests; top[0].Key != "new" {
+		t.Fatalf("the recent key should win, got %+v", top)
+	}
+
+	// far in the future the weights are rescaled, the counts stay right
+	clock.t = clock.t.Add(100 * time.Second)
+	tracker.Add("new", 1)
+	if e, _ := tracker.Requests("new"); math.Abs(e.Count-1) > 0.001 || math.IsInf(e.Count, 0) {
+		t.Fatalf("want a count of about 1, got %v", e.Count)
+	}
+}
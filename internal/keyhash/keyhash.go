@@ -0,0 +1,17 @@
+package keyhash
+
+import "hash/fnv"
+
+// the hash of a key for sampling, a key is sampled if it is at most rate * MaxUint64
+//
+// fnv alone spreads keys which only differ at the end badly over the high bits, the threshold looks at
+// those, so the hash is mixed once more, see splitmix64
+func Sum(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+
+	x := h.Sum64()
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
@@ -0,0 +1,23 @@
+package keyhash
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestSum_SequentialKeys(t *testing.T) {
+	rate := 0.1
+	threshold := uint64(rate * math.MaxUint64)
+	for _, prefix := range []string{"k", "user:", "Tom"} {
+		sampled := 0
+		for i := 0; i < 10000; i += 1 {
+			if Sum(fmt.Sprint(prefix, i)) <= threshold {
+				sampled += 1
+			}
+		}
+		if sampled < 900 || sampled > 1100 {
+			t.Fatalf("want about 1000 of 10000 %s keys sampled, got %d", prefix, sampled)
+		}
+	}
+}
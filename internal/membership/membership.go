@@ -0,0 +1,741 @@
+package membership
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultProbeInterval  = time.Second
+	defaultProbeTimeout   = 500 * time.Millisecond
+	defaultIndirectChecks = 3
+	defaultRetransmitMult = 4
+	// updates piggybacked on a single message
+	maxPiggyback = 8
+)
+
+type State int
+
+const (
+	StateAlive State = iota
+	StateSuspect
+	StateDead
+	StateLeft
+)
+
+func (s State) String() string {
+	switch s {
+	case StateAlive:
+		return "alive"
+	case StateSuspect:
+		return "suspect"
+	case StateDead:
+		return "dead"
+	case StateLeft:
+		return "left"
+	}
+	return "unknown"
+}
+
+type Member struct {
+	// unique name of the member, we use the address of its cache server
+	Name string `json:"name"`
+	// the gossip address
+	Addr        string `json:"addr"`
+	State       State  `json:"state"`
+	Incarnation uint64 `json:"incarnation"`
+}
+
+type EventType int
+
+const (
+	EventJoin EventType = iota
+	EventLeave
+	EventFail
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventJoin:
+		return "join"
+	case EventLeave:
+		return "leave"
+	case EventFail:
+		return "fail"
+	}
+	return "unknown"
+}
+
+type Event struct {
+	Type   EventType
+	Member Member
+}
+
+type Config struct {
+	// unique name of this member
+	Name string
+	// udp address to listen on, ignored if Transport is set
+	BindAddr  string
+	Transport Transport
+
+	// every interval we probe one member
+	ProbeInterval time.Duration
+	// how long we wait for a direct ack before asking others
+	ProbeTimeout time.Duration
+	// how many members we ask to probe indirectly
+	IndirectChecks int
+	// how long a member stays suspect before it is declared dead
+	SuspicionTimeout time.Duration
+	// gossip is retransmitted RetransmitMult * log(n + 1) times
+	RetransmitMult int
+
+	// called in order, from a single goroutine
+	OnEvent func(Event)
+}
+
+// a swim style membership list
+type Memberlist struct {
+	conf      Config
+	transport Transport
+
+	mu         sync.Mutex
+	self       *Member
+	members    map[string]*Member
+	probeOrder []string
+	probeIdx   int
+	seq        uint32
+	acks       map[uint32]func()
+	suspicions map[string]*time.Timer
+	broadcasts *broadcastQueue
+	left       bool
+
+	eventMu    sync.Mutex
+	events     []Event
+	eventReady chan struct{}
+
+	shutdown     chan struct{}
+	shutdownOnce sync.Once
+	wg           sync.WaitGroup
+}
+
+func Create(conf Config) (*Memberlist, error) {
+	if conf.Name == "" {
+		return nil, errors.New("membership: need a name")
+	}
+	if conf.ProbeInterval <= 0 {
+		conf.ProbeInterval = defaultProbeInterval
+	}
+	if conf.ProbeTimeout <= 0 || conf.ProbeTimeout >= conf.ProbeInterval {
+		conf.ProbeTimeout = conf.ProbeInterval / 2
+		if conf.ProbeInterval == defaultProbeInterval {
+			conf.ProbeTimeout = defaultProbeTimeout
+		}
+	}
+	if conf.IndirectChecks <= 0 {
+		conf.IndirectChecks = defaultIndirectChecks
+	}
+	if conf.SuspicionTimeout <= 0 {
+		conf.SuspicionTimeout = 5 * conf.ProbeInterval
+	}
+	if conf.RetransmitMult <= 0 {
+		conf.RetransmitMult = defaultRetransmitMult
+	}
+
+	transport := conf.Transport
+	if transport == nil {
+		t, err := NewUDPTransport(conf.BindAddr)
+		if err != nil {
+			return nil, err
+		}
+		transport = t
+	}
+
+	self := &Member{
+		Name:  conf.Name,
+		Addr:  transport.Addr(),
+		State: StateAlive,
+	}
+
+	m := &Memberlist{
+		conf:       conf,
+		transport:  transport,
+		self:       self,
+		members:    map[string]*Member{self.Name: self},
+		acks:       map[uint32]func(){},
+		suspicions: map[string]*time.Timer{},
+		broadcasts: newBroadcastQueue(),
+		eventReady: make(chan struct{}, 1),
+		shutdown:   make(chan struct{}),
+	}
+
+	m.wg.Add(3)
+	go m.receiveLoop()
+	go m.probeLoop()
+	go m.eventLoop()
+
+	return m, nil
+}
+
+func (m *Memberlist) Log(format string, v ...any) {
+	log.Printf("[Membership %s] %s", m.conf.Name, fmt.Sprintf(format, v...))
+}
+
+func (m *Memberlist) LocalMember() Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return *m.self
+}
+
+// the members which are alive or suspect, including ourselves
+func (m *Memberlist) Members() []Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := make([]Member, 0, len(m.members))
+	for _, member := range m.members {
+		if member.State == StateAlive || member.State == StateSuspect {
+			out = append(out, *member)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+
+	return out
+}
+
+// every member we know about, whatever its state
+func (m *Memberlist) AllMembers() []Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := make([]Member, 0, len(m.members))
+	for _, member := range m.members {
+		out = append(out, *member)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+
+	return out
+}
+
+// join contacts the given gossip addresses and exchanges the full state with them
+// it returns the number of seeds which answered
+func (m *Memberlist) Join(addrs ...string) (int, error) {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	joined := 0
+	var lastErr error
+
+	for _, addr := range addrs {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+
+			if err := m.pushPull(addr); err != nil {
+				mu.Lock()
+				lastErr = err
+				mu.Unlock()
+				return
+			}
+
+			mu.Lock()
+			joined += 1
+			mu.Unlock()
+		}(addr)
+	}
+	wg.Wait()
+
+	if joined == 0 && lastErr != nil {
+		return 0, lastErr
+	}
+
+	return joined, nil
+}
+
+func (m *Memberlist) pushPull(addr string) error {
+	acked := make(chan struct{}, 1)
+	seq := m.registerAck(func() {
+		select {
+		case acked <- struct{}{}:
+		default:
+		}
+	})
+	defer m.unregisterAck(seq)
+
+	m.mu.Lock()
+	msg := &message{Type: syncMsg, Seq: seq, From: m.self.Addr, Join: true, Updates: m.stateLocked()}
+	m.mu.Unlock()
+
+	// the join itself may be lost, try a few times
+	for i := 0; i < 3; i += 1 {
+		if err := m.send(addr, msg); err != nil {
+			return err
+		}
+
+		select {
+		case <-acked:
+			return nil
+		case <-time.After(m.conf.ProbeInterval):
+		case <-m.shutdown:
+			return errors.New("membership: shut down")
+		}
+	}
+
+	return fmt.Errorf("membership: no answer from %s", addr)
+}
+
+// leave tells the others that we are leaving on purpose, then shuts down
+func (m *Memberlist) Leave(timeout time.Duration) error {
+	m.mu.Lock()
+	if m.left {
+		m.mu.Unlock()
+		return nil
+	}
+	m.left = true
+	m.self.Incarnation += 1
+	m.self.State = StateLeft
+	u := m.updateOf(m.self)
+
+	targets := make([]string, 0, len(m.members))
+	for _, member := range m.members {
+		if member != m.self && (member.State == StateAlive || member.State == StateSuspect) {
+			targets = append(targets, member.Addr)
+		}
+	}
+	m.mu.Unlock()
+
+	// tell everyone directly, and wait until somebody heard us
+	acked := make(chan struct{}, len(targets))
+	seq := m.registerAck(func() {
+		select {
+		case acked <- struct{}{}:
+		default:
+		}
+	})
+	defer m.unregisterAck(seq)
+
+	for _, addr := range targets {
+		m.send(addr, &message{Type: pingMsg, Seq: seq, From: m.self.Addr, Updates: []update{u}})
+	}
+
+	if len(targets) > 0 {
+		select {
+		case <-acked:
+		case <-time.After(timeout):
+			m.Log("nobody acknowledged our leave")
+		}
+	}
+
+	return m.Shutdown()
+}
+
+func (m *Memberlist) Shutdown() error {
+	m.shutdownOnce.Do(func() {
+		close(m.shutdown)
+		m.transport.Close()
+
+		m.mu.Lock()
+		for name, t := range m.suspicions {
+			t.Stop()
+			delete(m.suspicions, name)
+		}
+		m.mu.Unlock()
+	})
+	m.wg.Wait()
+
+	return nil
+}
+
+func (m *Memberlist) send(addr string, msg *message) error {
+	msg.Node = m.conf.Name
+
+	// piggyback gossip on everything but the full state sync
+	if msg.Type != syncMsg {
+		m.mu.Lock()
+		msg.Updates = append(msg.Updates, m.broadcasts.take(maxPiggyback-len(msg.Updates), m.conf.RetransmitMult, len(m.members))...)
+		m.mu.Unlock()
+	}
+
+	b, err := encode(msg)
+	if err != nil {
+		return err
+	}
+
+	return m.transport.WriteTo(b, addr)
+}
+
+func (m *Memberlist) registerAck(f func()) uint32 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.seq += 1
+	m.acks[m.seq] = f
+
+	return m.seq
+}
+
+func (m *Memberlist) unregisterAck(seq uint32) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.acks, seq)
+}
+
+func (m *Memberlist) receiveLoop() {
+	defer m.wg.Done()
+
+	for {
+		select {
+		case p, ok := <-m.transport.PacketCh():
+			if !ok {
+				return
+			}
+			msg, err := decode(p.Buf)
+			if err != nil {
+				m.Log("drop bad packet from %s: %s", p.From, err.Error())
+				continue
+			}
+			m.handle(msg)
+		case <-m.shutdown:
+			return
+		}
+	}
+}
+
+func (m *Memberlist) handle(msg *message) {
+	if msg.Type == syncMsg {
+		m.mu.Lock()
+		for _, u := range msg.Updates {
+			m.applyLocked(u)
+		}
+		state := m.stateLocked()
+		ack := m.acks[msg.Seq]
+		m.mu.Unlock()
+
+		if msg.Join {
+			m.send(msg.From, &message{Type: syncMsg, Seq: msg.Seq, From: m.self.Addr, Updates: state})
+		} else if ack != nil {
+			ack()
+		}
+		return
+	}
+
+	m.mu.Lock()
+	for _, u := range msg.Updates {
+		m.applyLocked(u)
+	}
+	self := m.self.Name
+	// the sender does not know we declared it dead, tell it so that it can refute
+	var news []update
+	if sender, ok := m.members[msg.Node]; ok && sender.State == StateDead {
+		news = append(news, m.updateOf(sender))
+	}
+	m.mu.Unlock()
+
+	switch msg.Type {
+	case pingMsg:
+		// a ping meant for somebody who used to live at our address
+		if msg.Target != "" && msg.Target != self {
+			return
+		}
+		m.send(msg.From, &message{Type: ackMsg, Seq: msg.Seq, From: m.self.Addr, Updates: news})
+	case ackMsg:
+		m.mu.Lock()
+		ack := m.acks[msg.Seq]
+		m.mu.Unlock()
+		if ack != nil {
+			ack()
+		}
+	case pingReqMsg:
+		// probe the target on behalf of the requester and forward its ack
+		requester, reqSeq := msg.From, msg.Seq
+		var seq uint32
+		seq = m.registerAck(func() {
+			m.unregisterAck(seq)
+			m.send(requester, &message{Type: ackMsg, Seq: reqSeq, From: m.self.Addr})
+		})
+		time.AfterFunc(m.conf.ProbeTimeout, func() { m.unregisterAck(seq) })
+		m.send(msg.Target, &message{Type: pingMsg, Seq: seq, From: m.self.Addr})
+	}
+}
+
+// the whole member list as gossip
+// must be called with m.mu held
+func (m *Memberlist) stateLocked() []update {
+	out := make([]update, 0, len(m.members))
+	for _, member := range m.members {
+		out = append(out, m.updateOf(member))
+	}
+
+	return out
+}
+
+func (m *Memberlist) updateOf(member *Member) update {
+	return update{
+		Name:        member.Name,
+		Addr:        member.Addr,
+		State:       member.State,
+		Incarnation: member.Incarnation,
+	}
+}
+
+// apply a piece of gossip, incarnation numbers decide which news is newer
+// must be called with m.mu held
+func (m *Memberlist) applyLocked(u update) {
+	if u.Name == m.self.Name {
+		m.refuteLocked(u)
+		return
+	}
+
+	member, known := m.members[u.Name]
+	if !known {
+		member = &Member{Name: u.Name, Addr: u.Addr, State: u.State, Incarnation: u.Incarnation}
+		m.members[u.Name] = member
+		if u.State == StateAlive || u.State == StateSuspect {
+			m.broadcasts.push(u)
+			m.notifyLocked(EventJoin, member)
+			if u.State == StateSuspect {
+				m.suspectLocked(member)
+			}
+		}
+		return
+	}
+
+	switch u.State {
+	case StateAlive:
+		if u.Incarnation <= member.Incarnation {
+			return
+		}
+		wasDown := member.State == StateDead || member.State == StateLeft
+		m.clearSuspicionLocked(member.Name)
+		member.Addr = u.Addr
+		member.State = StateAlive
+		member.Incarnation = u.Incarnation
+		m.broadcasts.push(u)
+		if wasDown {
+			m.notifyLocked(EventJoin, member)
+		}
+	case StateSuspect:
+		if member.State == StateDead || member.State == StateLeft || u.Incarnation < member.Incarnation {
+			return
+		}
+		if member.State == StateSuspect && u.Incarnation == member.Incarnation {
+			return
+		}
+		member.State = StateSuspect
+		member.Incarnation = u.Incarnation
+		m.broadcasts.push(u)
+		m.suspectLocked(member)
+	case StateDead, StateLeft:
+		if member.State == StateDead || member.State == StateLeft || u.Incarnation < member.Incarnation {
+			return
+		}
+		m.clearSuspicionLocked(member.Name)
+		member.State = u.State
+		member.Incarnation = u.Incarnation
+		m.broadcasts.push(u)
+		if u.State == StateLeft {
+			m.notifyLocked(EventLeave, member)
+		} else {
+			m.notifyLocked(EventFail, member)
+		}
+	}
+}
+
+// somebody thinks we are suspect or dead, tell everyone we are alive with a newer incarnation
+// must be called with m.mu held
+func (m *Memberlist) refuteLocked(u update) {
+	if m.left || u.State == StateAlive || u.Incarnation < m.self.Incarnation {
+		return
+	}
+
+	m.self.Incarnation = u.Incarnation + 1
+	m.broadcasts.push(m.updateOf(m.self))
+	m.Log("refute %s rumor with incarnation %d", u.State, m.self.Incarnation)
+}
+
+// must be called with m.mu held
+func (m *Memberlist) suspectLocked(member *Member) {
+	if _, ok := m.suspicions[member.Name]; ok {
+		return
+	}
+
+	name, incarnation := member.Name, member.Incarnation
+	m.suspicions[name] = time.AfterFunc(m.conf.SuspicionTimeout, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		delete(m.suspicions, name)
+		member, ok := m.members[name]
+		if !ok || member.State != StateSuspect {
+			return
+		}
+		// the member refuted with a newer incarnation but was suspected again
+		if member.Incarnation > incarnation {
+			m.suspectLocked(member)
+			return
+		}
+		m.applyLocked(update{Name: name, Addr: member.Addr, State: StateDead, Incarnation: member.Incarnation})
+	})
+}
+
+// must be called with m.mu held
+func (m *Memberlist) clearSuspicionLocked(name string) {
+	if t, ok := m.suspicions[name]; ok {
+		t.Stop()
+		delete(m.suspicions, name)
+	}
+}
+
+// must be called with m.mu held
+func (m *Memberlist) notifyLocked(t EventType, member *Member) {
+	m.Log("%s %s (%s)", t, member.Name, member.Addr)
+
+	m.eventMu.Lock()
+	m.events = append(m.events, Event{Type: t, Member: *member})
+	m.eventMu.Unlock()
+
+	select {
+	case m.eventReady <- struct{}{}:
+	default:
+	}
+}
+
+func (m *Memberlist) eventLoop() {
+	defer m.wg.Done()
+
+	for {
+		select {
+		case <-m.eventReady:
+		case <-m.shutdown:
+			return
+		}
+
+		m.eventMu.Lock()
+		events := m.events
+		m.events = nil
+		m.eventMu.Unlock()
+
+		if m.conf.OnEvent == nil {
+			continue
+		}
+		for _, ev := range events {
+			m.conf.OnEvent(ev)
+		}
+	}
+}
+
+func (m *Memberlist) probeLoop() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(m.conf.ProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if target, ok := m.nextTarget(); ok {
+				m.probe(target)
+			}
+		case <-m.shutdown:
+			return
+		}
+	}
+}
+
+// walk the members in a random order, reshuffle after every round
+func (m *Memberlist) nextTarget() (Member, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for tries := 0; tries <= len(m.members); tries += 1 {
+		if m.probeIdx >= len(m.probeOrder) {
+			m.probeOrder = m.probeOrder[:0]
+			for name := range m.members {
+				m.probeOrder = append(m.probeOrder, name)
+			}
+			rand.Shuffle(len(m.probeOrder), func(i, j int) {
+				m.probeOrder[i], m.probeOrder[j] = m.probeOrder[j], m.probeOrder[i]
+			})
+			m.probeIdx = 0
+		}
+
+		name := m.probeOrder[m.probeIdx]
+		m.probeIdx += 1
+
+		member, ok := m.members[name]
+		if !ok || member == m.self || (member.State != StateAlive && member.State != StateSuspect) {
+			continue
+		}
+		return *member, true
+	}
+
+	return Member{}, false
+}
+
+func (m *Memberlist) probe(target Member) {
+	acked := make(chan struct{}, 1)
+	seq := m.registerAck(func() {
+		select {
+		case acked <- struct{}{}:
+		default:
+		}
+	})
+	defer m.unregisterAck(seq)
+
+	m.send(target.Addr, &message{Type: pingMsg, Seq: seq, From: m.self.Addr, Target: target.Name})
+
+	select {
+	case <-acked:
+		return
+	case <-time.After(m.conf.ProbeTimeout):
+	case <-m.shutdown:
+		return
+	}
+
+	// no direct answer, ask some others to probe it for us
+	for _, addr := range m.indirectPeers(target.Name) {
+		m.send(addr, &message{Type: pingReqMsg, Seq: seq, From: m.self.Addr, Target: target.Addr})
+	}
+
+	select {
+	case <-acked:
+		return
+	case <-time.After(m.conf.ProbeInterval - m.conf.ProbeTimeout):
+	case <-m.shutdown:
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if member, ok := m.members[target.Name]; ok && member.State == StateAlive && member.Incarnation == target.Incarnation {
+		m.Log("no ack from %s, suspect it", target.Name)
+		m.applyLocked(update{Name: target.Name, Addr: target.Addr, State: StateSuspect, Incarnation: target.Incarnation})
+	}
+}
+
+func (m *Memberlist) indirectPeers(exclude string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	candidates := make([]string, 0, len(m.members))
+	for name, member := range m.members {
+		if member != m.self && name != exclude && member.State == StateAlive {
+			candidates = append(candidates, member.Addr)
+		}
+	}
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+
+	if len(candidates) > m.conf.IndirectChecks {
+		candidates = candidates[:m.conf.IndirectChecks]
+	}
+
+	return candidates
+}
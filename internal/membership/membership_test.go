@@ -0,0 +1,243 @@
+package membership
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+// drops a share of the outgoing packets
+type lossyTransport struct {
+	Transport
+
+	mu   sync.Mutex
+	rnd  *rand.Rand
+	loss float64
+}
+
+func (t *lossyTransport) WriteTo(b []byte, addr string) error {
+	t.mu.Lock()
+	drop := t.rnd.Float64() < t.loss
+	t.mu.Unlock()
+
+	if drop {
+		return nil
+	}
+
+	return t.Transport.WriteTo(b, addr)
+}
+
+type eventLog struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (l *eventLog) add(ev Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, ev)
+}
+
+func (l *eventLog) has(t EventType, name string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, ev := range l.events {
+		if ev.Type == t && ev.Member.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func newTestMember(t *testing.T, name string, loss float64, log *eventLog) *Memberlist {
+	t.Helper()
+
+	udp, err := NewUDPTransport("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var transport Transport = udp
+	if loss > 0 {
+		transport = &lossyTransport{Transport: udp, rnd: rand.New(rand.NewSource(int64(len(name)))), loss: loss}
+	}
+
+	conf := Config{
+		Name:             name,
+		Transport:        transport,
+		ProbeInterval:    50 * time.Millisecond,
+		ProbeTimeout:     20 * time.Millisecond,
+		SuspicionTimeout: 300 * time.Millisecond,
+	}
+	if log != nil {
+		conf.OnEvent = log.add
+	}
+
+	m, err := Create(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Shutdown() })
+
+	return m
+}
+
+func waitFor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timeout waiting for %s", what)
+}
+
+func memberNames(m *Memberlist) map[string]bool {
+	names := map[string]bool{}
+	for _, member := range m.Members() {
+		names[member.Name] = true
+	}
+	return names
+}
+
+func converged(list []*Memberlist, want ...string) func() bool {
+	return func() bool {
+		for _, m := range list {
+			names := memberNames(m)
+			if len(names) != len(want) {
+				return false
+			}
+			for _, name := range want {
+				if !names[name] {
+					return false
+				}
+			}
+		}
+		return true
+	}
+}
+
+func startCluster(t *testing.T, n int, loss float64, logs []*eventLog) ([]*Memberlist, []string) {
+	t.Helper()
+
+	list := make([]*Memberlist, n)
+	names := make([]string, n)
+	for i := 0; i < n; i += 1 {
+		names[i] = fmt.Sprintf("node-%d", i)
+		var log *eventLog
+		if logs != nil {
+			log = logs[i]
+		}
+		list[i] = newTestMember(t, names[i], loss, log)
+	}
+
+	for i := 1; i < n; i += 1 {
+		if _, err := list[i].Join(list[0].LocalMember().Addr); err != nil {
+			t.Fatalf("%s fail to join: %v", names[i], err)
+		}
+	}
+
+	return list, names
+}
+
+func TestMemberlist_Join(t *testing.T) {
+	logs := []*eventLog{{}, {}, {}, {}}
+	list, names := startCluster(t, 4, 0, logs)
+
+	waitFor(t, 3*time.Second, "membership to converge", converged(list, names...))
+
+	waitFor(t, time.Second, "join events", func() bool {
+		for i, log := range logs {
+			for j, name := range names {
+				if i != j && !log.has(EventJoin, name) {
+					return false
+				}
+			}
+		}
+		return true
+	})
+}
+
+func TestMemberlist_Fail(t *testing.T) {
+	logs := []*eventLog{{}, {}, {}}
+	list, names := startCluster(t, 3, 0, logs)
+	waitFor(t, 3*time.Second, "membership to converge", converged(list, names...))
+
+	// crash without saying goodbye
+	list[2].Shutdown()
+
+	waitFor(t, 5*time.Second, "failure to be detected", converged(list[:2], names[:2]...))
+	waitFor(t, time.Second, "fail events", func() bool {
+		return logs[0].has(EventFail, names[2]) && logs[1].has(EventFail, names[2])
+	})
+}
+
+func TestMemberlist_Leave(t *testing.T) {
+	logs := []*eventLog{{}, {}, {}}
+	list, names := startCluster(t, 3, 0, logs)
+	waitFor(t, 3*time.Second, "membership to converge", converged(list, names...))
+
+	if err := list[2].Leave(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, 3*time.Second, "leave to be disseminated", converged(list[:2], names[:2]...))
+	waitFor(t, time.Second, "leave events", func() bool {
+		return logs[0].has(EventLeave, names[2]) && logs[1].has(EventLeave, names[2])
+	})
+	for _, log := range logs[:2] {
+		if log.has(EventFail, names[2]) {
+			t.Fatalf("a member which left should not fail: %s", names[2])
+		}
+	}
+}
+
+func TestMemberlist_PacketLoss(t *testing.T) {
+	list, names := startCluster(t, 5, 0.2, nil)
+	waitFor(t, 5*time.Second, "membership to converge despite loss", converged(list, names...))
+
+	list[4].Shutdown()
+	waitFor(t, 10*time.Second, "failure to be detected despite loss", converged(list[:4], names[:4]...))
+}
+
+func TestMemberlist_Rejoin(t *testing.T) {
+	list, names := startCluster(t, 3, 0, nil)
+	waitFor(t, 3*time.Second, "membership to converge", converged(list, names...))
+
+	list[2].Shutdown()
+	waitFor(t, 5*time.Second, "failure to be detected", converged(list[:2], names[:2]...))
+
+	// the same member comes back with a fresh incarnation, it has to refute its death
+	back := newTestMember(t, names[2], 0, nil)
+	if _, err := back.Join(list[0].LocalMember().Addr); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, "member to rejoin", converged([]*Memberlist{list[0], list[1], back}, names...))
+}
+
+func TestBroadcastQueue_Take(t *testing.T) {
+	q := newBroadcastQueue()
+	q.push(update{Name: "a", Incarnation: 1})
+	q.push(update{Name: "b"})
+	// newer news about a replace the old ones
+	q.push(update{Name: "a", Incarnation: 2})
+
+	if q.len() != 2 {
+		t.Fatalf("we want 2 pending updates, got %d", q.len())
+	}
+
+	// with 9 members every update is sent 1 * log10(10) = 1 time
+	got := q.take(1, 1, 9)
+	if len(got) != 1 || got[0].Name != "a" || got[0].Incarnation != 2 {
+		t.Fatalf("unexpected updates %v", got)
+	}
+	got = q.take(8, 1, 9)
+	if len(got) != 1 || got[0].Name != "b" || q.len() != 0 {
+		t.Fatalf("unexpected updates %v", got)
+	}
+}
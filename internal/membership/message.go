@@ -0,0 +1,114 @@
+package membership
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+)
+
+type msgType uint8
+
+const (
+	pingMsg msgType = iota
+	ackMsg
+	pingReqMsg
+	syncMsg
+)
+
+// the only thing we send over the wire
+type message struct {
+	Type msgType `json:"t"`
+	Seq  uint32  `json:"s,omitempty"`
+	// the gossip address of the sender, acks are sent back to it
+	From string `json:"f"`
+	// the name of the sender
+	Node string `json:"o,omitempty"`
+	// ping: name of the member we expect to answer
+	// ping-req: gossip address of the member to probe
+	Target string `json:"g,omitempty"`
+	// sync: set if the receiver should answer with its own state
+	Join bool `json:"j,omitempty"`
+	// piggybacked gossip, or the whole member list for sync
+	Updates []update `json:"u,omitempty"`
+}
+
+// the state of a single member as we gossip it
+type update struct {
+	Name        string `json:"n"`
+	Addr        string `json:"a"`
+	State       State  `json:"st"`
+	Incarnation uint64 `json:"i"`
+}
+
+func encode(msg *message) ([]byte, error) {
+	return json.Marshal(msg)
+}
+
+func decode(b []byte) (*message, error) {
+	msg := &message{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+type broadcast struct {
+	u         update
+	transmits int
+}
+
+// a queue of updates to piggyback, newer updates about a member replace older ones
+type broadcastQueue struct {
+	items map[string]*broadcast
+}
+
+func newBroadcastQueue() *broadcastQueue {
+	return &broadcastQueue{items: map[string]*broadcast{}}
+}
+
+func (q *broadcastQueue) push(u update) {
+	q.items[u.Name] = &broadcast{u: u}
+}
+
+// take up to limit updates, the least transmitted first
+// an update is dropped after it was sent retransmitMult * log(n + 1) times
+func (q *broadcastQueue) take(limit int, retransmitMult int, n int) []update {
+	if len(q.items) == 0 {
+		return nil
+	}
+
+	pending := make([]*broadcast, 0, len(q.items))
+	for _, b := range q.items {
+		pending = append(pending, b)
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		if pending[i].transmits != pending[j].transmits {
+			return pending[i].transmits < pending[j].transmits
+		}
+		return pending[i].u.Name < pending[j].u.Name
+	})
+
+	maxTransmits := retransmitMult * int(math.Ceil(math.Log10(float64(n+1))))
+	if maxTransmits < 1 {
+		maxTransmits = 1
+	}
+
+	out := make([]update, 0, limit)
+	for _, b := range pending {
+		if len(out) == limit {
+			break
+		}
+		out = append(out, b.u)
+		b.transmits += 1
+		if b.transmits >= maxTransmits {
+			delete(q.items, b.u.Name)
+		}
+	}
+
+	return out
+}
+
+func (q *broadcastQueue) len() int {
+	return len(q.items)
+}
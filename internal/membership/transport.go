@@ -0,0 +1,113 @@
+package membership
+
+import (
+	"net"
+	"sync"
+)
+
+// the largest datagram we read, messages are kept well below it
+const maxPacketSize = 65536
+
+type Packet struct {
+	Buf  []byte
+	From string
+}
+
+// a transport moves whole messages between members, it may lose or reorder them
+type Transport interface {
+	WriteTo(b []byte, addr string) error
+	PacketCh() <-chan *Packet
+	Addr() string
+	Close() error
+}
+
+type UDPTransport struct {
+	conn    net.PacketConn
+	packets chan *Packet
+
+	mu    sync.Mutex
+	addrs map[string]*net.UDPAddr
+}
+
+func NewUDPTransport(bindAddr string) (*UDPTransport, error) {
+	conn, err := net.ListenPacket("udp", bindAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &UDPTransport{
+		conn:    conn,
+		packets: make(chan *Packet, 1024),
+		mu:      sync.Mutex{},
+		addrs:   map[string]*net.UDPAddr{},
+	}
+	go t.listen()
+
+	return t, nil
+}
+
+func (t *UDPTransport) listen() {
+	defer close(t.packets)
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, from, err := t.conn.ReadFrom(buf)
+		if err != nil {
+			// the connection is closed
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return
+		}
+
+		b := make([]byte, n)
+		copy(b, buf[:n])
+
+		select {
+		case t.packets <- &Packet{Buf: b, From: from.String()}:
+		default:
+			// we are too slow, drop it like the network would
+		}
+	}
+}
+
+func (t *UDPTransport) resolve(addr string) (*net.UDPAddr, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if a, ok := t.addrs[addr]; ok {
+		return a, nil
+	}
+
+	a, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	t.addrs[addr] = a
+
+	return a, nil
+}
+
+func (t *UDPTransport) WriteTo(b []byte, addr string) error {
+	a, err := t.resolve(addr)
+	if err != nil {
+		return err
+	}
+
+	_, err = t.conn.WriteTo(b, a)
+	return err
+}
+
+func (t *UDPTransport) PacketCh() <-chan *Packet {
+	return t.packets
+}
+
+func (t *UDPTransport) Addr() string {
+	return t.conn.LocalAddr().String()
+}
+
+func (t *UDPTransport) Close() error {
+	return t.conn.Close()
+}
+
+var _ Transport = (*UDPTransport)(nil)
@@ -0,0 +1,279 @@
+package memcache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golrice/e-fis/internal/cache"
+)
+
+func validKey(key string) bool {
+	if len(key) == 0 || len(key) > maxKeyLen {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// dispatch runs a single command, it returns true if the client wants to quit
+func (s *Server) dispatch(r *bufio.Reader, w *bufio.Writer, line []byte) bool {
+	fields := strings.Fields(string(line))
+	if len(fields) == 0 {
+		w.WriteString("ERROR\r\n")
+		return false
+	}
+
+	switch cmd := fields[0]; cmd {
+	case "get", "gets":
+		s.get(w, fields[1:], cmd == "gets")
+	case "set", "add", "replace":
+		s.store(r, w, cmd, fields[1:])
+	case "delete":
+		s.delete(w, fields[1:])
+	case "touch":
+		s.touch(w, fields[1:])
+	case "stats":
+		s.writeStats(w, fields[1:])
+	case "version":
+		w.WriteString("VERSION " + version + "\r\n")
+	case "verbosity":
+		reply(w, noreply(fields), "OK")
+	case "quit":
+		return true
+	default:
+		s.stats.Inc("unknown_commands")
+		w.WriteString("ERROR\r\n")
+	}
+
+	return false
+}
+
+func noreply(fields []string) bool {
+	return len(fields) > 0 && fields[len(fields)-1] == "noreply"
+}
+
+func reply(w *bufio.Writer, quiet bool, msg string) {
+	if !quiet {
+		w.WriteString(msg + "\r\n")
+	}
+}
+
+// lookup reads a key, ok is false if it does not exist
+func (s *Server) lookup(key string) (node *cache.Node, v cache.ByteView, ok bool, err error) {
+	node, err = s.node()
+	if err != nil {
+		return
+	}
+
+	v, err = node.Get(key)
+	if errors.Is(err, cache.ErrNotFound) {
+		return node, v, false, nil
+	}
+
+	return node, v, err == nil, err
+}
+
+// get <key>*, keys which fail to load are left out like misses
+func (s *Server) get(w *bufio.Writer, keys []string, cas bool) {
+	if len(keys) == 0 {
+		w.WriteString("ERROR\r\n")
+		return
+	}
+
+	for _, key := range keys {
+		if !validKey(key) {
+			w.WriteString("CLIENT_ERROR bad command line format\r\n")
+			return
+		}
+	}
+
+	for _, key := range keys {
+		s.stats.Inc("cmd_get")
+		_, v, ok, err := s.lookup(key)
+		if err != nil || !ok {
+			s.stats.Inc("get_misses")
+			continue
+		}
+		s.stats.Inc("get_hits")
+
+		raw := v.ByteSlice()
+		flags, data := decode(raw)
+		if cas {
+			fmt.Fprintf(w, "VALUE %s %d %d %d\r\n", key, flags, len(data), casOf(raw))
+		} else {
+			fmt.Fprintf(w, "VALUE %s %d %d\r\n", key, flags, len(data))
+		}
+		w.Write(data)
+		w.WriteString("\r\n")
+	}
+	w.WriteString("END\r\n")
+}
+
+// <cmd> <key> <flags> <exptime> <bytes> [noreply], followed by the data block
+func (s *Server) store(r *bufio.Reader, w *bufio.Writer, cmd string, args []string) {
+	if len(args) != 4 && len(args) != 5 {
+		w.WriteString("ERROR\r\n")
+		return
+	}
+	quiet := len(args) == 5 && args[4] == "noreply"
+
+	key := args[0]
+	flags, err1 := strconv.ParseUint(args[1], 10, 32)
+	exptime, err2 := strconv.ParseInt(args[2], 10, 64)
+	size, err3 := strconv.Atoi(args[3])
+	if err3 != nil || size < 0 {
+		// we do not know how much data follows, so we can not skip it
+		w.WriteString("CLIENT_ERROR bad command line format\r\n")
+		return
+	}
+
+	// the data is skipped, not read, so that a client can not make us allocate what it claims
+	if size > s.opts.MaxItemSize {
+		if _, err := io.CopyN(io.Discard, r, int64(size)+2); err != nil {
+			return
+		}
+		w.WriteString("SERVER_ERROR object too large for cache\r\n")
+		return
+	}
+
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return
+	}
+	if string(data[size:]) != "\r\n" {
+		w.WriteString("CLIENT_ERROR bad data chunk\r\n")
+		return
+	}
+	data = data[:size]
+
+	if !validKey(key) || err1 != nil || err2 != nil || (len(args) == 5 && !quiet) {
+		w.WriteString("CLIENT_ERROR bad command line format\r\n")
+		return
+	}
+	s.stats.Inc("cmd_set")
+	node, _, exists, err := s.lookup(key)
+	if err != nil && node == nil {
+		reply(w, quiet, "SERVER_ERROR "+err.Error())
+		return
+	}
+	if (cmd == "add" && exists) || (cmd == "replace" && !exists) {
+		reply(w, quiet, "NOT_STORED")
+		return
+	}
+
+	ttl, expired := ttlOf(exptime, time.Now())
+	if expired {
+		// stored and gone at once
+		err = node.Delete(key)
+	} else {
+		err = node.Set(key, encode(uint32(flags), data), ttl)
+	}
+	if err != nil {
+		reply(w, quiet, "SERVER_ERROR "+err.Error())
+		return
+	}
+	reply(w, quiet, "STORED")
+}
+
+// delete <key> [0] [noreply]
+func (s *Server) delete(w *bufio.Writer, args []string) {
+	quiet := noreply(args)
+	if quiet {
+		args = args[:len(args)-1]
+	}
+	if len(args) == 2 && args[1] == "0" {
+		args = args[:1]
+	}
+	if len(args) != 1 || !validKey(args[0]) {
+		w.WriteString("CLIENT_ERROR bad command line format. Usage: delete <key> [noreply]\r\n")
+		return
+	}
+
+	s.stats.Inc("cmd_delete")
+	node, _, ok, err := s.lookup(args[0])
+	if err != nil && node == nil {
+		reply(w, quiet, "SERVER_ERROR "+err.Error())
+		return
+	}
+	if !ok {
+		reply(w, quiet, "NOT_FOUND")
+		return
+	}
+	if err := node.Delete(args[0]); err != nil {
+		reply(w, quiet, "SERVER_ERROR "+err.Error())
+		return
+	}
+	reply(w, quiet, "DELETED")
+}
+
+// touch <key> <exptime> [noreply], the value and its tags are stored again with the new ttl
+func (s *Server) touch(w *bufio.Writer, args []string) {
+	quiet := noreply(args)
+	if quiet {
+		args = args[:len(args)-1]
+	}
+	if len(args) != 2 || !validKey(args[0]) {
+		w.WriteString("ERROR\r\n")
+		return
+	}
+	exptime, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		w.WriteString("CLIENT_ERROR invalid exptime argument\r\n")
+		return
+	}
+
+	s.stats.Inc("cmd_touch")
+	node, v, ok, err := s.lookup(args[0])
+	if err != nil && node == nil {
+		reply(w, quiet, "SERVER_ERROR "+err.Error())
+		return
+	}
+	if !ok {
+		reply(w, quiet, "NOT_FOUND")
+		return
+	}
+
+	ttl, expired := ttlOf(exptime, time.Now())
+	if expired {
+		err = node.Delete(args[0])
+	} else {
+		err = node.Set(args[0], v.ByteSlice(), ttl, node.Tags(args[0])...)
+	}
+	if err != nil {
+		reply(w, quiet, "SERVER_ERROR "+err.Error())
+		return
+	}
+	reply(w, quiet, "TOUCHED")
+}
+
+// only the general statistics, sub sections are empty
+func (s *Server) writeStats(w *bufio.Writer, args []string) {
+	if len(args) > 0 {
+		w.WriteString("END\r\n")
+		return
+	}
+
+	now := time.Now()
+	fmt.Fprintf(w, "STAT pid %d\r\n", os.Getpid())
+	fmt.Fprintf(w, "STAT uptime %d\r\n", int64(now.Sub(s.started).Seconds()))
+	fmt.Fprintf(w, "STAT time %d\r\n", now.Unix())
+	fmt.Fprintf(w, "STAT version %s\r\n", version)
+	fmt.Fprintf(w, "STAT curr_connections %v\r\n", s.stats.Snapshot()["curr_connections"])
+	for _, name := range []string{"total_connections", "cmd_get", "cmd_set", "cmd_delete", "cmd_touch", "get_hits", "get_misses"} {
+		fmt.Fprintf(w, "STAT %s %d\r\n", name, s.stats.Counter(name))
+	}
+	if node, err := s.node(); err == nil {
+		fmt.Fprintf(w, "STAT curr_items %d\r\n", len(node.Keys()))
+	}
+	w.WriteString("END\r\n")
+}
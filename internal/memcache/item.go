@@ -0,0 +1,48 @@
+package memcache
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"time"
+)
+
+// exptimes above this are unix timestamps, below it they are seconds from now
+const relativeExptimeLimit = 60 * 60 * 24 * 30
+
+// the client flags are stored in front of the data, so that they travel with the value between peers
+func encode(flags uint32, data []byte) []byte {
+	b := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(b, flags)
+	copy(b[4:], data)
+	return b
+}
+
+// a value which was not stored by us has no flags
+func decode(b []byte) (uint32, []byte) {
+	if len(b) < 4 {
+		return 0, b
+	}
+	return binary.BigEndian.Uint32(b), b[4:]
+}
+
+// the ttl of an exptime, expired is true if the item is gone right away
+func ttlOf(exptime int64, now time.Time) (ttl time.Duration, expired bool) {
+	switch {
+	case exptime == 0:
+		return 0, false
+	case exptime < 0:
+		return 0, true
+	case exptime > relativeExptimeLimit:
+		ttl = time.Unix(exptime, 0).Sub(now)
+		return ttl, ttl <= 0
+	}
+
+	return time.Duration(exptime) * time.Second, false
+}
+
+// we keep no cas counter, a hash of the stored value changes whenever the value does
+func casOf(b []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(b)
+	return h.Sum64()
+}
@@ -0,0 +1,180 @@
+package memcache
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/golrice/e-fis/internal/cache"
+	"github.com/golrice/e-fis/internal/stats"
+)
+
+const (
+	version            = "1.6.0-efis"
+	defaultMaxItemSize = 1 << 20
+	maxKeyLen          = 250
+)
+
+var ErrServerClosed = errors.New("memcache: server closed")
+
+type Options struct {
+	// the namespace which backs the bucket, it must hold nothing but what memcached clients store
+	Namespace string
+	// larger values are refused, 1mb like memcached if 0
+	MaxItemSize int
+}
+
+// server speaks the memcached text protocol on top of a single namespace
+type Server struct {
+	graph   *cache.Graph
+	opts    Options
+	stats   *stats.Registry
+	started time.Time
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+func NewServer(graph *cache.Graph, opts Options) *Server {
+	if opts.MaxItemSize <= 0 {
+		opts.MaxItemSize = defaultMaxItemSize
+	}
+
+	s := &Server{
+		graph:   graph,
+		opts:    opts,
+		stats:   stats.New(),
+		started: time.Now(),
+		conns:   make(map[net.Conn]struct{}),
+	}
+	s.stats.Gauge("curr_connections", func() any {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.conns)
+	})
+
+	return s
+}
+
+func (s *Server) Stats() map[string]any {
+	return s.stats.Snapshot()
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(l)
+}
+
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listener = l
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		s.stats.Inc("total_connections")
+		go s.serveConn(conn)
+	}
+}
+
+// close stops listening and drops all connections
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+
+	return err
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		s.wg.Done()
+	}()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+
+	for {
+		line, err := r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			w.WriteString("CLIENT_ERROR line too long\r\n")
+			w.Flush()
+			return
+		}
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				log.Printf("[Memcache %s] %s", conn.RemoteAddr(), err.Error())
+			}
+			return
+		}
+
+		if quit := s.dispatch(r, w, line); quit {
+			w.Flush()
+			return
+		}
+
+		// answer a pipeline in one go
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) node() (*cache.Node, error) {
+	node, err := cache.GetNode(s.graph, s.opts.Namespace)
+	if err != nil {
+		return nil, errors.New("no such namespace " + s.opts.Namespace)
+	}
+	return node, nil
+}
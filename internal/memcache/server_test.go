@@ -0,0 +1,166 @@
+package memcache
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golrice/e-fis/internal/cache"
+)
+
+type conn struct {
+	t     *testing.T
+	c     net.Conn
+	r     *bufio.Reader
+	graph *cache.Graph
+}
+
+func startServer(t *testing.T) *conn {
+	t.Helper()
+
+	graph := cache.DefaultGraph()
+	graph.AddNode(cache.NewNode("memcache", 2<<10, func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("%s: %w", key, cache.ErrNotFound)
+	}))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(graph, Options{Namespace: "memcache", MaxItemSize: 16})
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &conn{t: t, c: c, r: bufio.NewReader(c), graph: graph}
+}
+
+// expect sends the request and reads as many lines as the wanted reply has
+func (c *conn) expect(request string, want ...string) {
+	c.t.Helper()
+
+	if _, err := c.c.Write([]byte(request)); err != nil {
+		c.t.Fatal(err)
+	}
+	c.c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for _, w := range want {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			c.t.Fatalf("%q: %v", request, err)
+		}
+		if got := strings.TrimRight(line, "\r\n"); got != w {
+			c.t.Fatalf("%q: got %q, want %q", request, got, w)
+		}
+	}
+}
+
+func TestServer_Storage(t *testing.T) {
+	c := startServer(t)
+
+	c.expect("get a\r\n", "END")
+	c.expect("set a 42 0 5\r\nhello\r\n", "STORED")
+	c.expect("get a b\r\n", "VALUE a 42 5", "hello", "END")
+	c.expect("add a 0 0 1\r\nx\r\n", "NOT_STORED")
+	c.expect("replace b 0 0 1\r\nx\r\n", "NOT_STORED")
+	c.expect("add b 7 0 1\r\nx\r\n", "STORED")
+	c.expect("replace b 8 0 1\r\ny\r\n", "STORED")
+	c.expect("get b\r\n", "VALUE b 8 1", "y", "END")
+	c.expect("delete b\r\n", "DELETED")
+	c.expect("delete b\r\n", "NOT_FOUND")
+
+	// noreply answers nothing, the next command proves it
+	c.expect("set c 0 0 1 noreply\r\nz\r\nget c\r\n", "VALUE c 0 1", "z", "END")
+
+	c.expect("set big 0 0 17\r\n01234567890123456\r\n", "SERVER_ERROR object too large for cache")
+	c.expect("set a 0 0 2\r\nabc\r\n", "CLIENT_ERROR bad data chunk")
+	c.expect("nope\r\n", "ERROR")
+}
+
+func TestServer_Expire(t *testing.T) {
+	c := startServer(t)
+
+	c.expect("set a 0 1 1\r\nx\r\n", "STORED")
+	c.expect("set b 0 -1 1\r\nx\r\n", "STORED")
+	c.expect("get b\r\n", "END")
+
+	c.expect("touch a 0\r\n", "TOUCHED")
+	c.expect("touch nope 10\r\n", "NOT_FOUND")
+
+	// the tags stay with the value
+	node, _ := cache.GetNode(c.graph, "memcache")
+	node.Set("t", []byte("x"), 0, "red")
+	c.expect("touch t 100\r\n", "TOUCHED")
+	if tags := node.Tags("t"); len(tags) != 1 || tags[0] != "red" {
+		t.Fatalf("tags = %v", tags)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	c.expect("get a\r\n", "VALUE a 0 1", "x", "END")
+
+	// an absolute exptime in the past
+	c.expect(fmt.Sprintf("set d 0 %d 1\r\nx\r\n", time.Now().Add(-time.Hour).Unix()), "STORED")
+	c.expect("get d\r\n", "END")
+}
+
+func TestServer_GetsAndStats(t *testing.T) {
+	c := startServer(t)
+
+	c.expect("set a 0 0 1\r\nx\r\n", "STORED")
+	if _, err := c.c.Write([]byte("gets a\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	line, _ := c.r.ReadString('\n')
+	var key string
+	var flags, size int
+	var cas uint64
+	if n, _ := fmt.Sscanf(line, "VALUE %s %d %d %d", &key, &flags, &size, &cas); n != 4 || cas == 0 {
+		t.Fatalf("gets: got %q", line)
+	}
+	c.expect("", "x", "END")
+
+	c.c.Write([]byte("stats\r\n"))
+	var hits bool
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line == "STAT get_hits 1\r\n" {
+			hits = true
+		}
+		if line == "END\r\n" {
+			break
+		}
+	}
+	if !hits {
+		t.Fatal("stats should count the hit")
+	}
+
+	c.expect("version\r\n", "VERSION "+version)
+}
+
+func TestTTLOf(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	cases := []struct {
+		exptime int64
+		ttl     time.Duration
+		expired bool
+	}{
+		{0, 0, false},
+		{10, 10 * time.Second, false},
+		{-1, 0, true},
+		{now.Unix() + 60, time.Minute, false},
+		{now.Unix() - 60, -time.Minute, true},
+	}
+	for _, c := range cases {
+		if ttl, expired := ttlOf(c.exptime, now); ttl != c.ttl || expired != c.expired {
+			t.Errorf("ttlOf(%d) = %v, %v, want %v, %v", c.exptime, ttl, expired, c.ttl, c.expired)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package mrc
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/golrice/e-fis/internal/keyhash"
+)
+
+// an estimator follows the lru hit ratio of a cache at every capacity at once,
+// see Waldspurger et al., Efficient MRC Construction with SHARDS
+//
+// only keys whose hash falls below a threshold are tracked, for them the reuse
+// distance, the bytes of the distinct keys read since the last read of the key,
+// is kept exactly with a fenwick tree and scaled up by the sample rate
+//
+// a few hot keys decide much of the hit ratio, whether they are sampled or not skews the
+// estimate, like SHARDS-adj the difference between the sampled reads and the expected
+// number of sampled reads is counted as hits at the smallest distance
+//
+// a sampled read stands for 1/rate reads, it is counted with that weight at the rate it was
+// taken at, so the reads from before the rate was halved do not outweigh the later ones
+const (
+	defaultSampleRate = 0.01
+	defaultMaxKeys    = 8192
+	// buckets per power of two of the distance
+	bucketsPerOctave = 4
+	minTreeSize      = 1024
+)
+
+type Options struct {
+	// the fraction of the keys which are tracked, 0.01 by default
+	SampleRate float64
+	// at most this many keys are tracked, the rate is halved whenever there are more
+	MaxKeys int
+}
+
+type entry struct {
+	time int
+	size int64
+	hash uint64
+}
+
+type Estimator struct {
+	// a key is tracked if its hash is at most threshold, it is checked before taking the lock
+	threshold atomic.Uint64
+	// every read
+	seen atomic.Int64
+
+	mu      sync.Mutex
+	rate    float64
+	maxKeys int
+
+	last map[string]entry
+	tree *fenwick
+	// the next timestamp, timestamps start at 1
+	now int
+
+	// the weight of the reads of a tracked key seen before, by bucket of the scaled reuse distance
+	hist []float64
+	// reads of a tracked key, the first read of a key always misses
+	refs int64
+	// the weight of those reads, the reads they stand for
+	weight float64
+}
+
+func New(opts Options) *Estimator {
+	if opts.SampleRate <= 0 || opts.SampleRate > 1 {
+		opts.SampleRate = defaultSampleRate
+	}
+	if opts.MaxKeys <= 0 {
+		opts.MaxKeys = defaultMaxKeys
+	}
+
+	e := &Estimator{
+		rate:    opts.SampleRate,
+		maxKeys: opts.MaxKeys,
+		last:    make(map[string]entry),
+		tree:    newFenwick(minTreeSize),
+		now:     1,
+	}
+	e.threshold.Store(thresholdOf(opts.SampleRate))
+
+	return e
+}
+
+func thresholdOf(rate float64) uint64 {
+	if rate >= 1 {
+		return math.MaxUint64
+	}
+	return uint64(rate * math.MaxUint64)
+}
+
+// access records a read of key, size is what it takes in the cache
+func (e *Estimator) Access(key string, size int64) {
+	e.seen.Add(1)
+	h := keyhash.Sum(key)
+	if h > e.threshold.Load() {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// the rate may have been halved in the meantime
+	if h > e.threshold.Load() {
+		return
+	}
+
+	e.refs += 1
+	e.weight += 1 / e.rate
+	if old, ok := e.last[key]; ok {
+		// the distinct keys read after the last read of this one, scaled up, and the key itself
+		d := e.tree.sum(e.now-1) - e.tree.sum(old.time)
+		e.count(float64(d)/e.rate + float64(size))
+		e.tree.add(old.time, -old.size)
+		delete(e.last, key)
+	}
+
+	if e.now >= e.tree.len() {
+		e.compact()
+	}
+	e.tree.add(e.now, size)
+	e.last[key] = entry{time: e.now, size: size, hash: h}
+	e.now += 1
+
+	if len(e.last) > e.maxKeys {
+		e.shrink()
+	}
+}
+
+// remove forgets a key, e.g. when it is deleted, its next read is a cold miss
+func (e *Estimator) Remove(key string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if old, ok := e.last[key]; ok {
+		e.tree.add(old.time, -old.size)
+		delete(e.last, key)
+	}
+}
+
+func bucketOf(d float64) int {
+	if d <= 1 {
+		return 0
+	}
+	return int(math.Ceil(math.Log2(d) * bucketsPerOctave))
+}
+
+// the largest distance of a bucket
+func bucketLimit(i int) float64 {
+	return math.Exp2(float64(i) / bucketsPerOctave)
+}
+
+func (e *Estimator) count(d float64) {
+	i := bucketOf(d)
+	for len(e.hist) <= i {
+		e.hist = append(e.hist, 0)
+	}
+	e.hist[i] += 1 / e.rate
+}
+
+// renumber the live timestamps from 1, keeping their order, the tree gets room for as many again
+func (e *Estimator) compact() {
+	keys := make([]string, 0, len(e.last))
+	for key := range e.last {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return e.last[keys[i]].time < e.last[keys[j]].time })
+
+	e.tree = newFenwick(max(2*len(keys)+2, minTreeSize))
+	for i, key := range keys {
+		old := e.last[key]
+		old.time = i + 1
+		e.last[key] = old
+		e.tree.add(old.time, old.size)
+	}
+	e.now = len(keys) + 1
+}
+
+// halve the sample rate and forget the keys which are not sampled anymore
+func (e *Estimator) shrink() {
+	e.rate /= 2
+	threshold := thresholdOf(e.rate)
+	e.threshold.Store(threshold)
+
+	for key, old := range e.last {
+		if old.hash > threshold {
+			e.tree.add(old.time, -old.size)
+			delete(e.last, key)
+		}
+	}
+}
+
+// the estimated lru hit ratio of a cache of capacity bytes
+func (e *Estimator) HitRatio(capacity int64) float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.hitRatioLocked(capacity)
+}
+
+func (e *Estimator) hitRatioLocked(capacity int64) float64 {
+	reads := float64(e.seen.Load())
+	if e.refs == 0 || reads <= 0 {
+		return 0
+	}
+
+	hits := reads - e.weight
+	for i, w := range e.hist {
+		if bucketLimit(i) > float64(capacity) {
+			break
+		}
+		hits += w
+	}
+	return min(max(hits/reads, 0), 1)
+}
+
+type Point struct {
+	Capacity int64   `json:"capacity"`
+	HitRatio float64 `json:"hit_ratio"`
+}
+
+func (e *Estimator) Curve(capacities []int64) []Point {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	points := make([]Point, len(capacities))
+	for i, c := range capacities {
+		points[i] = Point{Capacity: c, HitRatio: e.hitRatioLocked(c)}
+	}
+	return points
+}
+
+// the estimated bytes of all keys read so far, the capacity at which only cold misses are left
+func (e *Estimator) WorkingSet() int64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return int64(float64(e.tree.sum(e.now-1)) / e.rate)
+}
+
+// the current sample rate and the number of sampled reads
+func (e *Estimator) Sampling() (rate float64, refs int64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.rate, e.refs
+}
+
+// fenwick sums the sizes kept at the timestamps
+type fenwick struct {
+	t []int64
+}
+
+func newFenwick(n int) *fenwick {
+	return &fenwick{t: make([]int64, n+1)}
+}
+
+// the largest timestamp it holds, plus one
+func (f *fenwick) len() int {
+	return len(f.t)
+}
+
+func (f *fenwick) add(i int, delta int64) {
+	for ; i < len(f.t); i += i & -i {
+		f.t[i] += delta
+	}
+}
+
+// the sum of 1..i
+func (f *fenwick) sum(i int) int64 {
+	var s int64
+	for ; i > 0; i -= i & -i {
+		s += f.t[i]
+	}
+	return s
+}
@@ -0,0 +1,125 @@
+package mrc
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/golrice/e-fis/internal/cache/lru"
+)
+
+func TestEstimator_Loop(t *testing.T) {
+	// a loop over 100 keys of 10 bytes, lru hits all of them once they fit
+	e := New(Options{SampleRate: 1})
+	for round := 0; round < 10; round += 1 {
+		for i := 0; i < 100; i += 1 {
+			e.Access(fmt.Sprint(i), 10)
+		}
+	}
+
+	if r := e.HitRatio(500); r != 0 {
+		t.Fatalf("half of the loop never hits, got %v", r)
+	}
+	if r := e.HitRatio(2000); r != 0.9 {
+		t.Fatalf("the whole loop only misses cold, got %v", r)
+	}
+	if ws := e.WorkingSet(); ws != 1000 {
+		t.Fatalf("want a working set of 1000, got %d", ws)
+	}
+
+	e.Remove("0")
+	if ws := e.WorkingSet(); ws != 990 {
+		t.Fatalf("a removed key should be forgotten, got %d", ws)
+	}
+}
+
+type size int
+
+func (s size) Len() int { return int(s) }
+
+// the estimate from a sample of a zipf workload against a real lru cache
+func TestEstimator_AgainstLRU(t *testing.T) {
+	const keys = 20000
+	r := rand.New(rand.NewSource(1))
+	zipf := rand.NewZipf(r, 1.1, 1, keys-1)
+	trace := make([]string, 300000)
+	for i := range trace {
+		trace[i] = fmt.Sprint("key", zipf.Uint64())
+	}
+
+	// a fixed rate, and one which is halved a few times along the way
+	fixed := New(Options{SampleRate: 0.1, MaxKeys: 1 << 20})
+	shrunk := New(Options{SampleRate: 1, MaxKeys: 2000})
+	for _, key := range trace {
+		fixed.Access(key, int64(len(key))+100)
+		shrunk.Access(key, int64(len(key))+100)
+	}
+	if rate, _ := shrunk.Sampling(); rate > 0.2 {
+		t.Fatalf("the rate should have been halved, got %v", rate)
+	}
+
+	// a few hundred keys and up, a sample tells little about the very hottest keys
+	for _, capacity := range []int64{50000, 200000, 1000000} {
+		cache := lru.New(capacity, nil)
+		hits := 0
+		for _, key := range trace {
+			if _, ok := cache.Get(key); ok {
+				hits += 1
+				continue
+			}
+			cache.Add(key, size(100))
+		}
+
+		want := float64(hits) / float64(len(trace))
+		for _, e := range []*Estimator{fixed, shrunk} {
+			if got := e.HitRatio(capacity); math.Abs(got-want) > 0.05 {
+				t.Fatalf("capacity %d at rate %v: want about %.3f, got %.3f", capacity, e.rate, want, got)
+			}
+		}
+	}
+}
+
+// the reads before the rate was halved must not outweigh those after it
+func TestEstimator_ShrinkWeights(t *testing.T) {
+	e := New(Options{SampleRate: 1, MaxKeys: 500})
+	// a small working set which always hits, then a scan which always misses
+	for i := 0; i < 20000; i += 1 {
+		e.Access(fmt.Sprint("hot", i%100), 1)
+	}
+	for i := 0; i < 80000; i += 1 {
+		e.Access(fmt.Sprint("cold", i%20000), 1)
+	}
+
+	// 19900 hits out of 100000 reads
+	if got := e.HitRatio(1000); math.Abs(got-0.199) > 0.05 {
+		t.Fatalf("want about 0.199, got %.3f", got)
+	}
+}
+
+func TestEstimator_Shrink(t *testing.T) {
+	e := New(Options{SampleRate: 1, MaxKeys: 100})
+	for i := 0; i < 1000; i += 1 {
+		e.Access(fmt.Sprint(i), 1)
+	}
+
+	rate, refs := e.Sampling()
+	if rate >= 0.2 || refs == 0 {
+		t.Fatalf("the rate should drop to keep 100 keys, got %v", rate)
+	}
+	if len(e.last) > 100 {
+		t.Fatalf("want at most 100 keys, got %d", len(e.last))
+	}
+
+	// the tree is compacted along the way and still adds up
+	for i := 0; i < 5000; i += 1 {
+		e.Access(fmt.Sprint(i%50), 1)
+	}
+	var sum int64
+	for _, old := range e.last {
+		sum += old.size
+	}
+	if e.tree.sum(e.now-1) != sum {
+		t.Fatalf("the tree should hold %d, got %d", sum, e.tree.sum(e.now-1))
+	}
+}
@@ -0,0 +1,362 @@
+package peer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	pb "github.com/golrice/e-fis/internal/protocal"
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+const (
+	defaultBreakerWindow      = 20
+	defaultBreakerMinRequests = 10
+	defaultBreakerErrorRate   = 0.5
+	defaultBreakerSlowCall    = time.Second
+	defaultBreakerSlowRate    = 0.8
+	defaultBreakerOpenTimeout = 5 * time.Second
+	defaultBreakerProbes      = 3
+)
+
+type BreakerState int
+
+const (
+	StateClosed BreakerState = iota
+	StateOpen
+	StateHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+type BreakerOptions struct {
+	// the outcomes of the last Window calls are kept
+	Window int
+	// the window must hold this many outcomes before the breaker may open
+	MinRequests int
+	// open when the share of failed calls reaches it
+	ErrorRate float64
+	// a call slower than this counts as slow
+	SlowCall time.Duration
+	// open when the share of slow calls reaches it
+	SlowRate float64
+	// how long the breaker stays open before it lets probes through
+	OpenTimeout time.Duration
+	// successful probes needed to close again, it is also the number of probes in flight
+	HalfOpenProbes int
+	// called without the lock held
+	OnChange func(name string, from, to BreakerState)
+}
+
+func (o *BreakerOptions) fill() {
+	if o.Window <= 0 {
+		o.Window = defaultBreakerWindow
+	}
+	if o.MinRequests <= 0 {
+		o.MinRequests = defaultBreakerMinRequests
+	}
+	if o.MinRequests > o.Window {
+		o.MinRequests = o.Window
+	}
+	if o.ErrorRate <= 0 {
+		o.ErrorRate = defaultBreakerErrorRate
+	}
+	if o.SlowCall <= 0 {
+		o.SlowCall = defaultBreakerSlowCall
+	}
+	if o.SlowRate <= 0 {
+		o.SlowRate = defaultBreakerSlowRate
+	}
+	if o.OpenTimeout <= 0 {
+		o.OpenTimeout = defaultBreakerOpenTimeout
+	}
+	if o.HalfOpenProbes <= 0 {
+		o.HalfOpenProbes = defaultBreakerProbes
+	}
+}
+
+type BreakerStatus struct {
+	Name     string    `json:"name"`
+	State    string    `json:"state"`
+	Requests int       `json:"requests"`
+	Failures int       `json:"failures"`
+	Slow     int       `json:"slow"`
+	OpenedAt time.Time `json:"opened_at,omitempty"`
+}
+
+type outcome struct {
+	failed bool
+	slow   bool
+}
+
+// breaker guards a peergetter, it fails fast while the peer is failing or slow
+type Breaker struct {
+	name   string
+	getter PeerGetter
+	opts   BreakerOptions
+
+	mu       sync.Mutex
+	state    BreakerState
+	window   []outcome
+	next     int
+	openedAt time.Time
+	inflight int
+	probes   int
+	now      func() time.Time
+}
+
+func NewBreaker(name string, getter PeerGetter, opts BreakerOptions) *Breaker {
+	opts.fill()
+
+	return &Breaker{
+		name:   name,
+		getter: getter,
+		opts:   opts,
+		window: make([]outcome, 0, opts.Window),
+		now:    time.Now,
+	}
+}
+
+func (b *Breaker) Name() string {
+	return b.name
+}
+
+func (b *Breaker) Get(in *pb.Request, out *pb.Response) error {
+	return b.GetContext(context.Background(), in, out)
+}
+
+func (b *Breaker) GetContext(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	if !b.acquire() {
+		return ErrCircuitOpen
+	}
+
+	start := b.now()
+	var err error
+	if cg, ok := b.getter.(ContextGetter); ok {
+		err = cg.GetContext(ctx, in, out)
+	} else {
+		err = b.getter.Get(in, out)
+	}
+
+	// we gave up on the call ourselves, it says nothing about the peer
+	if ctx.Err() != nil {
+		b.release()
+		return err
+	}
+	b.record(err, b.now().Sub(start))
+
+	return err
+}
+
+// writes go straight to the peer, the breaker only judges reads
+func (b *Breaker) Set(in *pb.SetRequest) error {
+	setter, ok := b.getter.(PeerSetter)
+	if !ok {
+		return fmt.Errorf("peer %s can not store entries", b.name)
+	}
+	return setter.Set(in)
+}
+
+func (b *Breaker) Delete(in *pb.Request) error {
+	deleter, ok := b.getter.(PeerDeleter)
+	if !ok {
+		return fmt.Errorf("peer %s can not delete entries", b.name)
+	}
+	return deleter.Delete(in)
+}
+
+// allow tells whether a call would be let through right now, it changes nothing
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case StateOpen:
+		return b.now().Sub(b.openedAt) >= b.opts.OpenTimeout
+	case StateHalfOpen:
+		return b.inflight < b.opts.HalfOpenProbes
+	}
+	return true
+}
+
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+func (b *Breaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := BreakerStatus{
+		Name:     b.name,
+		State:    b.state.String(),
+		Requests: len(b.window),
+	}
+	for _, o := range b.window {
+		if o.failed {
+			s.Failures += 1
+		}
+		if o.slow {
+			s.Slow += 1
+		}
+	}
+	if b.state != StateClosed {
+		s.OpenedAt = b.openedAt
+	}
+
+	return s
+}
+
+func (b *Breaker) acquire() bool {
+	b.mu.Lock()
+
+	from := b.state
+	switch b.state {
+	case StateOpen:
+		if b.now().Sub(b.openedAt) < b.opts.OpenTimeout {
+			b.mu.Unlock()
+			return false
+		}
+		b.setStateLocked(StateHalfOpen)
+		b.inflight = 1
+	case StateHalfOpen:
+		if b.inflight >= b.opts.HalfOpenProbes {
+			b.mu.Unlock()
+			return false
+		}
+		b.inflight += 1
+	}
+	to := b.state
+	b.mu.Unlock()
+
+	b.notify(from, to)
+	return true
+}
+
+// give back a half-open probe without an outcome
+func (b *Breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == StateHalfOpen && b.inflight > 0 {
+		b.inflight -= 1
+	}
+}
+
+// a peer which answered with a proper status is not failing, unless it is overloaded
+func isFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.Temporary()
+	}
+
+	return true
+}
+
+func (b *Breaker) record(err error, elapsed time.Duration) {
+	o := outcome{failed: isFailure(err), slow: elapsed >= b.opts.SlowCall}
+
+	b.mu.Lock()
+
+	from := b.state
+	switch b.state {
+	case StateClosed:
+		b.pushLocked(o)
+		if b.trippedLocked() {
+			b.setStateLocked(StateOpen)
+		}
+	case StateHalfOpen:
+		if b.inflight > 0 {
+			b.inflight -= 1
+		}
+		if o.failed || o.slow {
+			b.setStateLocked(StateOpen)
+			break
+		}
+		b.probes += 1
+		if b.probes >= b.opts.HalfOpenProbes {
+			b.setStateLocked(StateClosed)
+		}
+	}
+	to := b.state
+	b.mu.Unlock()
+
+	b.notify(from, to)
+}
+
+// must be called with b.mu held
+func (b *Breaker) pushLocked(o outcome) {
+	if len(b.window) < b.opts.Window {
+		b.window = append(b.window, o)
+		return
+	}
+	b.window[b.next] = o
+	b.next = (b.next + 1) % b.opts.Window
+}
+
+// must be called with b.mu held
+func (b *Breaker) trippedLocked() bool {
+	if len(b.window) < b.opts.MinRequests {
+		return false
+	}
+
+	failed, slow := 0, 0
+	for _, o := range b.window {
+		if o.failed {
+			failed += 1
+		}
+		if o.slow {
+			slow += 1
+		}
+	}
+	n := float64(len(b.window))
+
+	return float64(failed)/n >= b.opts.ErrorRate || float64(slow)/n >= b.opts.SlowRate
+}
+
+// must be called with b.mu held
+func (b *Breaker) setStateLocked(s BreakerState) {
+	b.state = s
+	switch s {
+	case StateOpen:
+		b.openedAt = b.now()
+		b.inflight, b.probes = 0, 0
+	case StateHalfOpen:
+		b.inflight, b.probes = 0, 0
+	case StateClosed:
+		// start over with a clean window
+		b.window, b.next = b.window[:0], 0
+		b.inflight, b.probes = 0, 0
+	}
+}
+
+func (b *Breaker) notify(from, to BreakerState) {
+	if from != to && b.opts.OnChange != nil {
+		b.opts.OnChange(b.name, from, to)
+	}
+}
+
+var _ PeerGetter = (*Breaker)(nil)
+var _ ContextGetter = (*Breaker)(nil)
+var _ PeerSetter = (*Breaker)(nil)
+var _ PeerDeleter = (*Breaker)(nil)
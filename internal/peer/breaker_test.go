@@ -0,0 +1,153 @@
+package peer
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	pb "github.com/golrice/e-fis/internal/protocal"
+)
+
+type fakeGetter struct {
+	err   error
+	delay time.Duration
+	clock *fakeClock
+	calls int
+}
+
+func (f *fakeGetter) Get(in *pb.Request, out *pb.Response) error {
+	f.calls += 1
+	f.clock.advance(f.delay)
+	return f.err
+}
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+func newTestBreaker(getter *fakeGetter, changes *[]string) *Breaker {
+	b := NewBreaker("peer", getter, BreakerOptions{
+		Window:         4,
+		MinRequests:    4,
+		ErrorRate:      0.5,
+		SlowCall:       100 * time.Millisecond,
+		SlowRate:       0.75,
+		OpenTimeout:    time.Second,
+		HalfOpenProbes: 2,
+		OnChange: func(name string, from, to BreakerState) {
+			*changes = append(*changes, to.String())
+		},
+	})
+	b.now = getter.clock.now
+
+	return b
+}
+
+func TestBreaker_ErrorRate(t *testing.T) {
+	clock := &fakeClock{t: time.Now()}
+	getter := &fakeGetter{clock: clock}
+	var changes []string
+	b := newTestBreaker(getter, &changes)
+
+	call := func() error { return b.Get(&pb.Request{}, &pb.Response{}) }
+
+	// two successes and two failures reach the error rate
+	call()
+	call()
+	getter.err = errors.New("connection refused")
+	call()
+	if b.State() != StateClosed {
+		t.Fatal("the breaker should not open before MinRequests")
+	}
+	call()
+	if b.State() != StateOpen {
+		t.Fatal("the breaker should open at 50% errors")
+	}
+
+	// while open we fail fast without calling the peer
+	calls := getter.calls
+	if err := call(); err != ErrCircuitOpen || getter.calls != calls || b.Allow() {
+		t.Fatalf("we want a fast failure, got %v", err)
+	}
+
+	// after the timeout probes are let through, a failed probe opens it again
+	clock.advance(time.Second)
+	if !b.Allow() {
+		t.Fatal("the breaker should allow a probe after the timeout")
+	}
+	call()
+	if b.State() != StateOpen {
+		t.Fatal("a failed probe should open the breaker again")
+	}
+
+	// two good probes close it
+	clock.advance(time.Second)
+	getter.err = nil
+	call()
+	if b.State() != StateHalfOpen {
+		t.Fatal("one good probe is not enough")
+	}
+	call()
+	if b.State() != StateClosed {
+		t.Fatal("two good probes should close the breaker")
+	}
+
+	want := []string{"open", "half-open", "open", "half-open", "closed"}
+	if len(changes) != len(want) {
+		t.Fatalf("we want %v, got %v", want, changes)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Fatalf("we want %v, got %v", want, changes)
+		}
+	}
+}
+
+func TestBreaker_SlowCalls(t *testing.T) {
+	clock := &fakeClock{t: time.Now()}
+	getter := &fakeGetter{clock: clock, delay: 200 * time.Millisecond}
+	var changes []string
+	b := newTestBreaker(getter, &changes)
+
+	for i := 0; i < 3; i += 1 {
+		b.Get(&pb.Request{}, &pb.Response{})
+	}
+	getter.delay = 0
+	b.Get(&pb.Request{}, &pb.Response{})
+
+	if s := b.Status(); s.State != "open" || s.Slow != 3 || s.Failures != 0 {
+		t.Fatalf("three slow calls of four should open the breaker, got %+v", s)
+	}
+}
+
+func TestBreaker_StatusErrors(t *testing.T) {
+	clock := &fakeClock{t: time.Now()}
+	getter := &fakeGetter{clock: clock, err: &StatusError{Code: http.StatusNotFound, Status: "404 Not Found"}}
+	var changes []string
+	b := newTestBreaker(getter, &changes)
+
+	// the peer answers, it is just not there
+	for i := 0; i < 8; i += 1 {
+		b.Get(&pb.Request{}, &pb.Response{})
+	}
+	if b.State() != StateClosed {
+		t.Fatal("client errors should not open the breaker")
+	}
+
+	getter.err = &StatusError{Code: http.StatusServiceUnavailable, Status: "503 Service Unavailable"}
+	for i := 0; i < 4; i += 1 {
+		b.Get(&pb.Request{}, &pb.Response{})
+	}
+	if b.State() != StateOpen {
+		t.Fatal("an overloaded peer should open the breaker")
+	}
+}
@@ -1,40 +1,305 @@
 package peer
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"strconv"
+	"time"
 
 	pb "github.com/golrice/e-fis/internal/protocal"
+	"github.com/golrice/e-fis/internal/retry"
+	"google.golang.org/protobuf/proto"
 )
 
+// the path below the base path which answers health probes
+const HealthPath = "_health"
+
+// the path below the base path which takes batches of the invalidation bus
+const BusPath = "_bus"
+
+const (
+	defaultConnectTimeout = time.Second
+	defaultReadTimeout    = 2 * time.Second
+	defaultTimeout        = 3 * time.Second
+	defaultMaxIdleConns   = 16
+	defaultIdleTimeout    = 90 * time.Second
+	defaultMaxRetries     = 2
+	defaultBackoffBase    = 20 * time.Millisecond
+	defaultBackoffMax     = 500 * time.Millisecond
+)
+
+type HttpGetterOptions struct {
+	// use this client as is, the timeouts and pool options below are ignored then
+	Client *http.Client
+
+	// dialing a peer
+	ConnectTimeout time.Duration
+	// waiting for the response headers once the request is sent
+	ReadTimeout time.Duration
+	// the whole request, including reading the body
+	Timeout time.Duration
+	// idle keep-alive connections kept to the peer
+	MaxIdleConns    int
+	IdleConnTimeout time.Duration
+
+	// retries after the first attempt, only gets are retried
+	MaxRetries int
+	// the backoff before retry n is a random duration in [0, min(BackoffMax, BackoffBase * 2^n))
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+
+	// used for https peers, e.g. the client side of mutual tls
+	TLSConfig *tls.Config
+	// wraps the transport, e.g. to sign the requests
+	WrapTransport func(http.RoundTripper) http.RoundTripper
+}
+
+func (o *HttpGetterOptions) fill() {
+	if o.ConnectTimeout <= 0 {
+		o.ConnectTimeout = defaultConnectTimeout
+	}
+	if o.ReadTimeout <= 0 {
+		o.ReadTimeout = defaultReadTimeout
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = defaultTimeout
+	}
+	if o.MaxIdleConns <= 0 {
+		o.MaxIdleConns = defaultMaxIdleConns
+	}
+	if o.IdleConnTimeout <= 0 {
+		o.IdleConnTimeout = defaultIdleTimeout
+	}
+	if o.MaxRetries < 0 {
+		o.MaxRetries = 0
+	}
+	if o.BackoffBase <= 0 {
+		o.BackoffBase = defaultBackoffBase
+	}
+	if o.BackoffMax <= 0 {
+		o.BackoffMax = defaultBackoffMax
+	}
+}
+
+// the defaults, with retries enabled
+func DefaultHttpGetterOptions() HttpGetterOptions {
+	opts := HttpGetterOptions{MaxRetries: defaultMaxRetries}
+	opts.fill()
+
+	return opts
+}
+
+// a peer answered with something else than 200
+type StatusError struct {
+	Code   int
+	Status string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("server return: %v", e.Status)
+}
+
+func (e *StatusError) Temporary() bool {
+	return retry.Temporary(e.Code)
+}
+
 type HttpGetter struct {
 	BaseURL string
+
+	client *http.Client
+	opts   HttpGetterOptions
+}
+
+// every getter owns its transport, so every peer gets its own keep-alive pool
+func NewHttpGetter(baseURL string, opts HttpGetterOptions) *HttpGetter {
+	opts.fill()
+
+	client := opts.Client
+	if client == nil {
+		dialer := &net.Dialer{Timeout: opts.ConnectTimeout, KeepAlive: 30 * time.Second}
+		client = &http.Client{
+			Timeout: opts.Timeout,
+			Transport: &http.Transport{
+				Proxy:                 http.ProxyFromEnvironment,
+				DialContext:           dialer.DialContext,
+				MaxIdleConns:          opts.MaxIdleConns,
+				MaxIdleConnsPerHost:   opts.MaxIdleConns,
+				IdleConnTimeout:       opts.IdleConnTimeout,
+				ResponseHeaderTimeout: opts.ReadTimeout,
+				TLSHandshakeTimeout:   opts.ConnectTimeout,
+				TLSClientConfig:       opts.TLSConfig,
+			},
+		}
+		if opts.WrapTransport != nil {
+			client.Transport = opts.WrapTransport(client.Transport)
+		}
+	}
+
+	return &HttpGetter{
+		BaseURL: baseURL,
+		client:  client,
+		opts:    opts,
+	}
+}
+
+func (h *HttpGetter) Name() string {
+	return h.BaseURL
+}
+
+func (h *HttpGetter) httpClient() *http.Client {
+	if h.client == nil {
+		return http.DefaultClient
+	}
+	return h.client
 }
 
 func (h *HttpGetter) Get(in *pb.Request, out *pb.Response) error {
+	return h.GetContext(context.Background(), in, out)
+}
+
+func (h *HttpGetter) GetContext(ctx context.Context, in *pb.Request, out *pb.Response) error {
 	url := fmt.Sprintf("%v%v/%v", h.BaseURL, url.QueryEscape(in.NodeName), url.QueryEscape(in.Key))
 
-	res, err := http.Get(url)
+	body, err := h.do(ctx, http.MethodGet, url, nil, h.opts.MaxRetries)
 	if err != nil {
 		return err
 	}
-	defer res.Body.Close()
 
-	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("server return: %v", res.Status)
+	return proto.Unmarshal(body, out)
+}
+
+// set posts the batch to <base>/<node_name>, it is not retried, a retry could land after a newer write
+func (h *HttpGetter) Set(in *pb.SetRequest) error {
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return err
 	}
 
-	bytes, err := io.ReadAll(res.Body)
+	_, err = h.do(context.Background(), http.MethodPost, h.BaseURL+url.QueryEscape(in.NodeName), body, 0)
+	return err
+}
+
+// delete sends DELETE <base>/<node_name>/<key>, it is not retried like set
+func (h *HttpGetter) Delete(in *pb.Request) error {
+	url := fmt.Sprintf("%v%v/%v", h.BaseURL, url.QueryEscape(in.NodeName), url.QueryEscape(in.Key))
+
+	_, err := h.do(context.Background(), http.MethodDelete, url, nil, 0)
+	return err
+}
+
+// invalidate sends DELETE <base>/<node_name>?tag=..., ?prefix=... or ?generation=...,
+// it is not retried either
+func (h *HttpGetter) Invalidate(in *pb.InvalidateRequest) error {
+	q := url.Values{}
+	if in.Tag != "" {
+		q.Set("tag", in.Tag)
+	}
+	if in.Prefix != "" {
+		q.Set("prefix", in.Prefix)
+	}
+	if in.Generation > 0 {
+		q.Set("generation", strconv.FormatUint(in.Generation, 10))
+	}
+
+	_, err := h.do(context.Background(), http.MethodDelete, h.BaseURL+url.QueryEscape(in.NodeName)+"?"+q.Encode(), nil, 0)
+	return err
+}
+
+// deliver posts a batch of the invalidation bus, the bus retries on its own
+func (h *HttpGetter) Deliver(ctx context.Context, in *pb.BusBatch, out *pb.BusAck) error {
+	body, err := proto.Marshal(in)
 	if err != nil {
 		return err
 	}
 
-	out.Value = bytes
+	b, err := h.do(ctx, http.MethodPost, h.BaseURL+BusPath, body, 0)
+	if err != nil {
+		return err
+	}
+
+	return proto.Unmarshal(b, out)
+}
+
+// ping asks the peer whether it is alive, the health checker counts failures itself
+func (h *HttpGetter) Ping() error {
+	_, err := h.do(context.Background(), http.MethodGet, h.BaseURL+HealthPath, nil, 0)
+	return err
+}
+
+func (h *HttpGetter) do(ctx context.Context, method, url string, body []byte, retries int) ([]byte, error) {
+	var lastErr error
+
+	for attempt := 0; attempt <= retries; attempt += 1 {
+		if attempt > 0 {
+			timer := time.NewTimer(h.backoff(attempt - 1))
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, ctx.Err()
+			}
+		}
+
+		b, err := h.once(ctx, method, url, body)
+		if err == nil {
+			return b, nil
+		}
+		lastErr = err
+
+		if ctx.Err() != nil {
+			break
+		}
+
+		if se, ok := err.(*StatusError); ok && !se.Temporary() {
+			break
+		}
+	}
+
+	return nil, lastErr
+}
+
+func (h *HttpGetter) once(ctx context.Context, method, url string, body []byte) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+
+	res, err := h.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		// drain it, so that the connection can be reused
+		io.Copy(io.Discard, res.Body)
+		return nil, &StatusError{Code: res.StatusCode, Status: res.Status}
+	}
+
+	return io.ReadAll(res.Body)
+}
 
-	return nil
+func (h *HttpGetter) backoff(n int) time.Duration {
+	return retry.Backoff(n, h.opts.BackoffBase, h.opts.BackoffMax)
 }
 
 // make sure httpgetter is peergetter
 var _ PeerGetter = (*HttpGetter)(nil)
+var _ PeerSetter = (*HttpGetter)(nil)
+var _ PeerDeleter = (*HttpGetter)(nil)
+var _ PeerInvalidator = (*HttpGetter)(nil)
+var _ ContextGetter = (*HttpGetter)(nil)
@@ -0,0 +1,163 @@
+package peer
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "github.com/golrice/e-fis/internal/protocal"
+	"google.golang.org/protobuf/proto"
+)
+
+func newTestGetter(url string, retries int) *HttpGetter {
+	return NewHttpGetter(url+"/efis/", HttpGetterOptions{
+		Timeout:     200 * time.Millisecond,
+		MaxRetries:  retries,
+		BackoffBase: time.Millisecond,
+		BackoffMax:  5 * time.Millisecond,
+	})
+}
+
+func TestHttpGetter_Get(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/efis/scores/Tom" {
+			http.Error(w, "no such node", http.StatusNotFound)
+			return
+		}
+		body, _ := proto.Marshal(&pb.Response{Value: []byte("630")})
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	getter := newTestGetter(server.URL, 0)
+
+	out := &pb.Response{}
+	if err := getter.Get(&pb.Request{NodeName: "scores", Key: "Tom"}, out); err != nil {
+		t.Fatal(err)
+	}
+	// the body is a protobuf response, not the raw value
+	if string(out.Value) != "630" {
+		t.Fatalf("we want 630, got %q", out.Value)
+	}
+}
+
+func TestHttpGetter_Retry(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := proto.Marshal(&pb.Response{Value: []byte("ok")})
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	out := &pb.Response{}
+	if err := newTestGetter(server.URL, 2).Get(&pb.Request{NodeName: "n", Key: "k"}, out); err != nil || string(out.Value) != "ok" {
+		t.Fatalf("we want ok after two retries, got %q, %v", out.Value, err)
+	}
+
+	calls.Store(0)
+	err := newTestGetter(server.URL, 1).Get(&pb.Request{NodeName: "n", Key: "k"}, out)
+	var se *StatusError
+	if !errors.As(err, &se) || se.Code != http.StatusServiceUnavailable || calls.Load() != 2 {
+		t.Fatalf("we want 503 after one retry, got %v after %d calls", err, calls.Load())
+	}
+}
+
+func TestHttpGetter_NoRetryOnClientError(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Error(w, "no such node", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	err := newTestGetter(server.URL, 3).Get(&pb.Request{NodeName: "n", Key: "k"}, &pb.Response{})
+	if err == nil || calls.Load() != 1 {
+		t.Fatalf("a 404 should not be retried, got %v after %d calls", err, calls.Load())
+	}
+}
+
+func TestHttpGetter_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	start := time.Now()
+	if err := newTestGetter(server.URL, 0).Get(&pb.Request{NodeName: "n", Key: "k"}, &pb.Response{}); err == nil {
+		t.Fatal("a slow peer should time out")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("the timeout was not applied, took %v", d)
+	}
+}
+
+func TestHttpGetter_SetAndPing(t *testing.T) {
+	var got pb.SetRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/efis/"+HealthPath:
+		case r.Method == http.MethodPost && r.URL.Path == "/efis/scores":
+			b, _ := io.ReadAll(r.Body)
+			proto.Unmarshal(b, &got)
+		default:
+			http.Error(w, "bad request", http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	getter := newTestGetter(server.URL, 0)
+	if err := getter.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	in := &pb.SetRequest{NodeName: "scores", Entries: []*pb.Entry{{Key: "Tom", Value: []byte("630")}}}
+	if err := getter.Set(in); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Entries) != 1 || got.Entries[0].Key != "Tom" {
+		t.Fatalf("unexpected batch %v", &got)
+	}
+}
+
+func TestHttpGetter_WritesAreNotRetried(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Error(w, "busy", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	getter := newTestGetter(server.URL, 2)
+	getter.Set(&pb.SetRequest{NodeName: "scores"})
+	getter.Delete(&pb.Request{NodeName: "scores", Key: "Tom"})
+	getter.Invalidate(&pb.InvalidateRequest{NodeName: "scores", Tag: "t"})
+	if calls.Load() != 3 {
+		t.Fatalf("want one call per write, got %d", calls.Load())
+	}
+}
+
+func TestHttpGetter_Backoff(t *testing.T) {
+	getter := NewHttpGetter("", HttpGetterOptions{BackoffBase: 10 * time.Millisecond, BackoffMax: 50 * time.Millisecond})
+
+	for n := 0; n < 40; n += 1 {
+		want := 50 * time.Millisecond
+		if n < 3 {
+			want = (10 * time.Millisecond) << n
+		}
+		for i := 0; i < 20; i += 1 {
+			if d := getter.backoff(n); d < 0 || d > want {
+				t.Fatalf("backoff %d is %v, we want at most %v", n, d, want)
+			}
+		}
+	}
+}
@@ -1,6 +1,11 @@
 package peer
 
-import pb "github.com/golrice/e-fis/internal/protocal"
+import (
+	"context"
+	"time"
+
+	pb "github.com/golrice/e-fis/internal/protocal"
+)
 
 // we can use PickPeer function to get the peergetter
 type PeerPicker interface {
@@ -11,3 +16,59 @@ type PeerPicker interface {
 type PeerGetter interface {
 	Get(in *pb.Request, out *pb.Response) error
 }
+
+// peersetter stores a batch of entries into a node of the peer
+type PeerSetter interface {
+	Set(in *pb.SetRequest) error
+}
+
+// peerdeleter drops a key from a node of the peer
+type PeerDeleter interface {
+	Delete(in *pb.Request) error
+}
+
+// peerinvalidator drops every key with a tag or a prefix from a node of the peer,
+// or everything cached before a generation
+type PeerInvalidator interface {
+	Invalidate(in *pb.InvalidateRequest) error
+}
+
+// contextgetter is a peergetter whose get can be canceled
+type ContextGetter interface {
+	GetContext(ctx context.Context, in *pb.Request, out *pb.Response) error
+}
+
+// named is a peergetter which knows the member it talks to, a getter is rebuilt when the
+// options change, the name stays the same
+type Named interface {
+	Name() string
+}
+
+// replicapicker picks the members which hold the key of the namespace, its owner and those
+// with a spread copy, in random order and without ourselves. any other member would ask the
+// owner again, a hedge goes to one of these
+type ReplicaPicker interface {
+	PickReplicas(namespace, key string) []PeerGetter
+}
+
+// peerlister picks every other member of the ring, e.g. to fan an invalidation out to all of them
+type PeerLister interface {
+	PickAll() []PeerGetter
+}
+
+// spreader serves a hot key from several members, its owner copies it to the next members
+// on the ring and PickHolder spreads the reads of the key over all of them
+// a key is spread in a namespace, the same key may be cold in another one
+type Spreader interface {
+	// the owner of the key, writes go there while PickHolder may pick a member holding a copy
+	PickOwner(key string) (peer PeerGetter, ok bool)
+	// the owner of the key or a member holding a copy, reads of the namespace go there
+	PickHolder(namespace, key string) (peer PeerGetter, ok bool)
+	// the next n members after us on the ring, nil unless we own the key
+	PickCopies(key string, n int) []PeerGetter
+	// reads of key are spread over its owner and the next copies members until the deadline,
+	// 0 copies stops it
+	Spread(namespace, key string, copies int, until time.Time)
+	// how many members after the owner hold a copy of key, 0 if it is not spread
+	Spreading(namespace, key string) (copies int, until time.Time)
+}
@@ -0,0 +1,249 @@
+package peertls
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	// pem files, the ca signs the certificates of all peers
+	CAFile   string
+	CertFile string
+	KeyFile  string
+	// how often the files are checked for changes, 0 disables reloading
+	ReloadInterval time.Duration
+	// the peers we talk to, a certificate must be valid for one of them
+	// nil accepts every certificate signed by the ca
+	AllowedPeers func() []string
+}
+
+// the material in use
+type material struct {
+	cert *tls.Certificate
+	pool *x509.CertPool
+	raw  [3][]byte
+}
+
+// manager hands out tls configs for peer traffic, the certificates can be swapped at runtime
+type Manager struct {
+	conf Config
+
+	mu  sync.RWMutex
+	cur *material
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewManager(conf Config) (*Manager, error) {
+	m := &Manager{conf: conf}
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+
+	if conf.ReloadInterval > 0 {
+		m.stop = make(chan struct{})
+		m.done = make(chan struct{})
+		go m.watch()
+	}
+
+	return m, nil
+}
+
+// a manager without files, Update swaps the material
+func NewManagerFromPEM(caPEM, certPEM, keyPEM []byte, allowed func() []string) (*Manager, error) {
+	m := &Manager{conf: Config{AllowedPeers: allowed}}
+	if err := m.Update(caPEM, certPEM, keyPEM); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func parse(caPEM, certPEM, keyPEM []byte) (*material, error) {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("bad certificate: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, errors.New("no ca certificate found")
+	}
+
+	return &material{cert: &cert, pool: pool, raw: [3][]byte{caPEM, certPEM, keyPEM}}, nil
+}
+
+// update validates the new material and swaps it in, connections already open are kept
+func (m *Manager) Update(caPEM, certPEM, keyPEM []byte) error {
+	mat, err := parse(caPEM, certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.cur = mat
+
+	return nil
+}
+
+// reload reads the files again, the old material is kept if they are broken
+func (m *Manager) Reload() error {
+	if m.conf.CAFile == "" || m.conf.CertFile == "" || m.conf.KeyFile == "" {
+		return errors.New("need a ca, a certificate and a key file")
+	}
+
+	var raw [3][]byte
+	for i, path := range []string{m.conf.CAFile, m.conf.CertFile, m.conf.KeyFile} {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		raw[i] = b
+	}
+
+	m.mu.RLock()
+	same := m.cur != nil && bytes.Equal(raw[0], m.cur.raw[0]) && bytes.Equal(raw[1], m.cur.raw[1]) && bytes.Equal(raw[2], m.cur.raw[2])
+	m.mu.RUnlock()
+	if same {
+		return nil
+	}
+
+	if err := m.Update(raw[0], raw[1], raw[2]); err != nil {
+		return err
+	}
+	log.Printf("[TLS] loaded certificate %s", m.conf.CertFile)
+
+	return nil
+}
+
+func (m *Manager) watch() {
+	defer close(m.done)
+
+	ticker := time.NewTicker(m.conf.ReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := m.Reload(); err != nil {
+				log.Printf("[TLS] fail to reload certificates: %s", err.Error())
+			}
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+func (m *Manager) Close() {
+	if m.stop == nil {
+		return
+	}
+
+	select {
+	case <-m.stop:
+	default:
+		close(m.stop)
+		<-m.done
+	}
+}
+
+func (m *Manager) current() *material {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.cur
+}
+
+// the config of the peer server, clients must present a certificate of a member
+func (m *Manager) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return m.current().cert, nil
+		},
+		// a fresh config per handshake, so that a new ca is picked up
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cur := m.current()
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cur.cert},
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+				ClientCAs:    cur.pool,
+				VerifyConnection: func(cs tls.ConnectionState) error {
+					return m.verifyIdentity(cs.PeerCertificates[0])
+				},
+			}, nil
+		},
+	}
+}
+
+// the config of the peer clients
+func (m *Manager) ClientConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return m.current().cert, nil
+		},
+		// the ca may change at runtime, so we verify against the current pool ourselves
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("peer sent no certificate")
+			}
+
+			opts := x509.VerifyOptions{
+				Roots:         m.current().pool,
+				DNSName:       cs.ServerName,
+				Intermediates: x509.NewCertPool(),
+			}
+			for _, c := range cs.PeerCertificates[1:] {
+				opts.Intermediates.AddCert(c)
+			}
+			if _, err := cs.PeerCertificates[0].Verify(opts); err != nil {
+				return err
+			}
+
+			return m.verifyIdentity(cs.PeerCertificates[0])
+		},
+	}
+}
+
+// the certificate must be valid for the host of one of the allowed peers
+func (m *Manager) verifyIdentity(leaf *x509.Certificate) error {
+	if m.conf.AllowedPeers == nil {
+		return nil
+	}
+
+	peers := m.conf.AllowedPeers()
+	for _, p := range peers {
+		if leaf.VerifyHostname(hostOf(p)) == nil {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("certificate of %q does not belong to a member", leaf.Subject.CommonName)
+}
+
+// http://host:port -> host
+func hostOf(peer string) string {
+	if u, err := url.Parse(peer); err == nil && u.Host != "" {
+		peer = u.Host
+	}
+	if host, _, err := net.SplitHostPort(peer); err == nil {
+		return host
+	}
+
+	return peer
+}
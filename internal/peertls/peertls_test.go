@@ -0,0 +1,227 @@
+package peertls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var serial int64
+
+type ca struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newCA(t *testing.T) *ca {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(atomic.AddInt64(&serial, 1)),
+		Subject:               pkix.Name{CommonName: "efis test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	return &ca{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// a certificate for 127.0.0.1, good for both sides
+func (c *ca) issue(t *testing.T, name string) (certPEM, keyPEM []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(atomic.AddInt64(&serial, 1)),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, c.cert, &key.PublicKey, c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func (c *ca) manager(t *testing.T, name string, allowed func() []string) *Manager {
+	t.Helper()
+
+	cert, key := c.issue(t, name)
+	m, err := NewManagerFromPEM(c.pem, cert, key, allowed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func members(peers ...string) func() []string {
+	return func() []string { return peers }
+}
+
+func startServer(t *testing.T, m *Manager) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	srv.TLS = m.ServerConfig()
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func get(url string, conf *tls.Config) error {
+	client := &http.Client{
+		Timeout:   2 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: conf, DisableKeepAlives: true},
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+func TestMutualTLS_Accept(t *testing.T) {
+	authority := newCA(t)
+	self := "https://127.0.0.1:8001"
+
+	server := authority.manager(t, "server", members(self))
+	srv := startServer(t, server)
+
+	client := authority.manager(t, "client", members(self))
+	if err := get(srv.URL, client.ClientConfig()); err != nil {
+		t.Fatalf("a member should be accepted: %v", err)
+	}
+}
+
+func TestMutualTLS_Reject(t *testing.T) {
+	authority := newCA(t)
+	server := authority.manager(t, "server", members("https://127.0.0.1:8001"))
+	srv := startServer(t, server)
+
+	// no client certificate at all
+	noCert := &tls.Config{RootCAs: x509.NewCertPool()}
+	noCert.RootCAs.AppendCertsFromPEM(authority.pem)
+	if err := get(srv.URL, noCert); err == nil {
+		t.Fatal("a client without a certificate should be rejected")
+	}
+
+	// a certificate signed by someone else
+	foreign := newCA(t).manager(t, "foreign", nil)
+	if err := get(srv.URL, foreign.ClientConfig()); err == nil {
+		t.Fatal("a certificate of a foreign ca should be rejected")
+	}
+
+	// signed by our ca, but the server does not know the peer
+	other := authority.manager(t, "server", members("https://10.0.0.1:8001"))
+	srv = startServer(t, other)
+	client := authority.manager(t, "client", nil)
+	if err := get(srv.URL, client.ClientConfig()); err == nil {
+		t.Fatal("a peer outside the membership should be rejected")
+	}
+
+	// and the client refuses a server which is not a member
+	server = authority.manager(t, "server", nil)
+	srv = startServer(t, server)
+	client = authority.manager(t, "client", members("https://10.0.0.1:8001"))
+	if err := get(srv.URL, client.ClientConfig()); err == nil {
+		t.Fatal("a server outside the membership should be rejected")
+	}
+}
+
+func TestManager_Reload(t *testing.T) {
+	dir := t.TempDir()
+	conf := Config{
+		CAFile:   filepath.Join(dir, "ca.pem"),
+		CertFile: filepath.Join(dir, "cert.pem"),
+		KeyFile:  filepath.Join(dir, "key.pem"),
+	}
+	write := func(c *ca, name string) {
+		cert, key := c.issue(t, name)
+		for path, b := range map[string][]byte{conf.CAFile: c.pem, conf.CertFile: cert, conf.KeyFile: key} {
+			if err := os.WriteFile(path, b, 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	oldCA := newCA(t)
+	write(oldCA, "server")
+	server, err := NewManager(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	srv := startServer(t, server)
+
+	if err := get(srv.URL, oldCA.manager(t, "client", nil).ClientConfig()); err != nil {
+		t.Fatalf("before the rotation: %v", err)
+	}
+
+	// rotate to a new ca without restarting the server
+	newAuthority := newCA(t)
+	write(newAuthority, "server")
+	if err := server.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := get(srv.URL, oldCA.manager(t, "client", nil).ClientConfig()); err == nil {
+		t.Fatal("the old ca should not be trusted after the reload")
+	}
+	if err := get(srv.URL, newAuthority.manager(t, "client", nil).ClientConfig()); err != nil {
+		t.Fatalf("after the rotation: %v", err)
+	}
+
+	// a broken file keeps the current material
+	os.WriteFile(conf.KeyFile, []byte("garbage"), 0o600)
+	if err := server.Reload(); err == nil {
+		t.Fatal("a broken key should fail the reload")
+	}
+	if err := get(srv.URL, newAuthority.manager(t, "client", nil).ClientConfig()); err != nil {
+		t.Fatalf("the old material should be kept: %v", err)
+	}
+}
@@ -19,7 +19,8 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	RpcGetter_Get_FullMethodName = "/protocal.RpcGetter/Get"
+	RpcGetter_Get_FullMethodName   = "/protocal.RpcGetter/Get"
+	RpcGetter_Watch_FullMethodName = "/protocal.RpcGetter/Watch"
 )
 
 // RpcGetterClient is the client API for RpcGetter service.
@@ -27,6 +28,7 @@ const (
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type RpcGetterClient interface {
 	Get(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Response, error)
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[WatchEvent], error)
 }
 
 type rpcGetterClient struct {
@@ -47,11 +49,31 @@ func (c *rpcGetterClient) Get(ctx context.Context, in *Request, opts ...grpc.Cal
 	return out, nil
 }
 
+func (c *rpcGetterClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[WatchEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &RpcGetter_ServiceDesc.Streams[0], RpcGetter_Watch_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchRequest, WatchEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type RpcGetter_WatchClient = grpc.ServerStreamingClient[WatchEvent]
+
 // RpcGetterServer is the server API for RpcGetter service.
 // All implementations must embed UnimplementedRpcGetterServer
 // for forward compatibility.
 type RpcGetterServer interface {
 	Get(context.Context, *Request) (*Response, error)
+	Watch(*WatchRequest, grpc.ServerStreamingServer[WatchEvent]) error
 	mustEmbedUnimplementedRpcGetterServer()
 }
 
@@ -65,6 +87,9 @@ type UnimplementedRpcGetterServer struct{}
 func (UnimplementedRpcGetterServer) Get(context.Context, *Request) (*Response, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Get not implemented")
 }
+func (UnimplementedRpcGetterServer) Watch(*WatchRequest, grpc.ServerStreamingServer[WatchEvent]) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
 func (UnimplementedRpcGetterServer) mustEmbedUnimplementedRpcGetterServer() {}
 func (UnimplementedRpcGetterServer) testEmbeddedByValue()                   {}
 
@@ -104,6 +129,17 @@ func _RpcGetter_Get_Handler(srv interface{}, ctx context.Context, dec func(inter
 	return interceptor(ctx, in, info, handler)
 }
 
+func _RpcGetter_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(RpcGetterServer).Watch(m, &grpc.GenericServerStream[WatchRequest, WatchEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type RpcGetter_WatchServer = grpc.ServerStreamingServer[WatchEvent]
+
 // RpcGetter_ServiceDesc is the grpc.ServiceDesc for RpcGetter service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -116,6 +152,12 @@ var RpcGetter_ServiceDesc = grpc.ServiceDesc{
 			Handler:    _RpcGetter_Get_Handler,
 		},
 	},
-	Streams:  []grpc.StreamDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       _RpcGetter_Watch_Handler,
+			ServerStreams: true,
+		},
+	},
 	Metadata: "cachepb.proto",
 }
@@ -0,0 +1,258 @@
+package rebalance
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golrice/e-fis/internal/cache"
+	pb "github.com/golrice/e-fis/internal/protocal"
+)
+
+const (
+	defaultBatchSize = 100
+	defaultRate      = 10
+)
+
+// sender delivers a batch to the peer which owns it now
+type Sender func(owner string, in *pb.SetRequest) error
+
+type Options struct {
+	// entries per batch
+	BatchSize int
+	// batches per second, across all owners
+	Rate int
+}
+
+// what the current or last run did
+type Progress struct {
+	Running    bool      `json:"running"`
+	Run        int       `json:"run"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	// local keys looked at
+	Scanned int64 `json:"scanned"`
+	// keys owned by another peer
+	Pending int64 `json:"pending"`
+	Moved   int64 `json:"moved"`
+	Failed  int64 `json:"failed"`
+	// a newer ring replaced this run before it finished
+	Canceled  bool   `json:"canceled"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// rebalancer hands keys over to their new owner after the ring changed
+type Rebalancer struct {
+	self string
+	send Sender
+	opts Options
+
+	// serializes Run
+	runMu sync.Mutex
+
+	mu       sync.Mutex
+	progress Progress
+	cancel   chan struct{}
+	done     chan struct{}
+}
+
+func New(self string, send Sender, opts Options) *Rebalancer {
+	if send == nil {
+		panic("need a good sender")
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultBatchSize
+	}
+	if opts.Rate <= 0 {
+		opts.Rate = defaultRate
+	}
+
+	return &Rebalancer{
+		self: self,
+		send: send,
+		opts: opts,
+	}
+}
+
+func (r *Rebalancer) Log(format string, v ...any) {
+	log.Printf("[Rebalance %s] %s", r.self, fmt.Sprintf(format, v...))
+}
+
+// run starts moving keys whose owner is not us any more, a running pass is canceled first
+// owner must be safe to call from another goroutine
+func (r *Rebalancer) Run(nodes []*cache.Node, owner func(key string) string) {
+	r.runMu.Lock()
+	defer r.runMu.Unlock()
+
+	r.Stop()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cancel = make(chan struct{})
+	r.done = make(chan struct{})
+	r.progress = Progress{
+		Running:   true,
+		Run:       r.progress.Run + 1,
+		StartedAt: time.Now(),
+	}
+
+	go r.run(nodes, owner, r.cancel, r.done)
+}
+
+// stop cancels the running pass and waits for it
+func (r *Rebalancer) Stop() {
+	r.mu.Lock()
+	cancel, done := r.cancel, r.done
+	r.cancel, r.done = nil, nil
+	r.mu.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	close(cancel)
+	<-done
+}
+
+// wait until the running pass is finished
+func (r *Rebalancer) Wait() {
+	r.mu.Lock()
+	done := r.done
+	r.mu.Unlock()
+
+	if done != nil {
+		<-done
+	}
+}
+
+func (r *Rebalancer) Progress() Progress {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.progress
+}
+
+func (r *Rebalancer) update(f func(p *Progress)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f(&r.progress)
+}
+
+type batch struct {
+	node  *cache.Node
+	owner string
+	keys  []string
+}
+
+func (r *Rebalancer) run(nodes []*cache.Node, owner func(key string) string, cancel <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	batches := r.plan(nodes, owner)
+
+	ticker := time.NewTicker(time.Second / time.Duration(r.opts.Rate))
+	defer ticker.Stop()
+
+	for i, b := range batches {
+		// the first batch goes out right away
+		if i > 0 {
+			select {
+			case <-ticker.C:
+			case <-cancel:
+				r.update(func(p *Progress) {
+					p.Running, p.Canceled, p.FinishedAt = false, true, time.Now()
+				})
+				return
+			}
+		}
+
+		r.handOff(b)
+	}
+
+	progress := r.Progress()
+	if progress.Pending > 0 {
+		r.Log("run %d moved %d keys, %d failed", progress.Run, progress.Moved, progress.Failed)
+	}
+	r.update(func(p *Progress) {
+		p.Running, p.FinishedAt = false, time.Now()
+	})
+}
+
+// group the keys we do not own any more by node and owner
+func (r *Rebalancer) plan(nodes []*cache.Node, owner func(key string) string) []batch {
+	var batches []batch
+	var scanned, pending int64
+
+	for _, node := range nodes {
+		byOwner := map[string][]string{}
+		for _, key := range node.Keys() {
+			scanned += 1
+			if o := owner(key); o != "" && o != r.self {
+				byOwner[o] = append(byOwner[o], key)
+				pending += 1
+			}
+		}
+
+		owners := make([]string, 0, len(byOwner))
+		for o := range byOwner {
+			owners = append(owners, o)
+		}
+		sort.Strings(owners)
+
+		for _, o := range owners {
+			keys := byOwner[o]
+			for len(keys) > 0 {
+				n := min(len(keys), r.opts.BatchSize)
+				batches = append(batches, batch{node: node, owner: o, keys: keys[:n]})
+				keys = keys[n:]
+			}
+		}
+	}
+
+	r.update(func(p *Progress) {
+		p.Scanned, p.Pending = scanned, pending
+	})
+
+	return batches
+}
+
+func (r *Rebalancer) handOff(b batch) {
+	in := &pb.SetRequest{NodeName: b.node.Name()}
+	keys := make([]string, 0, len(b.keys))
+	for _, key := range b.keys {
+		// it may be evicted since we planned
+		v, ok := b.node.Peek(key)
+		if !ok {
+			continue
+		}
+		in.Entries = append(in.Entries, &pb.Entry{Key: key, Value: v.ByteSlice(), Expire: cache.ExpireToNano(v.Expire()), Tags: b.node.Tags(key)})
+		keys = append(keys, key)
+	}
+
+	skipped := int64(len(b.keys) - len(keys))
+	if len(keys) == 0 {
+		r.update(func(p *Progress) { p.Pending -= skipped })
+		return
+	}
+
+	if err := r.send(b.owner, in); err != nil {
+		// keep them, we can still serve them until they are evicted
+		r.Log("fail to hand %d keys of %s to %s: %s", len(keys), b.node.Name(), b.owner, err.Error())
+		r.update(func(p *Progress) {
+			p.Pending -= int64(len(b.keys))
+			p.Failed += int64(len(keys))
+			p.LastError = err.Error()
+		})
+		return
+	}
+
+	for _, key := range keys {
+		b.node.Drop(key)
+	}
+	r.update(func(p *Progress) {
+		p.Pending -= int64(len(b.keys))
+		p.Moved += int64(len(keys))
+	})
+}
@@ -0,0 +1,118 @@
+package rebalance
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/golrice/e-fis/internal/cache"
+	pb "github.com/golrice/e-fis/internal/protocal"
+)
+
+func newNode(name string) *cache.Node {
+	return cache.NewNode(name, 0, func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("no key: %s", key)
+	})
+}
+
+// even keys belong to "other", odd keys to "self"
+func owner(key string) string {
+	if i, _ := strconv.Atoi(key); i%2 == 0 {
+		return "other"
+	}
+	return "self"
+}
+
+func TestRebalancer_Run(t *testing.T) {
+	local, remote := newNode("scores"), newNode("scores")
+	for i := 0; i < 25; i += 1 {
+		local.SetLocal(strconv.Itoa(i), []byte("v"+strconv.Itoa(i)))
+	}
+
+	var mu sync.Mutex
+	var batches int
+	r := New("self", func(o string, in *pb.SetRequest) error {
+		if o != "other" || in.NodeName != "scores" {
+			t.Errorf("unexpected batch for %s/%s", o, in.NodeName)
+		}
+		mu.Lock()
+		batches += 1
+		mu.Unlock()
+		for _, e := range in.Entries {
+			remote.SetLocal(e.Key, e.Value)
+		}
+		return nil
+	}, Options{BatchSize: 5, Rate: 1000})
+
+	r.Run([]*cache.Node{local}, owner)
+	r.Wait()
+
+	p := r.Progress()
+	if p.Running || p.Scanned != 25 || p.Moved != 13 || p.Failed != 0 || p.Pending != 0 {
+		t.Fatalf("unexpected progress %+v", p)
+	}
+	if batches != 3 {
+		t.Fatalf("we want 3 batches of at most 5 keys, got %d", batches)
+	}
+
+	for i := 0; i < 25; i += 1 {
+		key := strconv.Itoa(i)
+		_, here := local.Peek(key)
+		v, there := remote.Peek(key)
+		if owner(key) == "other" && (here || !there || v.String() != "v"+key) {
+			t.Fatalf("key %s should be moved", key)
+		}
+		if owner(key) == "self" && (!here || there) {
+			t.Fatalf("key %s should stay", key)
+		}
+	}
+}
+
+func TestRebalancer_Failure(t *testing.T) {
+	local := newNode("scores")
+	local.SetLocal("0", []byte("v0"))
+
+	r := New("self", func(o string, in *pb.SetRequest) error {
+		return errors.New("peer is down")
+	}, Options{})
+
+	r.Run([]*cache.Node{local}, owner)
+	r.Wait()
+
+	if p := r.Progress(); p.Failed != 1 || p.Moved != 0 || p.LastError == "" {
+		t.Fatalf("unexpected progress %+v", p)
+	}
+	// we keep what we could not hand off
+	if _, ok := local.Peek("0"); !ok {
+		t.Fatal("key 0 should stay on failure")
+	}
+}
+
+func TestRebalancer_Cancel(t *testing.T) {
+	local := newNode("scores")
+	for i := 0; i < 10; i += 2 {
+		local.SetLocal(strconv.Itoa(i), []byte("v"))
+	}
+
+	block := make(chan struct{})
+	sent := make(chan struct{}, 10)
+	r := New("self", func(o string, in *pb.SetRequest) error {
+		sent <- struct{}{}
+		<-block
+		return nil
+	}, Options{BatchSize: 1, Rate: 1})
+
+	r.Run([]*cache.Node{local}, owner)
+	<-sent
+	close(block)
+
+	// a new ring replaces the running pass
+	r.Run([]*cache.Node{}, owner)
+	r.Wait()
+
+	if p := r.Progress(); p.Run != 2 || p.Running {
+		t.Fatalf("unexpected progress %+v", p)
+	}
+}
@@ -0,0 +1,447 @@
+package resp
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golrice/e-fis/internal/cache"
+)
+
+type command struct {
+	// the number of arguments including the name, -n means at least n
+	arity int
+	fn    func(s *Server, c *client, args [][]byte)
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"PING":    {-1, (*Server).ping},
+		"ECHO":    {2, (*Server).echo},
+		"QUIT":    {1, (*Server).quit},
+		"SELECT":  {2, (*Server).selectDB},
+		"HELLO":   {-1, (*Server).hello},
+		"GET":     {2, (*Server).get},
+		"MGET":    {-2, (*Server).mget},
+		"SET":     {-3, (*Server).set},
+		"DEL":     {-2, (*Server).del},
+		"EXISTS":  {-2, (*Server).exists},
+		"EXPIRE":  {3, (*Server).expire},
+		"PEXPIRE": {3, (*Server).expire},
+		"TTL":     {2, (*Server).ttl},
+		"PTTL":    {2, (*Server).ttl},
+		"DBSIZE":  {1, (*Server).dbsize},
+		"INFO":    {-1, (*Server).info},
+		"CONFIG":  {-2, (*Server).config},
+		"COMMAND": {-1, (*Server).command},
+		"CLIENT":  {-2, (*Server).client},
+	}
+}
+
+func (s *Server) dispatch(c *client, args [][]byte) {
+	name := strings.ToUpper(string(args[0]))
+	cmd, ok := commands[name]
+	if !ok {
+		s.stats.Inc("unknown_commands")
+		c.w.error(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+		return
+	}
+
+	if (cmd.arity > 0 && len(args) != cmd.arity) || (cmd.arity < 0 && len(args) < -cmd.arity) {
+		c.w.error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name)))
+		return
+	}
+
+	s.stats.Inc("cmd_" + strings.ToLower(name))
+	cmd.fn(s, c, args)
+}
+
+// an error reply, the messages of errors we made up already carry their code
+func (s *Server) fail(c *client, err error) {
+	s.stats.Inc("command_errors")
+	msg := err.Error()
+	if !strings.HasPrefix(msg, "ERR ") {
+		msg = "ERR " + msg
+	}
+	c.w.error(msg)
+}
+
+// lookup reads a key like GET would, ok is false if it does not exist
+func (s *Server) lookup(c *client, raw []byte) (node *cache.Node, key string, v cache.ByteView, ok bool, err error) {
+	node, key, err = s.route(c, string(raw))
+	if err != nil {
+		return
+	}
+
+	v, err = node.Get(key)
+	if errors.Is(err, cache.ErrNotFound) {
+		return node, key, v, false, nil
+	}
+
+	return node, key, v, err == nil, err
+}
+
+func (s *Server) ping(c *client, args [][]byte) {
+	if len(args) > 2 {
+		c.w.error("ERR wrong number of arguments for 'ping' command")
+		return
+	}
+	if len(args) == 2 {
+		c.w.bulk(args[1])
+		return
+	}
+	c.w.simple("PONG")
+}
+
+func (s *Server) echo(c *client, args [][]byte) {
+	c.w.bulk(args[1])
+}
+
+func (s *Server) quit(c *client, args [][]byte) {
+	c.quit = true
+	c.w.simple("OK")
+}
+
+// SELECT takes an index into the databases, or the name of a namespace
+func (s *Server) selectDB(c *client, args [][]byte) {
+	target := string(args[1])
+	if i, err := strconv.Atoi(target); err == nil {
+		dbs := s.databases()
+		if i < 0 || i >= len(dbs) {
+			c.w.error("ERR DB index is out of range")
+			return
+		}
+		target = dbs[i]
+	}
+
+	if _, err := cache.GetNode(s.graph, target); err != nil {
+		c.w.error("ERR no such namespace '" + target + "'")
+		return
+	}
+
+	c.db = target
+	c.w.simple("OK")
+}
+
+func (s *Server) hello(c *client, args [][]byte) {
+	if len(args) > 1 {
+		version, err := strconv.Atoi(string(args[1]))
+		if err != nil || version < 2 || version > 3 {
+			c.w.error("NOPROTO unsupported protocol version")
+			return
+		}
+		c.w.proto = version
+
+		for i := 2; i < len(args); i++ {
+			switch strings.ToUpper(string(args[i])) {
+			case "AUTH":
+				// there are no users, any credentials are fine
+				i += 2
+			case "SETNAME":
+				if i+1 < len(args) {
+					c.name = string(args[i+1])
+				}
+				i += 1
+			}
+		}
+	}
+
+	c.w.mapLen(7)
+	c.w.bulkString("server")
+	c.w.bulkString("efis")
+	c.w.bulkString("version")
+	c.w.bulkString(redisVersion)
+	c.w.bulkString("proto")
+	c.w.int(int64(c.w.proto))
+	c.w.bulkString("id")
+	c.w.int(c.id)
+	c.w.bulkString("mode")
+	c.w.bulkString("standalone")
+	c.w.bulkString("role")
+	c.w.bulkString("master")
+	c.w.bulkString("modules")
+	c.w.array(0)
+}
+
+func (s *Server) get(c *client, args [][]byte) {
+	_, _, v, ok, err := s.lookup(c, args[1])
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+	if !ok {
+		c.w.null()
+		return
+	}
+	c.w.bulk(v.ByteSlice())
+}
+
+// a key which fails to load is a nil, like a key of another type in redis
+func (s *Server) mget(c *client, args [][]byte) {
+	c.w.array(len(args) - 1)
+	for _, raw := range args[1:] {
+		_, _, v, ok, err := s.lookup(c, raw)
+		if err != nil || !ok {
+			c.w.null()
+			continue
+		}
+		c.w.bulk(v.ByteSlice())
+	}
+}
+
+// SET key value [EX seconds | PX milliseconds] [NX | XX]
+func (s *Server) set(c *client, args [][]byte) {
+	var ttl time.Duration
+	var nx, xx bool
+	for i := 3; i < len(args); i++ {
+		switch opt := strings.ToUpper(string(args[i])); opt {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "EX", "PX":
+			if i+1 >= len(args) || ttl != 0 {
+				c.w.error("ERR syntax error")
+				return
+			}
+			n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil || n <= 0 {
+				c.w.error("ERR invalid expire time in 'set' command")
+				return
+			}
+			unit := time.Second
+			if opt == "PX" {
+				unit = time.Millisecond
+			}
+			ttl = time.Duration(n) * unit
+			i += 1
+		default:
+			c.w.error("ERR syntax error")
+			return
+		}
+	}
+	if nx && xx {
+		c.w.error("ERR syntax error")
+		return
+	}
+
+	node, key, err := s.route(c, string(args[1]))
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	if nx || xx {
+		_, _, _, ok, err := s.lookup(c, args[1])
+		if err != nil {
+			s.fail(c, err)
+			return
+		}
+		if (nx && ok) || (xx && !ok) {
+			c.w.null()
+			return
+		}
+	}
+
+	if err := node.Set(key, args[2], ttl); err != nil {
+		s.fail(c, err)
+		return
+	}
+	c.w.simple("OK")
+}
+
+// keys are counted if they could be read before
+func (s *Server) del(c *client, args [][]byte) {
+	var n int64
+	for _, raw := range args[1:] {
+		node, key, _, ok, err := s.lookup(c, raw)
+		if err != nil && node == nil {
+			s.fail(c, err)
+			return
+		}
+		if !ok {
+			continue
+		}
+		if err := node.Delete(key); err != nil {
+			s.fail(c, err)
+			return
+		}
+		n += 1
+	}
+	c.w.int(n)
+}
+
+func (s *Server) exists(c *client, args [][]byte) {
+	var n int64
+	for _, raw := range args[1:] {
+		if _, _, _, ok, _ := s.lookup(c, raw); ok {
+			n += 1
+		}
+	}
+	c.w.int(n)
+}
+
+// EXPIRE key seconds, PEXPIRE key milliseconds, the value and its tags are stored again with the new ttl
+func (s *Server) expire(c *client, args [][]byte) {
+	n, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		c.w.error("ERR value is not an integer or out of range")
+		return
+	}
+	unit := time.Second
+	if strings.EqualFold(string(args[0]), "PEXPIRE") {
+		unit = time.Millisecond
+	}
+
+	node, key, v, ok, err := s.lookup(c, args[1])
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+	if !ok {
+		c.w.int(0)
+		return
+	}
+
+	if n <= 0 {
+		err = node.Delete(key)
+	} else {
+		err = node.Set(key, v.ByteSlice(), time.Duration(n)*unit, node.Tags(key)...)
+	}
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+	c.w.int(1)
+}
+
+// -2 if the key does not exist, -1 if it never expires
+func (s *Server) ttl(c *client, args [][]byte) {
+	_, _, v, ok, err := s.lookup(c, args[1])
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+	if !ok {
+		c.w.int(-2)
+		return
+	}
+	if v.Expire().IsZero() {
+		c.w.int(-1)
+		return
+	}
+
+	left := max(time.Until(v.Expire()), 0)
+	if strings.EqualFold(string(args[0]), "PTTL") {
+		c.w.int(left.Milliseconds())
+		return
+	}
+	c.w.int(int64((left + 500*time.Millisecond) / time.Second))
+}
+
+// only the keys cached on this server
+func (s *Server) dbsize(c *client, args [][]byte) {
+	node, err := cache.GetNode(s.graph, c.db)
+	if err != nil {
+		c.w.error("ERR no such namespace '" + c.db + "'")
+		return
+	}
+	c.w.int(int64(len(node.Keys())))
+}
+
+func (s *Server) info(c *client, args [][]byte) {
+	section := "all"
+	if len(args) > 1 {
+		section = strings.ToLower(string(args[1]))
+	}
+	want := func(name string) bool {
+		return section == "all" || section == "default" || section == "everything" || section == name
+	}
+
+	var b strings.Builder
+	if want("server") {
+		fmt.Fprintf(&b, "# Server\r\nredis_version:%s\r\nredis_mode:standalone\r\nserver_name:efis\r\nuptime_in_seconds:%d\r\n\r\n",
+			redisVersion, int64(time.Since(s.started).Seconds()))
+	}
+	if want("clients") {
+		fmt.Fprintf(&b, "# Clients\r\nconnected_clients:%v\r\n\r\n", s.stats.Snapshot()["connected_clients"])
+	}
+	if want("stats") {
+		b.WriteString("# Stats\r\n")
+		snapshot := s.stats.Snapshot()
+		names := make([]string, 0, len(snapshot))
+		for name := range snapshot {
+			if name != "connected_clients" {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(&b, "%s:%v\r\n", name, snapshot[name])
+		}
+		b.WriteString("\r\n")
+	}
+	if want("keyspace") {
+		b.WriteString("# Keyspace\r\n")
+		for i, db := range s.databases() {
+			node, err := cache.GetNode(s.graph, db)
+			if err != nil {
+				continue
+			}
+			if keys := len(node.Keys()); keys > 0 {
+				fmt.Fprintf(&b, "db%d:keys=%d,expires=0,avg_ttl=0,namespace=%s\r\n", i, keys, db)
+			}
+		}
+		b.WriteString("\r\n")
+	}
+
+	c.w.bulkString(b.String())
+}
+
+// there is nothing to configure, CONFIG GET answers empty so that tools like redis-benchmark go on
+func (s *Server) config(c *client, args [][]byte) {
+	if strings.EqualFold(string(args[1]), "GET") {
+		c.w.mapLen(0)
+		return
+	}
+	c.w.error("ERR CONFIG " + strings.ToUpper(string(args[1])) + " is not supported")
+}
+
+func (s *Server) command(c *client, args [][]byte) {
+	if len(args) > 1 && strings.EqualFold(string(args[1]), "COUNT") {
+		c.w.int(int64(len(commands)))
+		return
+	}
+	if len(args) > 1 && strings.EqualFold(string(args[1]), "DOCS") {
+		c.w.mapLen(0)
+		return
+	}
+	c.w.array(0)
+}
+
+func (s *Server) client(c *client, args [][]byte) {
+	switch strings.ToUpper(string(args[1])) {
+	case "SETNAME":
+		if len(args) != 3 {
+			c.w.error("ERR wrong number of arguments for 'client|setname' command")
+			return
+		}
+		c.name = string(args[2])
+		c.w.simple("OK")
+	case "GETNAME":
+		if c.name == "" {
+			c.w.null()
+			return
+		}
+		c.w.bulkString(c.name)
+	case "SETINFO":
+		c.w.simple("OK")
+	default:
+		c.w.error("ERR CLIENT " + strings.ToUpper(string(args[1])) + " is not supported")
+	}
+}
@@ -0,0 +1,208 @@
+package resp
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golrice/e-fis/internal/cache"
+	"github.com/golrice/e-fis/internal/stats"
+)
+
+// the version we pretend to be, clients check it before using newer commands
+const redisVersion = "7.0.0"
+
+var ErrServerClosed = errors.New("resp: server closed")
+
+type Options struct {
+	// the namespaces SELECT 0, 1, ... switches to, a connection starts in the first one
+	// all nodes of the graph, sorted by name, if empty
+	Databases []string
+}
+
+// server speaks a subset of the redis protocol and maps it onto the nodes of a graph
+// a key is looked up in the selected namespace, unless it starts with "<namespace>:"
+type Server struct {
+	graph   *cache.Graph
+	opts    Options
+	stats   *stats.Registry
+	started time.Time
+	nextID  atomic.Int64
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+func NewServer(graph *cache.Graph, opts Options) *Server {
+	s := &Server{
+		graph:   graph,
+		opts:    opts,
+		stats:   stats.New(),
+		started: time.Now(),
+		conns:   make(map[net.Conn]struct{}),
+	}
+	s.stats.Gauge("connected_clients", func() any {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.conns)
+	})
+
+	return s
+}
+
+func (s *Server) Stats() map[string]any {
+	return s.stats.Snapshot()
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(l)
+}
+
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listener = l
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		s.stats.Inc("total_connections_received")
+		go s.serveConn(conn)
+	}
+}
+
+// close stops listening and drops all connections
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+
+	return err
+}
+
+// the state of a connection
+type client struct {
+	id   int64
+	db   string
+	name string
+	w    *writer
+	quit bool
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		s.wg.Done()
+	}()
+
+	r := newReader(conn)
+	c := &client{id: s.nextID.Add(1), w: newWriter(conn)}
+	if dbs := s.databases(); len(dbs) > 0 {
+		c.db = dbs[0]
+	}
+
+	for !c.quit {
+		args, err := r.command()
+		if err != nil {
+			if errors.Is(err, errProtocol) {
+				c.w.error("ERR " + err.Error())
+				c.w.flush()
+			} else if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				log.Printf("[RESP %s] %s", conn.RemoteAddr(), err.Error())
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		s.stats.Inc("total_commands_processed")
+		s.dispatch(c, args)
+
+		// answer a pipeline in one go
+		if r.buffered() == 0 || c.quit {
+			if err := c.w.flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) databases() []string {
+	if len(s.opts.Databases) > 0 {
+		return s.opts.Databases
+	}
+
+	nodes := s.graph.Nodes()
+	names := make([]string, len(nodes))
+	for i, node := range nodes {
+		names[i] = node.Name()
+	}
+
+	return names
+}
+
+// route finds the node of a key, a known namespace prefix wins over the selected one
+func (s *Server) route(c *client, key string) (*cache.Node, string, error) {
+	if i := strings.IndexByte(key, ':'); i > 0 {
+		if node, err := cache.GetNode(s.graph, key[:i]); err == nil {
+			return node, key[i+1:], nil
+		}
+	}
+
+	node, err := cache.GetNode(s.graph, c.db)
+	if err != nil {
+		return nil, "", errors.New("ERR no such namespace '" + c.db + "'")
+	}
+
+	return node, key, nil
+}
@@ -0,0 +1,210 @@
+package resp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golrice/e-fis/internal/cache"
+)
+
+type conn struct {
+	t     *testing.T
+	c     net.Conn
+	r     *bufio.Reader
+	graph *cache.Graph
+}
+
+func startServer(t *testing.T) *conn {
+	t.Helper()
+
+	graph := cache.DefaultGraph()
+	notFound := func(key string) ([]byte, error) { return nil, fmt.Errorf("%s: %w", key, cache.ErrNotFound) }
+	graph.AddNode(cache.NewNode("kv", 2<<10, notFound))
+	graph.AddNode(cache.NewNode("scores", 2<<10, func(key string) ([]byte, error) {
+		if key == "Tom" {
+			return []byte("630"), nil
+		}
+		return notFound(key)
+	}))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(graph, Options{Databases: []string{"kv", "scores"}})
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &conn{t: t, c: c, r: bufio.NewReader(c), graph: graph}
+}
+
+func (c *conn) send(args ...string) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := c.c.Write([]byte(b.String())); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// reply reads one reply and renders it in a compact form, e.g. [a,(nil)] for an array
+func (c *conn) reply() string {
+	c.c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	line = strings.TrimRight(line, "\r\n")
+
+	switch line[0] {
+	case '$':
+		if line == "$-1" {
+			return "(nil)"
+		}
+		var n int
+		fmt.Sscanf(line[1:], "%d", &n)
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, b); err != nil {
+			c.t.Fatal(err)
+		}
+		return string(b[:n])
+	case '_':
+		return "(nil)"
+	case '*', '%':
+		var n int
+		fmt.Sscanf(line[1:], "%d", &n)
+		if line[0] == '%' {
+			n *= 2
+		}
+		items := make([]string, n)
+		for i := range items {
+			items[i] = c.reply()
+		}
+		return "[" + strings.Join(items, ",") + "]"
+	}
+
+	return line
+}
+
+func (c *conn) do(args ...string) string {
+	c.send(args...)
+	return c.reply()
+}
+
+func (c *conn) expect(want string, args ...string) {
+	c.t.Helper()
+	if got := c.do(args...); got != want {
+		c.t.Fatalf("%v: got %q, want %q", args, got, want)
+	}
+}
+
+func TestServer_Commands(t *testing.T) {
+	c := startServer(t)
+
+	c.expect("+PONG", "PING")
+	c.expect("hi", "PING", "hi")
+	c.expect("(nil)", "GET", "a")
+	c.expect("+OK", "SET", "a", "1")
+	c.expect("1", "GET", "a")
+	c.expect("(nil)", "SET", "a", "2", "NX")
+	c.expect("+OK", "SET", "a", "2", "XX")
+	c.expect("(nil)", "SET", "b", "2", "XX")
+	c.expect("[2,(nil)]", "MGET", "a", "b")
+	c.expect(":1", "EXISTS", "a", "b")
+	c.expect(":1", "DEL", "a", "b")
+	c.expect(":0", "EXISTS", "a")
+
+	// the getter of the namespace answers misses
+	c.expect("(nil)", "GET", "Tom")
+	c.expect("630", "GET", "scores:Tom")
+	c.expect("+OK", "SELECT", "1")
+	c.expect("630", "GET", "Tom")
+	c.expect("+OK", "SELECT", "kv")
+	c.expect("-ERR DB index is out of range", "SELECT", "2")
+
+	c.expect("-ERR unknown command 'NOPE'", "NOPE")
+	c.expect("-ERR wrong number of arguments for 'get' command", "GET")
+	c.expect("-ERR syntax error", "SET", "a", "1", "EX")
+}
+
+func TestServer_Expire(t *testing.T) {
+	c := startServer(t)
+
+	c.expect(":-2", "TTL", "a")
+	c.expect("+OK", "SET", "a", "1")
+	c.expect(":-1", "TTL", "a")
+	c.expect(":1", "EXPIRE", "a", "100")
+	c.expect(":100", "TTL", "a")
+	c.expect(":0", "EXPIRE", "b", "100")
+
+	// the tags stay with the value
+	node, _ := cache.GetNode(c.graph, "kv")
+	node.Set("t", []byte("1"), 0, "red")
+	c.expect(":1", "EXPIRE", "t", "100")
+	if tags := node.Tags("t"); len(tags) != 1 || tags[0] != "red" {
+		t.Fatalf("tags = %v", tags)
+	}
+
+	c.expect("+OK", "SET", "a", "1", "PX", "50")
+	time.Sleep(60 * time.Millisecond)
+	c.expect("(nil)", "GET", "a")
+	c.expect(":-2", "PTTL", "a")
+}
+
+func TestServer_ProtocolDetails(t *testing.T) {
+	c := startServer(t)
+
+	// a pipeline gets all its answers
+	c.send("SET", "a", "1")
+	c.send("GET", "a")
+	c.send("GET", "b")
+	for _, want := range []string{"+OK", "1", "(nil)"} {
+		if got := c.reply(); got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+
+	// inline commands, as typed into telnet
+	c.c.Write([]byte("GET a\r\n"))
+	if got := c.reply(); got != "1" {
+		t.Fatalf("inline: got %q", got)
+	}
+
+	// resp3 has its own null
+	if got := c.do("HELLO", "3"); !strings.Contains(got, "proto,:3") {
+		t.Fatalf("hello: got %q", got)
+	}
+	c.send("GET", "b")
+	line, _ := c.r.ReadString('\n')
+	if line != "_\r\n" {
+		t.Fatalf("resp3 null: got %q", line)
+	}
+
+	if got := c.do("INFO", "keyspace"); !strings.Contains(got, "db0:keys=1") {
+		t.Fatalf("info: got %q", got)
+	}
+
+	c.expect("+OK", "QUIT")
+}
+
+func TestServer_BulkTooLong(t *testing.T) {
+	c := startServer(t)
+
+	// the length alone is refused, before a buffer is allocated for it
+	c.c.Write([]byte(fmt.Sprintf("*3\r\n$3\r\nSET\r\n$1\r\na\r\n$%d\r\n", maxBulkLen+1)))
+	if got := c.reply(); got != "-ERR protocol error: invalid bulk length" {
+		t.Fatalf("got %q", got)
+	}
+}
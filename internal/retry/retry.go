@@ -0,0 +1,29 @@
+package retry
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// only overload and gateway errors are worth another try
+func Temporary(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// the backoff before retry n, a random duration in [0, min(max, base * 2^n)]
+// full jitter, see https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/
+func Backoff(n int, base, max time.Duration) time.Duration {
+	ceil := max
+	if n < 30 {
+		if d := base << n; d > 0 && d < ceil {
+			ceil = d
+		}
+	}
+
+	return time.Duration(rand.Int63n(int64(ceil) + 1))
+}
@@ -0,0 +1,35 @@
+package retry
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTemporary(t *testing.T) {
+	for code, want := range map[int]bool{
+		http.StatusServiceUnavailable:  true,
+		http.StatusTooManyRequests:     true,
+		http.StatusNotFound:            false,
+		http.StatusUnauthorized:        false,
+		http.StatusInternalServerError: false,
+	} {
+		if Temporary(code) != want {
+			t.Fatalf("temporary %d should be %v", code, want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for n := 0; n < 64; n += 1 {
+		want := 100 * time.Millisecond
+		if n < 3 {
+			want = 20 * time.Millisecond << n
+		}
+		for i := 0; i < 100; i += 1 {
+			if d := Backoff(n, 20*time.Millisecond, 100*time.Millisecond); d < 0 || d > want {
+				t.Fatalf("backoff %d is %v, we want at most %v", n, d, want)
+			}
+		}
+	}
+}
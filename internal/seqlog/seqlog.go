@@ -0,0 +1,62 @@
+package seqlog
+
+// a log numbers what is appended to it and keeps the last entries, a reader which fell behind
+// catches up from it, or learns from First that what it missed is gone
+//
+// it is not safe for concurrent use, the owner guards it with its own lock
+type Log[T any] struct {
+	size    int
+	seq     uint64
+	entries []T
+}
+
+// the log keeps at least size entries, trimmed in one go it holds up to twice as many
+func New[T any](size int) *Log[T] {
+	return &Log[T]{size: size}
+}
+
+// the number of the last entry, 0 before the first one
+func (l *Log[T]) Seq() uint64 {
+	return l.seq
+}
+
+// the number of the oldest entry kept, Seq()+1 if there is none
+func (l *Log[T]) First() uint64 {
+	return l.seq + 1 - uint64(len(l.entries))
+}
+
+// append v as entry Seq()+1, it returns its number
+func (l *Log[T]) Append(v T) uint64 {
+	l.seq += 1
+	l.entries = append(l.entries, v)
+	if len(l.entries) > 2*l.size {
+		l.entries = append([]T(nil), l.entries[len(l.entries)-l.size:]...)
+	}
+	return l.seq
+}
+
+// the entries after seq, from First on if some of them are gone, at most n unless n is 0
+// they must not be modified, they are valid until the next append
+func (l *Log[T]) After(seq uint64, n int) []T {
+	first := l.First()
+	start := max(seq+1, first)
+	if start > l.seq {
+		return nil
+	}
+
+	entries := l.entries[start-first:]
+	if n > 0 && len(entries) > n {
+		entries = entries[:n]
+	}
+	return entries
+}
+
+// the entry seq, if it is still kept
+func (l *Log[T]) At(seq uint64) (T, bool) {
+	first := l.First()
+	if seq < first || seq > l.seq {
+		var zero T
+		return zero, false
+	}
+	return l.entries[seq-first], true
+}
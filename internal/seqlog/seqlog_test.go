@@ -0,0 +1,40 @@
+package seqlog
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestLog(t *testing.T) {
+	l := New[int](2)
+	if l.Seq() != 0 || l.First() != 1 || l.After(0, 0) != nil {
+		t.Fatal("an empty log holds nothing")
+	}
+
+	for i := 1; i <= 5; i += 1 {
+		if seq := l.Append(i * 10); seq != uint64(i) {
+			t.Fatalf("want entry %d, got %d", i, seq)
+		}
+	}
+
+	// trimmed to 2 once it held 5
+	if l.Seq() != 5 || l.First() != 4 {
+		t.Fatalf("want entries 4 to 5, got %d to %d", l.First(), l.Seq())
+	}
+	if got := l.After(1, 0); !slices.Equal(got, []int{40, 50}) {
+		t.Fatalf("the gone entries are skipped, got %v", got)
+	}
+	if got := l.After(3, 1); !slices.Equal(got, []int{40}) {
+		t.Fatalf("want at most 1 entry, got %v", got)
+	}
+	if got := l.After(5, 0); got != nil {
+		t.Fatalf("nothing after the last one, got %v", got)
+	}
+
+	if v, ok := l.At(5); !ok || v != 50 {
+		t.Fatalf("want 50, got %v", v)
+	}
+	if _, ok := l.At(3); ok {
+		t.Fatal("entry 3 is gone")
+	}
+}
@@ -0,0 +1,230 @@
+package sim
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/golrice/e-fis/internal/cache/basic"
+	"github.com/golrice/e-fis/internal/cache/fifo"
+	"github.com/golrice/e-fis/internal/cache/lfu"
+	"github.com/golrice/e-fis/internal/cache/lru"
+	"github.com/golrice/e-fis/internal/trace"
+)
+
+const (
+	defaultPoints = 10
+	defaultSize   = 1
+	// the smallest capacity tried, as a fraction of the working set
+	minFraction = 0.01
+)
+
+// a factory builds an empty cache holding at most capacity bytes
+type Factory func(capacity int64) basic.BasicCache
+
+var (
+	mu        sync.RWMutex
+	factories = map[string]Factory{
+		"lru":  func(capacity int64) basic.BasicCache { return lru.New(capacity, nil) },
+		"fifo": func(capacity int64) basic.BasicCache { return fifo.New(capacity, nil) },
+		"lfu":  func(capacity int64) basic.BasicCache { return lfu.New(capacity, nil) },
+	}
+)
+
+// register another policy, e.g. one which is not part of the cache yet
+func Register(name string, f Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	factories[name] = f
+}
+
+func Policies() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+type Options struct {
+	// all registered policies if empty
+	Policies []string
+	// bytes, Points capacities between 1% and 100% of the working set if empty
+	Capacities []int64
+	Points     int
+	// the size of a record which does not know its size, e.g. from a block trace
+	DefaultSize int
+}
+
+// one policy at one capacity
+type Point struct {
+	Policy       string  `json:"policy"`
+	Capacity     int64   `json:"capacity"`
+	Gets         int64   `json:"gets"`
+	Hits         int64   `json:"hits"`
+	Bytes        int64   `json:"bytes"`
+	HitBytes     int64   `json:"hit_bytes"`
+	HitRatio     float64 `json:"hit_ratio"`
+	ByteHitRatio float64 `json:"byte_hit_ratio"`
+}
+
+type Result struct {
+	Records    int `json:"records"`
+	UniqueKeys int `json:"unique_keys"`
+	// bytes needed to keep every key, the capacity at which only cold misses are left
+	WorkingSet int64   `json:"working_set"`
+	Points     []Point `json:"points"`
+}
+
+// the values only need a size
+type value int
+
+func (v value) Len() int { return int(v) }
+
+// run replays the records against every policy at every capacity, the runs are independent
+func Run(records []trace.Record, opts Options) (*Result, error) {
+	if len(records) == 0 {
+		return nil, errors.New("the trace is empty")
+	}
+	if opts.DefaultSize <= 0 {
+		opts.DefaultSize = defaultSize
+	}
+	if opts.Points <= 0 {
+		opts.Points = defaultPoints
+	}
+	if len(opts.Policies) == 0 {
+		opts.Policies = Policies()
+	}
+
+	mu.RLock()
+	builders := make([]Factory, len(opts.Policies))
+	for i, name := range opts.Policies {
+		f, ok := factories[name]
+		if !ok {
+			mu.RUnlock()
+			return nil, fmt.Errorf("unknown policy %s", name)
+		}
+		builders[i] = f
+	}
+	mu.RUnlock()
+
+	result := &Result{Records: len(records)}
+	sizes := make(map[string]int64)
+	for _, r := range records {
+		size := int64(len(r.Key) + sizeOf(r, opts.DefaultSize))
+		if size > sizes[r.Key] {
+			result.WorkingSet += size - sizes[r.Key]
+			sizes[r.Key] = size
+		}
+	}
+	result.UniqueKeys = len(sizes)
+
+	capacities := opts.Capacities
+	if len(capacities) == 0 {
+		capacities = spread(result.WorkingSet, opts.Points)
+	}
+
+	result.Points = make([]Point, len(builders)*len(capacities))
+	var wg sync.WaitGroup
+	for i, build := range builders {
+		for j, capacity := range capacities {
+			p := &result.Points[i*len(capacities)+j]
+			p.Policy, p.Capacity = opts.Policies[i], capacity
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				replay(p, build(p.Capacity), records, opts.DefaultSize)
+			}()
+		}
+	}
+	wg.Wait()
+
+	return result, nil
+}
+
+func sizeOf(r trace.Record, defaultSize int) int {
+	if r.Size > 0 {
+		return r.Size
+	}
+	return defaultSize
+}
+
+// log spaced capacities from 1% up to the whole working set
+func spread(workingSet int64, points int) []int64 {
+	if points == 1 || workingSet <= 1 {
+		return []int64{max(workingSet, 1)}
+	}
+
+	var capacities []int64
+	low := math.Log(max(float64(workingSet)*minFraction, 1))
+	high := math.Log(float64(workingSet))
+	for i := 0; i < points; i += 1 {
+		c := int64(math.Round(math.Exp(low + (high-low)*float64(i)/float64(points-1))))
+		if len(capacities) == 0 || c > capacities[len(capacities)-1] {
+			capacities = append(capacities, c)
+		}
+	}
+
+	return capacities
+}
+
+// a get which misses loads the key, like a node does, a set stores it and a delete drops it
+func replay(p *Point, c basic.BasicCache, records []trace.Record, defaultSize int) {
+	for _, r := range records {
+		size := sizeOf(r, defaultSize)
+
+		switch r.Op {
+		case trace.OpGet:
+			p.Gets += 1
+			p.Bytes += int64(size)
+			if _, ok := c.Get(r.Key); ok {
+				p.Hits += 1
+				p.HitBytes += int64(size)
+				continue
+			}
+			c.Add(r.Key, value(size))
+		case trace.OpSet:
+			c.Add(r.Key, value(size))
+		case trace.OpDelete:
+			c.Delete(r.Key)
+		}
+	}
+
+	if p.Gets > 0 {
+		p.HitRatio = float64(p.Hits) / float64(p.Gets)
+	}
+	if p.Bytes > 0 {
+		p.ByteHitRatio = float64(p.HitBytes) / float64(p.Bytes)
+	}
+}
+
+// one line per policy and capacity, with a header
+func WriteCSV(w io.Writer, points []Point) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"policy", "capacity", "gets", "hits", "hit_ratio", "byte_hit_ratio"})
+	for _, p := range points {
+		cw.Write([]string{
+			p.Policy,
+			strconv.FormatInt(p.Capacity, 10),
+			strconv.FormatInt(p.Gets, 10),
+			strconv.FormatInt(p.Hits, 10),
+			strconv.FormatFloat(p.HitRatio, 'f', 4, 64),
+			strconv.FormatFloat(p.ByteHitRatio, 'f', 4, 64),
+		})
+	}
+	cw.Flush()
+
+	return cw.Error()
+}
@@ -0,0 +1,94 @@
+package sim
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/golrice/e-fis/internal/cache/basic"
+	"github.com/golrice/e-fis/internal/cache/lru"
+	"github.com/golrice/e-fis/internal/trace"
+)
+
+func gets(keys ...string) []trace.Record {
+	records := make([]trace.Record, len(keys))
+	for i, key := range keys {
+		records[i] = trace.Record{Op: trace.OpGet, Key: key, Size: 9}
+	}
+	return records
+}
+
+func TestRun_Capacities(t *testing.T) {
+	// a loop over 3 keys of 10 bytes each, lru only keeps up once all of them fit
+	var keys []string
+	for i := 0; i < 100; i += 1 {
+		keys = append(keys, "a", "b", "c")
+	}
+
+	result, err := Run(gets(keys...), Options{Policies: []string{"lru", "fifo"}, Capacities: []int64{20, 30}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.UniqueKeys != 3 || result.WorkingSet != 30 || len(result.Points) != 4 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+
+	for _, p := range result.Points {
+		switch p.Capacity {
+		case 20:
+			if p.Hits != 0 {
+				t.Fatalf("%s should thrash below the loop, got %+v", p.Policy, p)
+			}
+		case 30:
+			if p.Hits != 297 || p.HitRatio != 0.99 || p.ByteHitRatio != 0.99 {
+				t.Fatalf("%s should only miss cold, got %+v", p.Policy, p)
+			}
+		}
+	}
+}
+
+func TestRun_Writes(t *testing.T) {
+	records := []trace.Record{
+		{Op: trace.OpSet, Key: "a", Size: 1},
+		{Op: trace.OpGet, Key: "a", Size: 1},
+		{Op: trace.OpDelete, Key: "a"},
+		{Op: trace.OpGet, Key: "a", Size: 1},
+	}
+
+	result, err := Run(records, Options{Policies: []string{"lru"}, Points: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := result.Points[0]; p.Gets != 2 || p.Hits != 1 {
+		t.Fatalf("a set should be hit and a delete should miss, got %+v", p)
+	}
+}
+
+func TestRun_Register(t *testing.T) {
+	Register("unlimited", func(capacity int64) basic.BasicCache { return lru.New(0, nil) })
+
+	result, err := Run(gets("a", "b", "a", "b"), Options{Policies: []string{"unlimited"}, Capacities: []int64{1}})
+	if err != nil || result.Points[0].Hits != 2 {
+		t.Fatalf("a registered policy should be used, got %+v, %v", result, err)
+	}
+
+	if _, err := Run(gets("a"), Options{Policies: []string{"nope"}}); err == nil {
+		t.Fatal("an unknown policy should fail")
+	}
+}
+
+func TestSpread(t *testing.T) {
+	capacities := spread(10000, 5)
+	if len(capacities) != 5 || capacities[0] != 100 || capacities[4] != 10000 {
+		t.Fatalf("unexpected capacities %v", capacities)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+	WriteCSV(&buf, []Point{{Policy: "lru", Capacity: 10, Gets: 4, Hits: 1, HitRatio: 0.25, ByteHitRatio: 0.5}})
+
+	want := "policy,capacity,gets,hits,hit_ratio,byte_hit_ratio\nlru,10,4,1,0.2500,0.5000\n"
+	if buf.String() != want {
+		t.Fatalf("want %q, got %q", want, buf.String())
+	}
+}
@@ -0,0 +1,90 @@
+package stats
+
+import (
+	"sort"
+	"sync"
+)
+
+// a registry holds named counters and gauges, it is safe for concurrency
+type Registry struct {
+	mu       sync.RWMutex
+	counters map[string]int64
+	gauges   map[string]func() any
+}
+
+func New() *Registry {
+	return &Registry{
+		mu:       sync.RWMutex{},
+		counters: map[string]int64{},
+		gauges:   map[string]func() any{},
+	}
+}
+
+func (r *Registry) Add(name string, delta int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.counters[name] += delta
+}
+
+func (r *Registry) Inc(name string) {
+	r.Add(name, 1)
+}
+
+func (r *Registry) Counter(name string) int64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.counters[name]
+}
+
+// gauge is evaluated lazily when the snapshot is taken
+func (r *Registry) Gauge(name string, f func() any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if f == nil {
+		delete(r.gauges, name)
+		return
+	}
+	r.gauges[name] = f
+}
+
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.counters)+len(r.gauges))
+	for k := range r.counters {
+		names = append(names, k)
+	}
+	for k := range r.gauges {
+		if _, ok := r.counters[k]; !ok {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// snapshot returns a copy of all values, gauges win over counters with the same name
+func (r *Registry) Snapshot() map[string]any {
+	r.mu.RLock()
+	gauges := make(map[string]func() any, len(r.gauges))
+	out := make(map[string]any, len(r.counters)+len(r.gauges))
+	for k, v := range r.counters {
+		out[k] = v
+	}
+	for k, f := range r.gauges {
+		gauges[k] = f
+	}
+	r.mu.RUnlock()
+
+	// gauges may take their own locks, so call them without holding ours
+	for k, f := range gauges {
+		out[k] = f()
+	}
+
+	return out
+}
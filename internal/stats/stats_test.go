@@ -0,0 +1,48 @@
+package stats
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestRegistry_Counter(t *testing.T) {
+	r := New()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i += 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.Inc("hits")
+		}()
+	}
+	wg.Wait()
+
+	r.Add("hits", -10)
+	if v := r.Counter("hits"); v != 90 {
+		t.Fatalf("we want 90 hits, got %d", v)
+	}
+	if v := r.Counter("misses"); v != 0 {
+		t.Fatalf("unknown counter should be 0, got %d", v)
+	}
+}
+
+func TestRegistry_Snapshot(t *testing.T) {
+	r := New()
+	r.Inc("a")
+	r.Gauge("b", func() any { return "up" })
+
+	want := map[string]any{"a": int64(1), "b": "up"}
+	if got := r.Snapshot(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("we want %v, got %v", want, got)
+	}
+	if got := r.Names(); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Fatalf("unexpected names %v", got)
+	}
+
+	r.Gauge("b", nil)
+	if _, ok := r.Snapshot()["b"]; ok {
+		t.Fatal("gauge b should be removed")
+	}
+}
@@ -0,0 +1,86 @@
+package trace
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// the trace formats ReadFormat knows
+const (
+	FormatEfis = "efis"
+	// "start count ignored request", every line reads count blocks from start on,
+	// the format of the traces published with the ARC paper
+	FormatARC = "arc"
+	// one block number per line, the format of the traces published with the LIRS paper
+	FormatLIRS = "lirs"
+)
+
+// the most blocks a line of an arc trace may read, the requests of the published traces
+// are far shorter, a larger count is a broken line, not one we should expand
+const maxARCCount = 1 << 16
+
+func Formats() []string {
+	return []string{FormatEfis, FormatARC, FormatLIRS}
+}
+
+// read a whole trace, block traces become gets of the block numbers with an unknown size
+func ReadFormat(r io.Reader, format string) ([]Record, error) {
+	switch format {
+	case FormatEfis, "":
+		return ReadAll(r)
+	case FormatARC:
+		return readBlocks(r, parseARC)
+	case FormatLIRS:
+		return readBlocks(r, parseLIRS)
+	}
+	return nil, fmt.Errorf("unknown trace format %q", format)
+}
+
+func parseARC(fields []string) (start, count int64, err error) {
+	if len(fields) < 2 {
+		return 0, 0, fmt.Errorf("want at least 2 fields, got %d", len(fields))
+	}
+	if start, err = strconv.ParseInt(fields[0], 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("bad block %q", fields[0])
+	}
+	if count, err = strconv.ParseInt(fields[1], 10, 64); err != nil || count < 0 || count > maxARCCount {
+		return 0, 0, fmt.Errorf("bad count %q", fields[1])
+	}
+	return start, count, nil
+}
+
+func parseLIRS(fields []string) (start, count int64, err error) {
+	// some lirs traces end with a line of *
+	if fields[0] == "*" {
+		return 0, 0, nil
+	}
+	if start, err = strconv.ParseInt(fields[0], 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("bad block %q", fields[0])
+	}
+	return start, 1, nil
+}
+
+func readBlocks(r io.Reader, parse func(fields []string) (int64, int64, error)) ([]Record, error) {
+	var records []Record
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n += 1 {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		start, count, err := parse(fields)
+		if err != nil {
+			return records, fmt.Errorf("line %d: %w", n, err)
+		}
+		for block := start; block < start+count; block += 1 {
+			records = append(records, Record{Op: OpGet, Key: strconv.FormatInt(block, 10)})
+		}
+	}
+
+	return records, scanner.Err()
+}
@@ -0,0 +1,274 @@
+package trace
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golrice/e-fis/internal/keyhash"
+)
+
+const (
+	defaultMaxBytes   = 64 << 20
+	defaultMaxFiles   = 5
+	defaultSampleRate = 0.1
+	flushInterval     = time.Second
+)
+
+type RecorderOptions struct {
+	// the file records are appended to, rotated files get .1, .2, ... appended, .1 is the newest
+	Path string `json:"path"`
+	// the file is rotated once it is larger, 64MB by default
+	MaxBytes int64 `json:"max_bytes"`
+	// rotated files kept, 5 by default
+	MaxFiles int `json:"max_files"`
+	// the fraction of the keys which are recorded, 0.1 by default
+	// a key is recorded on every access or never, so per key patterns stay intact
+	SampleRate float64 `json:"sample_rate"`
+}
+
+func (o *RecorderOptions) fill() {
+	if o.MaxBytes <= 0 {
+		o.MaxBytes = defaultMaxBytes
+	}
+	if o.MaxFiles <= 0 {
+		o.MaxFiles = defaultMaxFiles
+	}
+	if o.SampleRate <= 0 || o.SampleRate > 1 {
+		o.SampleRate = defaultSampleRate
+	}
+}
+
+type RecorderStatus struct {
+	Enabled bool `json:"enabled"`
+	RecorderOptions
+	Recorded  int64  `json:"recorded"`
+	Rotations int64  `json:"rotations"`
+	Errors    int64  `json:"errors"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// recorder appends sampled accesses to a rotating trace file, it is off until Start
+// the keys are hashed, a trace never holds a key of the users
+type Recorder struct {
+	enabled atomic.Bool
+	// a key is recorded if its hash is at most threshold
+	threshold atomic.Uint64
+
+	mu        sync.Mutex
+	opts      RecorderOptions
+	file      *os.File
+	w         *Writer
+	written   int64
+	recorded  int64
+	rotations int64
+	errors    int64
+	lastErr   error
+	stop      chan struct{}
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// start recording to opts.Path, a running recording is stopped first
+func (r *Recorder) Start(opts RecorderOptions) error {
+	if opts.Path == "" {
+		return errors.New("the trace needs a path")
+	}
+	opts.fill()
+
+	r.Stop()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.opts = opts
+	if err := r.openLocked(); err != nil {
+		return err
+	}
+
+	threshold := uint64(math.MaxUint64)
+	if opts.SampleRate < 1 {
+		threshold = uint64(opts.SampleRate * math.MaxUint64)
+	}
+	r.threshold.Store(threshold)
+	r.stop = make(chan struct{})
+	go r.flushLoop(r.stop)
+	r.enabled.Store(true)
+
+	log.Printf("[Trace] recording %.2f%% of the keys to %s", opts.SampleRate*100, opts.Path)
+	return nil
+}
+
+// stop flushes and closes the trace, it is harmless if nothing is recorded
+func (r *Recorder) Stop() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.enabled.Load() {
+		return nil
+	}
+	r.enabled.Store(false)
+	close(r.stop)
+
+	log.Printf("[Trace] stopped recording to %s", r.opts.Path)
+	return r.closeLocked()
+}
+
+func (r *Recorder) Enabled() bool {
+	return r.enabled.Load()
+}
+
+func (r *Recorder) Status() RecorderStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	status := RecorderStatus{
+		Enabled:         r.enabled.Load(),
+		RecorderOptions: r.opts,
+		Recorded:        r.recorded,
+		Rotations:       r.rotations,
+		Errors:          r.errors,
+	}
+	if r.lastErr != nil {
+		status.LastError = r.lastErr.Error()
+	}
+	return status
+}
+
+// record an access if the key is sampled, it is cheap while the recorder is off
+func (r *Recorder) Record(op Op, namespace, key string, size int, result string) {
+	if r == nil || !r.enabled.Load() {
+		return
+	}
+
+	h := keyhash.Sum(key)
+	if h > r.threshold.Load() {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// stopped in the meantime
+	if r.w == nil {
+		return
+	}
+
+	err := r.w.Write(Record{
+		Time:      time.Now(),
+		Op:        op,
+		Namespace: namespace,
+		Key:       fmt.Sprintf("%016x", h),
+		Size:      size,
+		Result:    result,
+	})
+	if err != nil {
+		r.failLocked(err)
+		return
+	}
+	r.recorded += 1
+
+	if r.written+int64(r.w.w.Buffered()) >= r.opts.MaxBytes {
+		if err := r.rotateLocked(); err != nil {
+			r.failLocked(err)
+		}
+	}
+}
+
+func (r *Recorder) failLocked(err error) {
+	r.errors += 1
+	if r.lastErr == nil || r.lastErr.Error() != err.Error() {
+		log.Printf("[Trace] fail to record: %s", err.Error())
+	}
+	r.lastErr = err
+}
+
+func (r *Recorder) flushLoop(stop chan struct{}) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.mu.Lock()
+			if r.w != nil {
+				if err := r.w.Flush(); err != nil {
+					r.failLocked(err)
+				}
+			}
+			r.mu.Unlock()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// counts what reaches the file, with what is buffered it tells when to rotate
+type countingWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (c countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	*c.n += int64(n)
+	return n, err
+}
+
+func (r *Recorder) openLocked() error {
+	f, err := os.OpenFile(r.opts.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	r.file = f
+	r.written = info.Size()
+	r.w = NewWriter(countingWriter{w: f, n: &r.written})
+	return nil
+}
+
+func (r *Recorder) closeLocked() error {
+	if r.file == nil {
+		return nil
+	}
+
+	err := r.w.Flush()
+	if cerr := r.file.Close(); err == nil {
+		err = cerr
+	}
+	r.file, r.w = nil, nil
+
+	return err
+}
+
+// path.1 becomes path.2 and so on, the oldest file is dropped
+func (r *Recorder) rotateLocked() error {
+	if err := r.closeLocked(); err != nil {
+		return err
+	}
+
+	path := r.opts.Path
+	os.Remove(fmt.Sprintf("%s.%d", path, r.opts.MaxFiles))
+	for i := r.opts.MaxFiles - 1; i >= 1; i -= 1 {
+		os.Rename(fmt.Sprintf("%s.%d", path, i), fmt.Sprintf("%s.%d", path, i+1))
+	}
+	if err := os.Rename(path, path+".1"); err != nil {
+		return err
+	}
+	r.rotations += 1
+
+	return r.openLocked()
+}
@@ -0,0 +1,111 @@
+package trace
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readTrace(t *testing.T, path string) []Record {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	records, err := ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestRecorder_Sampling(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace")
+	r := NewRecorder()
+
+	// off, nothing is written
+	r.Record(OpGet, "kv", "a", 1, ResultHit)
+	if err := r.Start(RecorderOptions{Path: path, SampleRate: 0.25}); err != nil {
+		t.Fatal(err)
+	}
+
+	// every key is either recorded on every access or never
+	for round := 0; round < 3; round += 1 {
+		for i := 0; i < 1000; i += 1 {
+			r.Record(OpGet, "kv", fmt.Sprint("key", i), 10, ResultLocal)
+		}
+	}
+	if err := r.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	r.Record(OpGet, "kv", "a", 1, ResultHit)
+
+	counts := map[string]int{}
+	for _, rec := range readTrace(t, path) {
+		if rec.Namespace != "kv" || rec.Size != 10 || rec.Result != ResultLocal || len(rec.Key) != 16 {
+			t.Fatalf("unexpected record %v", rec)
+		}
+		counts[rec.Key] += 1
+	}
+	for key, n := range counts {
+		if n != 3 {
+			t.Fatalf("key %s was recorded %d times, want 3", key, n)
+		}
+	}
+	if len(counts) < 200 || len(counts) > 300 {
+		t.Fatalf("want about 250 of 1000 keys sampled, got %d", len(counts))
+	}
+	if s := r.Status(); s.Enabled || s.Recorded != int64(3*len(counts)) {
+		t.Fatalf("unexpected status %+v", s)
+	}
+}
+
+func TestRecorder_SequentialKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace")
+	r := NewRecorder()
+	if err := r.Start(RecorderOptions{Path: path, SampleRate: 0.1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// keys which only differ in their last bytes are sampled at the rate, not all or none of them
+	for i := 0; i < 10000; i += 1 {
+		r.Record(OpGet, "kv", fmt.Sprint("user:", i), 10, ResultLocal)
+	}
+	if err := r.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(readTrace(t, path)); n < 900 || n > 1100 {
+		t.Fatalf("want about 1000 of 10000 keys sampled, got %d", n)
+	}
+}
+
+func TestRecorder_Rotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace")
+	r := NewRecorder()
+	if err := r.Start(RecorderOptions{Path: path, SampleRate: 1, MaxBytes: 200, MaxFiles: 2}); err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+
+	for i := 0; i < 100; i += 1 {
+		r.Record(OpSet, "kv", fmt.Sprint(i), 1, "")
+	}
+
+	if s := r.Status(); s.Rotations < 3 || s.Errors != 0 {
+		t.Fatalf("unexpected status %+v", s)
+	}
+	if _, err := os.Stat(path + ".2"); err != nil {
+		t.Fatal("the older file should be kept")
+	}
+	if _, err := os.Stat(path + ".3"); err == nil {
+		t.Fatal("only MaxFiles rotated files should be kept")
+	}
+	if records := readTrace(t, path+".1"); len(records) == 0 {
+		t.Fatal("a rotated file should hold records")
+	}
+}
@@ -0,0 +1,181 @@
+package trace
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// a trace is text, one access per line
+//
+//	<unix nanos> <op> <namespace> <key> <size> [<result>]
+//
+// keys and namespaces are path escaped, so they never hold a space
+// blank lines and lines starting with # are skipped
+const Header = "# efis trace v1: time op namespace key size [result]"
+
+type Op string
+
+const (
+	OpGet    Op = "get"
+	OpSet    Op = "set"
+	OpDelete Op = "del"
+)
+
+// where a get was answered from, empty if it is not known
+const (
+	ResultHit   = "hit"
+	ResultPeer  = "peer"
+	ResultLocal = "local"
+	ResultMiss  = "miss"
+)
+
+type Record struct {
+	Time      time.Time
+	Op        Op
+	Namespace string
+	Key       string
+	// bytes of the value, 0 if it is not known
+	Size   int
+	Result string
+}
+
+func (r Record) String() string {
+	ns := r.Namespace
+	if ns == "" {
+		ns = "-"
+	}
+
+	line := fmt.Sprintf("%d %s %s %s %d", r.Time.UnixNano(), r.Op, url.PathEscape(ns), url.PathEscape(r.Key), r.Size)
+	if r.Result != "" {
+		line += " " + r.Result
+	}
+	return line
+}
+
+func Parse(line string) (Record, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 5 && len(fields) != 6 {
+		return Record{}, fmt.Errorf("want 5 or 6 fields, got %d", len(fields))
+	}
+
+	nanos, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return Record{}, fmt.Errorf("bad time %q", fields[0])
+	}
+
+	op := Op(fields[1])
+	switch op {
+	case OpGet, OpSet, OpDelete:
+	default:
+		return Record{}, fmt.Errorf("unknown op %q", fields[1])
+	}
+
+	ns, err := url.PathUnescape(fields[2])
+	if err != nil {
+		return Record{}, fmt.Errorf("bad namespace %q", fields[2])
+	}
+	if ns == "-" {
+		ns = ""
+	}
+
+	key, err := url.PathUnescape(fields[3])
+	if err != nil {
+		return Record{}, fmt.Errorf("bad key %q", fields[3])
+	}
+
+	size, err := strconv.Atoi(fields[4])
+	if err != nil || size < 0 {
+		return Record{}, fmt.Errorf("bad size %q", fields[4])
+	}
+
+	r := Record{Time: time.Unix(0, nanos), Op: op, Namespace: ns, Key: key, Size: size}
+	if len(fields) == 6 {
+		r.Result = fields[5]
+	}
+
+	return r, nil
+}
+
+type Reader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func NewReader(r io.Reader) *Reader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+
+	return &Reader{scanner: scanner}
+}
+
+// read returns the next record, io.EOF at the end of the trace
+func (r *Reader) Read() (Record, error) {
+	for r.scanner.Scan() {
+		r.line += 1
+
+		line := strings.TrimSpace(r.scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rec, err := Parse(line)
+		if err != nil {
+			return Record{}, fmt.Errorf("line %d: %w", r.line, err)
+		}
+		return rec, nil
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		return Record{}, err
+	}
+	return Record{}, io.EOF
+}
+
+// read all records, e.g. to replay them more than once
+func ReadAll(r io.Reader) ([]Record, error) {
+	reader := NewReader(r)
+
+	var records []Record
+	for {
+		rec, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, rec)
+	}
+}
+
+// writer buffers the records, call Flush when done
+type Writer struct {
+	w      *bufio.Writer
+	header bool
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+func (w *Writer) Write(r Record) error {
+	if !w.header {
+		w.header = true
+		if _, err := w.w.WriteString(Header + "\n"); err != nil {
+			return err
+		}
+	}
+
+	_, err := w.w.WriteString(r.String() + "\n")
+	return err
+}
+
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
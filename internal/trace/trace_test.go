@@ -0,0 +1,80 @@
+package trace
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrace_RoundTrip(t *testing.T) {
+	records := []Record{
+		{Time: time.Unix(1, 5), Op: OpGet, Namespace: "scores", Key: "Tom", Size: 3, Result: ResultHit},
+		{Time: time.Unix(2, 0), Op: OpSet, Namespace: "kv", Key: "a key/with spaces", Size: 10},
+		{Time: time.Unix(3, 0), Op: OpDelete, Key: "b"},
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	for _, r := range records {
+		if err := w.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Flush()
+
+	if !strings.HasPrefix(buf.String(), Header+"\n") {
+		t.Fatalf("the trace should start with the header, got %q", buf.String())
+	}
+
+	got, err := ReadAll(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(records) {
+		t.Fatalf("want %d records, got %d", len(records), len(got))
+	}
+	for i := range records {
+		if !got[i].Time.Equal(records[i].Time) || got[i].Op != records[i].Op || got[i].Namespace != records[i].Namespace ||
+			got[i].Key != records[i].Key || got[i].Size != records[i].Size || got[i].Result != records[i].Result {
+			t.Fatalf("record %d: want %v, got %v", i, records[i], got[i])
+		}
+	}
+}
+
+func TestTrace_BadLines(t *testing.T) {
+	for _, line := range []string{
+		"1 get kv",
+		"x get kv a 1",
+		"1 put kv a 1",
+		"1 get kv a -1",
+	} {
+		if _, err := Parse(line); err == nil {
+			t.Fatalf("%q should not parse", line)
+		}
+	}
+
+	_, err := ReadAll(strings.NewReader("# comment\n\n1 get kv a 1\n1 get kv\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 4") {
+		t.Fatalf("the error should name the line, got %v", err)
+	}
+}
+
+func TestReadFormat(t *testing.T) {
+	arc, err := ReadFormat(strings.NewReader("10 3 0 1\n20 1 0 2\n"), FormatARC)
+	if err != nil || len(arc) != 4 || arc[2].Key != "12" || arc[3].Key != "20" {
+		t.Fatalf("unexpected arc records %v, %v", arc, err)
+	}
+
+	lirs, err := ReadFormat(strings.NewReader("5\n7\n5\n*\n"), FormatLIRS)
+	if err != nil || len(lirs) != 3 || lirs[2].Key != "5" {
+		t.Fatalf("unexpected lirs records %v, %v", lirs, err)
+	}
+
+	if _, err := ReadFormat(strings.NewReader("x\n"), FormatLIRS); err == nil {
+		t.Fatal("a bad block should fail")
+	}
+	if _, err := ReadFormat(strings.NewReader("1 9223372036854775806 0 1\n"), FormatARC); err == nil {
+		t.Fatal("a huge count should fail")
+	}
+}
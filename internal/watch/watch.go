@@ -0,0 +1,263 @@
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golrice/e-fis/internal/seqlog"
+)
+
+// a hub streams the changes of the local caches to subscribers, e.g. clients which keep a cache
+// of their own coherent
+//
+// every event gets the next sequence number of the hub and stays in a log, its token tells a
+// subscriber where to resume, it gets what it missed from the log. a subscriber which fell out of
+// the log, or whose token is from before a restart, gets a KindReset first and starts over
+//
+// the events are those of this server only, a set is seen at the owner of the key, with the bus
+// every other member which cached the key sees it as a delete
+const (
+	defaultLogSize = 16384
+	defaultBuffer  = 1024
+)
+
+var ErrBadToken = errors.New("bad token")
+
+type Kind string
+
+const (
+	KindSet    Kind = "set"
+	KindDelete Kind = "delete"
+	KindExpire Kind = "expire"
+	KindEvict  Kind = "evict"
+	// every key of the namespace is stale, the generation was bumped, Key is empty
+	KindFlush Kind = "flush"
+	// never published, events were lost since the token, anything the subscriber holds may be stale
+	KindReset Kind = "reset"
+	// nothing changed, it only carries the token, so that a filtered subscriber keeps up
+	KindProgress Kind = "progress"
+)
+
+type Event struct {
+	Seq uint64
+	// resume after this event with it
+	Token     string
+	Time      time.Time
+	Namespace string
+	Kind      Kind
+	Key       string
+	// of a set, zero if the value never expires
+	Expire time.Time
+}
+
+// an empty namespace matches every namespace, the prefix applies to the keys
+type Filter struct {
+	Namespace string
+	Prefix    string
+}
+
+func (f Filter) match(ev Event) bool {
+	if f.Namespace != "" && ev.Namespace != f.Namespace {
+		return false
+	}
+	// a flush drops every key
+	return ev.Kind == KindFlush || strings.HasPrefix(ev.Key, f.Prefix)
+}
+
+type Options struct {
+	// events kept for subscribers which resume, 16384 by default
+	LogSize int
+	// events a subscriber may fall behind, it is dropped then and resumes with its token
+	Buffer int
+}
+
+func (o *Options) fill() {
+	if o.LogSize <= 0 {
+		o.LogSize = defaultLogSize
+	}
+	if o.Buffer <= 0 {
+		o.Buffer = defaultBuffer
+	}
+}
+
+type Status struct {
+	Epoch       int64  `json:"epoch"`
+	Seq         uint64 `json:"seq"`
+	Subscribers int    `json:"subscribers"`
+	// subscribers which fell behind and were dropped
+	Dropped int64 `json:"dropped"`
+}
+
+type Hub struct {
+	epoch int64
+	opts  Options
+
+	mu      sync.Mutex
+	log     *seqlog.Log[Event]
+	subs    map[*Subscription]struct{}
+	dropped int64
+
+	now func() time.Time
+}
+
+func New(opts Options) *Hub {
+	opts.fill()
+
+	return &Hub{
+		epoch: time.Now().UnixNano(),
+		opts:  opts,
+		log:   seqlog.New[Event](opts.LogSize),
+		subs:  make(map[*Subscription]struct{}),
+		now:   time.Now,
+	}
+}
+
+type Subscription struct {
+	// closed when the subscription is, or when the subscriber fell behind
+	C <-chan Event
+
+	c      chan Event
+	hub    *Hub
+	filter Filter
+	lost   bool
+}
+
+// the events matching f after the token, an empty token starts from now
+// the first event is a KindReset if the events after the token are gone, the last of the
+// replayed events is a KindProgress with the current token
+func (h *Hub) Subscribe(f Filter, after string) (*Subscription, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var replay []Event
+	if after != "" {
+		epoch, seq, err := parseToken(after)
+		if err != nil {
+			return nil, err
+		}
+
+		if epoch != h.epoch || seq > h.log.Seq() || seq+1 < h.log.First() {
+			replay = append(replay, h.markLocked(KindReset))
+		} else {
+			for _, ev := range h.log.After(seq, 0) {
+				if f.match(ev) {
+					replay = append(replay, ev)
+				}
+			}
+		}
+	}
+	replay = append(replay, h.markLocked(KindProgress))
+
+	c := make(chan Event, h.opts.Buffer+len(replay))
+	for _, ev := range replay {
+		c <- ev
+	}
+	s := &Subscription{C: c, c: c, hub: h, filter: f}
+	h.subs[s] = struct{}{}
+
+	return s, nil
+}
+
+// an event which is not logged, at the current position
+// must be called with h.mu held
+func (h *Hub) markLocked(kind Kind) Event {
+	seq := h.log.Seq()
+	return Event{Seq: seq, Token: h.token(seq), Time: h.now(), Kind: kind}
+}
+
+func (h *Hub) token(seq uint64) string {
+	return fmt.Sprintf("%d-%d", h.epoch, seq)
+}
+
+func parseToken(token string) (int64, uint64, error) {
+	e, s, ok := strings.Cut(token, "-")
+	if !ok {
+		return 0, 0, ErrBadToken
+	}
+	epoch, err := strconv.ParseInt(e, 10, 64)
+	if err != nil {
+		return 0, 0, ErrBadToken
+	}
+	seq, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, 0, ErrBadToken
+	}
+	return epoch, seq, nil
+}
+
+// publish hands the event to every subscriber whose filter matches, it never blocks
+func (h *Hub) Publish(ev Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ev.Seq = h.log.Seq() + 1
+	ev.Token = h.token(ev.Seq)
+	if ev.Time.IsZero() {
+		ev.Time = h.now()
+	}
+	h.log.Append(ev)
+
+	for s := range h.subs {
+		if !s.filter.match(ev) {
+			continue
+		}
+		select {
+		case s.c <- ev:
+		default:
+			// it resumes from the last event it read
+			s.lost = true
+			h.dropped += 1
+			h.closeLocked(s)
+		}
+	}
+}
+
+// sends the current token once the subscriber read everything before it, so that a filtered
+// subscriber resumes from there and not from its last matching event
+func (s *Subscription) Progress() {
+	h := s.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subs[s]; !ok {
+		return
+	}
+	select {
+	case s.c <- h.markLocked(KindProgress):
+	default:
+	}
+}
+
+// whether the subscription was closed because the subscriber fell behind
+func (s *Subscription) Lost() bool {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	return s.lost
+}
+
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	s.hub.closeLocked(s)
+}
+
+// must be called with h.mu held
+func (h *Hub) closeLocked(s *Subscription) {
+	if _, ok := h.subs[s]; ok {
+		delete(h.subs, s)
+		close(s.c)
+	}
+}
+
+func (h *Hub) Status() Status {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return Status{Epoch: h.epoch, Seq: h.log.Seq(), Subscribers: len(h.subs), Dropped: h.dropped}
+}
@@ -0,0 +1,134 @@
+package watch
+
+import (
+	"fmt"
+	"testing"
+)
+
+func publish(h *Hub, namespace string, keys ...string) {
+	for _, key := range keys {
+		h.Publish(Event{Namespace: namespace, Kind: KindSet, Key: key})
+	}
+}
+
+// the events which are waiting, a reset is * and a flush !, the token of the last event is returned too
+func drain(s *Subscription) (string, string) {
+	got, token := "", ""
+	for {
+		select {
+		case ev, ok := <-s.C:
+			if !ok {
+				return got + "closed", token
+			}
+			token = ev.Token
+			switch ev.Kind {
+			case KindReset:
+				got += "* "
+			case KindFlush:
+				got += "! "
+			case KindProgress:
+			default:
+				got += ev.Key + " "
+			}
+		default:
+			return got, token
+		}
+	}
+}
+
+func TestHub_Filter(t *testing.T) {
+	h := New(Options{})
+	s, err := h.Subscribe(Filter{Namespace: "users", Prefix: "u1:"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	publish(h, "users", "u1:a", "u2:a", "u1:b")
+	publish(h, "items", "u1:c")
+	h.Publish(Event{Namespace: "users", Kind: KindFlush})
+
+	if got, _ := drain(s); got != "u1:a u1:b ! " {
+		t.Fatalf("got %q", got)
+	}
+	if h.Status().Subscribers != 1 {
+		t.Fatal("want one subscriber")
+	}
+	s.Close()
+	if got, _ := drain(s); got != "closed" || h.Status().Subscribers != 0 {
+		t.Fatal("the subscription should be closed")
+	}
+}
+
+func TestHub_Resume(t *testing.T) {
+	h := New(Options{LogSize: 4})
+	s, _ := h.Subscribe(Filter{}, "")
+	publish(h, "users", "a", "b")
+	_, token := drain(s)
+	s.Close()
+
+	publish(h, "users", "c", "d")
+	s, err := h.Subscribe(Filter{}, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, token := drain(s)
+	if got != "c d " {
+		t.Fatalf("want the missed events, got %q", got)
+	}
+	s.Close()
+
+	// the subscriber only wants what does not change, the progress keeps its token current
+	s, _ = h.Subscribe(Filter{Prefix: "z"}, token)
+	for i := 0; i < 10; i += 1 {
+		publish(h, "users", fmt.Sprint("k", i))
+	}
+	s.Progress()
+	_, token = drain(s)
+	s.Close()
+	publish(h, "users", "z")
+	s, _ = h.Subscribe(Filter{Prefix: "z"}, token)
+	if got, _ := drain(s); got != "z " {
+		t.Fatalf("want no reset after a progress, got %q", got)
+	}
+	s.Close()
+}
+
+func TestHub_Reset(t *testing.T) {
+	h := New(Options{LogSize: 2})
+	s, _ := h.Subscribe(Filter{}, "")
+	_, token := drain(s)
+	s.Close()
+
+	publish(h, "users", "a", "b", "c", "d", "e", "f")
+	s, _ = h.Subscribe(Filter{}, token)
+	got, token := drain(s)
+	s.Close()
+	if got != "* " {
+		t.Fatalf("want a reset after falling out of the log, got %q", got)
+	}
+
+	// a token of another hub, e.g. before a restart
+	s, _ = New(Options{}).Subscribe(Filter{}, token)
+	if got, _ := drain(s); got != "* " {
+		t.Fatalf("want a reset for a foreign token, got %q", got)
+	}
+
+	if _, err := h.Subscribe(Filter{}, "nope"); err != ErrBadToken {
+		t.Fatalf("want ErrBadToken, got %v", err)
+	}
+}
+
+func TestHub_SlowSubscriber(t *testing.T) {
+	h := New(Options{Buffer: 2})
+	s, _ := h.Subscribe(Filter{}, "")
+
+	// the progress and two events fit
+	publish(h, "users", "a", "b", "c")
+	if got, _ := drain(s); got != "a b closed" || !s.Lost() {
+		t.Fatalf("a subscriber which falls behind should be dropped, got %q", got)
+	}
+	if h.Status().Dropped != 1 {
+		t.Fatal("the drop should be counted")
+	}
+}